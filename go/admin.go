@@ -0,0 +1,132 @@
+package _go
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"sort"
+	"time"
+)
+
+//go:embed admin
+var adminFiles embed.FS
+
+// Lifetime of the URLs made by POST /sign.
+const (
+	DefaultSignTTL = time.Hour
+	MaxSignTTL     = 7 * 24 * time.Hour
+)
+
+// WithAdminUI serves a web admin page at /admin/ to list and upload bases, preview the
+// channel info of apks and make signed download links. The page only uses the JSON
+// API of the server with the API key entered by the user, so the page itself is
+// served without authentication.
+func WithAdminUI() func(*Server) {
+	return func(s *Server) {
+		s.adminUI = true
+	}
+}
+
+// adminHandler serves the embedded admin page.
+func adminHandler() http.Handler {
+	sub, err := fs.Sub(adminFiles, "admin")
+	if err != nil {
+		panic(err)
+	}
+	files := http.StripPrefix("/admin/", http.FileServer(http.FS(sub)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+		h.Set("X-Content-Type-Options", "nosniff")
+		files.ServeHTTP(w, r)
+	})
+}
+
+// ChannelSet is the response of GET /channels, Any is true if every channel is allowed.
+type ChannelSet struct {
+	Any      bool     `json:"any"`
+	Channels []string `json:"channels"`
+}
+
+// serveChannels lists the channel allowlist of the tenant.
+func (s *Server) serveChannels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	t := s.tenant(TenantFromContext(r.Context()))
+	s.mu.RLock()
+	set := ChannelSet{Any: t.channels == nil, Channels: make([]string, 0, len(t.channels))}
+	for ch := range t.channels {
+		set.Channels = append(set.Channels, ch)
+	}
+	s.mu.RUnlock()
+	sort.Strings(set.Channels)
+	writeJSON(w, http.StatusOK, set)
+}
+
+// SignRequest is the body of POST /sign, TTL is in seconds, 0 means DefaultSignTTL.
+type SignRequest struct {
+	Base    string            `json:"base"`
+	Channel string            `json:"channel"`
+	Extras  map[string]string `json:"extras"`
+	TTL     int64             `json:"ttl"`
+}
+
+// SignResponse is the response of POST /sign, URL is a path and query on the server.
+type SignResponse struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+// serveSign makes a signed download URL for a base and channel of the tenant, it is
+// only available with WithURLSigning and WithAuth, since anyone could download
+// otherwise.
+func (s *Server) serveSign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if s.secret == nil {
+		writeError(w, newErrf("URL signing is not enabled: %w", ErrNotFound))
+		return
+	}
+	var req SignRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, newErrf("%s: %w", err, ErrInvalid))
+		return
+	}
+	ttl := time.Duration(req.TTL) * time.Second
+	switch {
+	case req.TTL == 0:
+		ttl = DefaultSignTTL
+	case req.TTL < 0 || ttl > MaxSignTTL:
+		writeError(w, newErrf("ttl must be between 1 and %d seconds: %w", int64(MaxSignTTL/time.Second), ErrInvalid))
+		return
+	}
+	for k := range req.Extras {
+		if reservedExtra(k) {
+			writeError(w, newErrf("extra %q is reserved: %w", k, ErrInvalid))
+			return
+		}
+	}
+	tenant := TenantFromContext(r.Context())
+	if req.Channel == "" || !s.allowed(tenant, req.Channel) {
+		writeError(w, newErrf("channel %q: %w", req.Channel, ErrNotFound))
+		return
+	}
+	base, ok := s.base(tenant, req.Base)
+	if !ok {
+		writeError(w, newErrf("base %q: %w", req.Base, ErrNotFound))
+		return
+	}
+	base.release()
+	expires := time.Now().Add(ttl)
+	writeJSON(w, http.StatusOK, SignResponse{
+		URL:     SignTenantURL(s.secret, tenant, req.Base, req.Channel, req.Extras, expires),
+		Expires: expires.UTC().Truncate(time.Second),
+	})
+}
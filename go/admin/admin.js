@@ -0,0 +1,214 @@
+"use strict";
+
+// The admin page only talks to the JSON API of the server it is served from, the API
+// paths are resolved against the page so the server may be mounted under a prefix.
+const api = (path) => new URL(".." + path, location.href);
+
+const $ = (id) => document.getElementById(id);
+
+function status(msg, isError) {
+  const el = $("status");
+  el.textContent = msg;
+  el.className = isError ? "error" : "";
+}
+
+function headers() {
+  const h = {};
+  const key = sessionStorage.getItem("walle-key");
+  if (key) {
+    h["Authorization"] = "Bearer " + key;
+  }
+  return h;
+}
+
+async function call(method, path, body, contentType) {
+  const h = headers();
+  if (contentType) {
+    h["Content-Type"] = contentType;
+  }
+  const resp = await fetch(api(path), { method, headers: h, body });
+  let data = null;
+  if ((resp.headers.get("Content-Type") || "").startsWith("application/json")) {
+    data = await resp.json();
+  }
+  if (!resp.ok) {
+    const err = new Error((data && data.error) || resp.status + " " + resp.statusText);
+    err.status = resp.status;
+    throw err;
+  }
+  return data;
+}
+
+function cell(row, text, className) {
+  const td = document.createElement("td");
+  td.textContent = text;
+  if (className) {
+    td.className = className;
+  }
+  row.appendChild(td);
+}
+
+function option(list, value) {
+  const o = document.createElement("option");
+  o.value = value;
+  list.appendChild(o);
+}
+
+function formatSize(n) {
+  const units = ["B", "KB", "MB", "GB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return n.toFixed(i ? 1 : 0) + " " + units[i];
+}
+
+async function loadBases() {
+  const body = $("bases").tBodies[0];
+  const names = $("base-names");
+  body.replaceChildren();
+  names.replaceChildren();
+  let bases;
+  try {
+    bases = await call("GET", "/bases");
+  } catch (e) {
+    status(e.status === 404 ? "The server has no base registry." : "Listing bases: " + e.message, e.status !== 404);
+    return;
+  }
+  for (const b of bases) {
+    option(names, b.name);
+    const aliases = Object.entries(b.aliases || {});
+    for (const [alias] of aliases) {
+      option(names, b.name + "@" + alias);
+    }
+    for (const v of b.versions) {
+      option(names, b.name + "@" + v.version);
+      const row = body.insertRow();
+      cell(row, b.name);
+      cell(row, v.version);
+      cell(row, formatSize(v.size));
+      cell(row, v.sha256.slice(0, 16), "digest");
+      cell(row, new Date(v.uploaded).toLocaleString());
+      cell(row, aliases.filter(([, to]) => to === v.version).map(([a]) => a).join(", "));
+    }
+  }
+}
+
+async function loadChannels() {
+  const el = $("channels");
+  const names = $("channel-names");
+  names.replaceChildren();
+  try {
+    const set = await call("GET", "/channels");
+    set.channels.forEach((ch) => option(names, ch));
+    el.textContent = set.any ? "Any channel is allowed." : set.channels.join(", ") || "No channel is allowed.";
+  } catch (e) {
+    el.textContent = "Listing channels: " + e.message;
+  }
+}
+
+function refresh() {
+  status("");
+  loadBases();
+  loadChannels();
+}
+
+function parseExtras(text) {
+  const extras = {};
+  for (const line of text.split("\n")) {
+    const t = line.trim();
+    if (!t) {
+      continue;
+    }
+    const i = t.indexOf("=");
+    if (i <= 0) {
+      throw new Error("extras must be key=value: " + t);
+    }
+    extras[t.slice(0, i).trim()] = t.slice(i + 1).trim();
+  }
+  return extras;
+}
+
+function showInspection(ins) {
+  const dl = $("inspection");
+  dl.replaceChildren();
+  const add = (term, value) => {
+    const dt = document.createElement("dt");
+    dt.textContent = term;
+    const dd = document.createElement("dd");
+    dd.textContent = value;
+    dl.append(dt, dd);
+  };
+  add("Size", formatSize(ins.size));
+  add("Channel", ins.channel || "(none)");
+  add("Extras", Object.entries(ins.extras || {}).map(([k, v]) => k + "=" + v).join(", ") || "(none)");
+  add("Schemes", (ins.schemes || []).join(", ") || "(none)");
+  add("Blocks", (ins.entries || []).map((e) => (e.name || e.id) + " (" + e.size + " B)").join(", "));
+  add("Anomalies", (ins.anomalies || []).join("; ") || "(none)");
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+  $("key").value = sessionStorage.getItem("walle-key") || "";
+  $("key-form").addEventListener("submit", (ev) => {
+    ev.preventDefault();
+    sessionStorage.setItem("walle-key", $("key").value.trim());
+    refresh();
+  });
+  $("refresh").addEventListener("click", refresh);
+
+  $("upload-form").addEventListener("submit", async (ev) => {
+    ev.preventDefault();
+    const f = ev.target;
+    const base = f.base.value.trim();
+    const version = f.version.value.trim();
+    status("Uploading " + base + "@" + version + "…");
+    try {
+      await call("PUT", "/bases/" + encodeURIComponent(base) + "/" + encodeURIComponent(version),
+        f.apk.files[0], "application/vnd.android.package-archive");
+      status("Uploaded " + base + "@" + version + ".");
+      f.reset();
+      loadBases();
+    } catch (e) {
+      status("Upload: " + e.message, true);
+    }
+  });
+
+  $("inspect-form").addEventListener("submit", async (ev) => {
+    ev.preventDefault();
+    status("Inspecting…");
+    try {
+      showInspection(await call("POST", "/inspect", ev.target.apk.files[0], "application/vnd.android.package-archive"));
+      status("");
+    } catch (e) {
+      status("Preview: " + e.message, true);
+    }
+  });
+
+  $("sign-form").addEventListener("submit", async (ev) => {
+    ev.preventDefault();
+    const f = ev.target;
+    const link = $("signed");
+    link.hidden = true;
+    $("signed-expires").textContent = "";
+    try {
+      const req = {
+        base: f.base.value.trim(),
+        channel: f.channel.value.trim(),
+        extras: parseExtras(f.extras.value),
+        ttl: Math.round(Number(f.hours.value || 1) * 3600),
+      };
+      const res = await call("POST", "/sign", JSON.stringify(req), "application/json");
+      const url = api(res.url).href;
+      link.href = url;
+      link.textContent = url;
+      link.hidden = false;
+      $("signed-expires").textContent = "expires " + new Date(res.expires).toLocaleString();
+      status("");
+    } catch (e) {
+      status("Sign: " + e.message, true);
+    }
+  });
+
+  refresh();
+});
@@ -0,0 +1,118 @@
+package _go
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_WithAdminUI(t *testing.T) {
+	keysPath := filepath.Join(t.TempDir(), "keys.json")
+	writeTestKeys(t, keysPath, `[{"key": "k", "tenant": "team", "scopes": ["read", "download"]}]`, time.Now().Add(-time.Hour))
+	ks, err := LoadKeyStore(keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("s3cret")
+	s := NewServer(WithAdminUI(), WithAuth(ks), WithURLSigning(secret), WithTenantChannels("team", "xiaomi", "huawei"))
+	base := newTestApk(t, t.TempDir())
+	if err := s.RegisterTenant("team", "demo", base); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	get := func(path string) (int, string, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		if !strings.HasPrefix(path, "/apk/") {
+			req.Header.Set("Authorization", "Bearer k")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get("Content-Type"), b
+	}
+	for _, path := range []string{"/admin/", "/admin/admin.js", "/admin/admin.css"} {
+		if code, _, b := get(path); code != http.StatusOK || len(b) == 0 {
+			t.Errorf("GET %s got status %d, %d bytes", path, code, len(b))
+		}
+	}
+
+	code, _, b := get("/channels")
+	var set ChannelSet
+	if err := json.Unmarshal(b, &set); err != nil || code != http.StatusOK {
+		t.Fatalf("GET /channels got status %d, %s", code, b)
+	}
+	if set.Any || strings.Join(set.Channels, ",") != "huawei,xiaomi" {
+		t.Errorf("GET /channels got %+v", set)
+	}
+
+	sign := func(body string) (int, SignResponse) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/sign", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer k")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var res SignResponse
+		_ = json.NewDecoder(resp.Body).Decode(&res)
+		return resp.StatusCode, res
+	}
+	code, res := sign(`{"base": "demo", "channel": "xiaomi", "extras": {"k": "v"}, "ttl": 600}`)
+	if code != http.StatusOK {
+		t.Fatalf("POST /sign got status %d", code)
+	}
+	if code, _, got := get(res.URL); code != http.StatusOK || string(got) != string(testChannelApk(t, base, "xiaomi", map[string]string{"k": "v"})) {
+		t.Errorf("GET signed URL got status %d, %d bytes", code, len(got))
+	}
+	// the admin page always sends extras
+	if _, res := sign(`{"base": "demo", "channel": "xiaomi", "extras": {}}`); res.URL == "" {
+		t.Errorf("POST /sign with empty extras failed")
+	} else if code, _, _ := get(res.URL); code != http.StatusOK {
+		t.Errorf("GET signed URL with empty extras got status %d", code)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"unknown base", `{"base": "nope", "channel": "xiaomi"}`, http.StatusNotFound},
+		{"channel not allowed", `{"base": "demo", "channel": "oppo"}`, http.StatusNotFound},
+		{"ttl too long", `{"base": "demo", "channel": "xiaomi", "ttl": 99999999}`, http.StatusBadRequest},
+		{"bad json", `{`, http.StatusBadRequest},
+		{"reserved extra", `{"base": "demo", "channel": "xiaomi", "extras": {"sig": "x"}}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, _ := sign(tt.body); code != tt.wantStatus {
+				t.Errorf("POST /sign got status %d, want %d", code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestServer_signWithoutAuth(t *testing.T) {
+	s, _ := newTestServer(t, WithAdminUI(), WithURLSigning([]byte("s3cret")))
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	resp, err := http.Post(srv.URL+"/sign", "application/json", strings.NewReader(`{"base": "demo", "channel": "xiaomi"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("POST /sign without auth got status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,160 @@
+package _go
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// AlignmentIssue is a stored entry whose data is not aligned.
+type AlignmentIssue struct {
+	Name string `json:"name"`
+	// Offset is the offset of the data of the entry.
+	Offset int64 `json:"offset"`
+	// Alignment is the alignment the data must have, 4096 for .so files to be mapped
+	// from the apk and 4 for the others.
+	Alignment int64 `json:"alignment"`
+}
+
+func (i AlignmentIssue) String() string {
+	return fmt.Sprintf("%s at %d is not %d-byte aligned", i.Name, i.Offset, i.Alignment)
+}
+
+// AlignmentFix is the result of Align.
+type AlignmentFix struct {
+	// Fixed are the entries that are not aligned in the input apk.
+	Fixed []AlignmentIssue `json:"fixed"`
+	// BrokenSignatures are the signature schemes of the input apk that no longer verify
+	// in the output one, such as "v2" and "v3", since they sign the offsets of the
+	// entries. The output must be signed again then, e.g. with SignApk which keeps the
+	// channel. JAR signatures are still valid.
+	BrokenSignatures []string `json:"brokenSignatures,omitempty"`
+}
+
+// CheckAlignment checks the alignment of the apk at path, see CheckAlignmentAt.
+func CheckAlignment(path string) ([]AlignmentIssue, error) {
+	ret, err := openFile(path, func(f *os.File) (interface{}, error) {
+		size, err := fileSize(f)
+		if err != nil {
+			return nil, err
+		}
+		return CheckAlignmentAt(f, size)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret.([]AlignmentIssue), nil
+}
+
+// CheckAlignmentAt walks the local file headers of the apk in r and returns the stored
+// entries whose data is not aligned as zipalign -c -p 4 checks: .so files must be
+// 4096-byte aligned and the others 4-byte aligned. Compressed entries and directories
+// need no alignment.
+func CheckAlignmentAt(r io.ReaderAt, size int64) ([]AlignmentIssue, error) {
+	z, err := newUnsignedZipSectionsAt(r, size)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readZipEntries(&z)
+	if err != nil {
+		return nil, err
+	}
+	issues := []AlignmentIssue{}
+	for _, e := range entries {
+		if !e.aligned() {
+			continue
+		}
+		_, _, _, dataOffset, err := e.readLocalHeader(r)
+		if err != nil {
+			return nil, err
+		}
+		if alignment := zipAlignment(e.name); dataOffset%alignment != 0 {
+			issues = append(issues, AlignmentIssue{Name: e.name, Offset: dataOffset, Alignment: alignment})
+		}
+	}
+	return issues, nil
+}
+
+// Align writes the apk at input to output with every stored entry aligned as
+// zipalign -p 4 does, by an alignment extra field in the local file header as
+// apksigner does. Entries that are aligned already are copied as they are, but gaps
+// between them are dropped, so an aligned apk without gaps is copied byte for byte.
+// The APK Signing Block is kept, but its signatures are reported in BrokenSignatures
+// if any entry or the block is moved.
+func Align(input, output string) (*AlignmentFix, error) {
+	if sameFile(input, output) {
+		return nil, errors.New("output must not be the input apk")
+	}
+	ret, err := openFile(input, func(in *os.File) (interface{}, error) {
+		size, err := fileSize(in)
+		if err != nil {
+			return nil, err
+		}
+		out, err := os.Create(output)
+		if err != nil {
+			return nil, err
+		}
+		fix, err := align(in, size, out)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(output)
+		}
+		return fix, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret.(*AlignmentFix), nil
+}
+
+func align(r io.ReaderAt, size int64, w io.Writer) (*AlignmentFix, error) {
+	issues, err := CheckAlignmentAt(r, size)
+	if err != nil {
+		return nil, err
+	}
+	z, err := newUnsignedZipSectionsAt(r, size)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readZipEntries(&z)
+	if err != nil {
+		return nil, err
+	}
+	zw := &zipRewriter{w: w}
+	var moved bool
+	for _, e := range entries {
+		// a gap before e or entries out of order move it as well
+		moved = moved || zw.off != e.localOffset
+		changed, err := zw.copyEntry(r, e)
+		if err != nil {
+			return nil, err
+		}
+		moved = moved || changed
+	}
+	moved = moved || zw.off != z.signingBlockOffset
+	if err := zw.close(z.signingBlock, z.eocd); err != nil {
+		return nil, err
+	}
+
+	fix := &AlignmentFix{Fixed: issues}
+	if moved && z.signingBlock != nil {
+		schemes := map[uint32]string{
+			APK_SIGNATURE_SCHEME_V2_BLOCK_ID:  "v2",
+			APK_SIGNATURE_SCHEME_V3_BLOCK_ID:  "v3",
+			APK_SIGNATURE_SCHEME_V31_BLOCK_ID: "v3.1",
+		}
+		err := forEachIdValue(z.signingBlock, func(id uint32, value []byte) bool {
+			if scheme, ok := schemes[id]; ok {
+				fix.BrokenSignatures = append(fix.BrokenSignatures, scheme)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fix, nil
+}
@@ -0,0 +1,188 @@
+package _go
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testUnalignedZip makes a zip whose stored entries are not aligned, as zip.Writer
+// does not align them.
+func testUnalignedZip(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range []struct {
+		name   string
+		method uint16
+	}{
+		{"AndroidManifest.xml", zip.Deflate},
+		{"resources.arsc", zip.Store},
+		{"lib/arm64-v8a/libwalle.so", zip.Store},
+		{"res/", zip.Store},
+		{"assets/a.txt", zip.Store},
+	} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: e.name, Method: e.method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasSuffix(e.name, "/") {
+			continue
+		}
+		if _, err := w.Write(bytes.Repeat([]byte(e.name), 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAlign(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := []*x509.Certificate{testCertOf(t, key.Public(), key)}
+	unaligned := testUnalignedZip(t)
+	var signed bytes.Buffer
+	if err := Sign(bytes.NewReader(unaligned), int64(len(unaligned)), &signed, key, certs, WithV3Signing()); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	if err := os.WriteFile(path("base.apk"), signed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewApk(path("base.apk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.PutChannel("huawei", path("channel.apk")); err != nil {
+		t.Fatal(err)
+	}
+
+	issues, err := CheckAlignment(path("channel.apk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(issues); len(issues) != 3 || issues[1].Alignment != 4096 {
+		t.Fatalf("got issues %s", got)
+	}
+
+	fix, err := Align(path("channel.apk"), path("aligned.apk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(fix.Fixed) != fmt.Sprint(issues) || fmt.Sprint(fix.BrokenSignatures) != "[v2 v3]" {
+		t.Errorf("got fix %+v", fix)
+	}
+	if issues, err := CheckAlignment(path("aligned.apk")); err != nil || len(issues) > 0 {
+		t.Errorf("aligned apk has issues %s, %v", issues, err)
+	}
+	if v, err := VerifyFile(path("aligned.apk")); err != nil || v.Verified {
+		t.Errorf("aligned apk verifies, %v", err)
+	}
+	zr, err := zip.OpenReader(path("aligned.apk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got bytes.Buffer
+		_, err = got.ReadFrom(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := bytes.Repeat([]byte(f.Name), 10); !strings.HasSuffix(f.Name, "/") && !bytes.Equal(got.Bytes(), want) {
+			t.Errorf("%s = %q, want %q", f.Name, got.Bytes(), want)
+		}
+	}
+	zr.Close()
+
+	// signed again, the apk verifies and keeps the channel
+	if err := SignApk(path("aligned.apk"), path("signed.apk"), key, certs, WithV3Signing()); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := VerifyFile(path("signed.apk")); err != nil || !v.Verified {
+		t.Errorf("signed apk does not verify, %v", err)
+	}
+	if a, err := NewApk(path("signed.apk")); err != nil || a.Channel() != "huawei" {
+		t.Errorf("channel is lost, %v", err)
+	}
+
+	// an aligned apk is copied as it is
+	fix, err = Align(path("signed.apk"), path("copy.apk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fix.Fixed) > 0 || len(fix.BrokenSignatures) > 0 {
+		t.Errorf("got fix %+v of aligned apk", fix)
+	}
+	want, _ := os.ReadFile(path("signed.apk"))
+	if got, _ := os.ReadFile(path("copy.apk")); !bytes.Equal(got, want) {
+		t.Errorf("aligned apk is changed")
+	}
+	if _, err := Align(path("signed.apk"), path("signed.apk")); err == nil {
+		t.Errorf("apk is aligned in place")
+	}
+}
+
+func TestAlign_gap(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := []*x509.Certificate{testCertOf(t, key.Public(), key)}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("AndroidManifest.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(bytes.Repeat([]byte("manifest"), 10))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// 4 bytes of gap before the central directory
+	b := buf.Bytes()
+	eocd := b[len(b)-_ZIP_EOCD_REC_MIN_SIZE:]
+	cdOffset := getEocdCentralDirectoryOffset(eocd)
+	gapped := append(append(append([]byte{}, b[:cdOffset]...), 0, 0, 0, 0), b[cdOffset:]...)
+	setEocdCentralDirectoryOffset(gapped[len(gapped)-_ZIP_EOCD_REC_MIN_SIZE:], cdOffset+4)
+
+	var signed bytes.Buffer
+	if err := Sign(bytes.NewReader(gapped), int64(len(gapped)), &signed, key, certs); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	input, output := filepath.Join(dir, "gap.apk"), filepath.Join(dir, "aligned.apk")
+	if err := os.WriteFile(input, signed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := VerifyFile(input); err != nil || !v.Verified {
+		t.Fatalf("input apk does not verify, %v", err)
+	}
+	fix, err := Align(input, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fix.Fixed) > 0 || fmt.Sprint(fix.BrokenSignatures) != "[v2]" {
+		t.Errorf("got fix %+v", fix)
+	}
+	if v, err := VerifyFile(output); err != nil || v.Verified {
+		t.Errorf("aligned apk verifies, %v", err)
+	}
+}
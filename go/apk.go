@@ -1,7 +1,10 @@
 package _go
 
 import (
+	"crypto"
+	"crypto/x509"
 	"errors"
+	"io"
 	"os"
 	"path/filepath"
 )
@@ -10,6 +13,68 @@ import (
 type apk struct {
 	path string
 	info channelInfo
+	// src and size are set instead of path when the apk is not a local file.
+	src  io.ReaderAt
+	size int64
+
+	opts      []func(*apk)
+	selfCheck bool
+	verify    bool
+	// v4Key and v4Certs sign the generated apks with APK Signature Scheme v4.
+	v4Key   crypto.PrivateKey
+	v4Certs []*x509.Certificate
+	// assets are the entries to add to or replace in the apk of a channel, which is
+	// re-signed by assetsKey and assetsCerts then.
+	assets       func(channel string, extras map[string]string) (map[string][]byte, error)
+	assetsKey    crypto.PrivateKey
+	assetsCerts  []*x509.Certificate
+	assetsMinSdk int
+	// file is read instead of opening path, it is closed by whoever opened it.
+	file *os.File
+}
+
+// WithSelfCheck makes every generated apk be re-read and compared with the base apk,
+// an output that does not pass the check is removed.
+func WithSelfCheck() func(*apk) {
+	return func(a *apk) {
+		a.selfCheck = true
+	}
+}
+
+// WithVerify makes the signatures of every generated apk be verified, see Verify. An
+// output that does not verify is removed.
+func WithVerify() func(*apk) {
+	return func(a *apk) {
+		a.verify = true
+	}
+}
+
+// WithV4Signing makes every generated apk be signed with APK Signature Scheme v4 by
+// key, whose signature is written next to the apk with the suffix .idsig, see
+// SignApkV4. key and certs must be of a v2 or v3 signer of the base apk.
+func WithV4Signing(key crypto.PrivateKey, certs []*x509.Certificate) func(*apk) {
+	return func(a *apk) {
+		a.v4Key = key
+		a.v4Certs = certs
+	}
+}
+
+// WithAssets makes every generated apk have the entries returned by assets for its
+// channel and extras, such as assets/partner.json, added or replaced, for partners that
+// read files of the apk rather than the channel in the APK Signing Block. Since the
+// entries are signed, the old signatures are dropped and the apk is re-signed with
+// JAR signature, APK Signature Scheme v2 and v3 by key, see Sign. certs is the
+// certificate chain of key, the first one is of key. minSdkVersion is the one of the
+// apk, the JAR signature uses SHA-1 for Android versions below 4.3 (5.0 for DSA keys)
+// as apksigner does, and EC keys require 18 at least. The channel and extras are
+// still written to the APK Signing Block.
+func WithAssets(key crypto.PrivateKey, certs []*x509.Certificate, minSdkVersion int, assets func(channel string, extras map[string]string) (map[string][]byte, error)) func(*apk) {
+	return func(a *apk) {
+		a.assets = assets
+		a.assetsKey = key
+		a.assetsCerts = certs
+		a.assetsMinSdk = minSdkVersion
+	}
 }
 
 func (a *apk) Path() string {
@@ -34,7 +99,7 @@ func (a *apk) All() map[string]string {
 	return res
 }
 
-func NewApk(path string) (*apk, error) {
+func NewApk(path string, opts ...func(*apk)) (*apk, error) {
 	if path == "" {
 		return nil, errors.New("path is empty string")
 	}
@@ -45,10 +110,38 @@ func NewApk(path string) (*apk, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &apk{
+	a := &apk{
 		path: path,
 		info: info,
-	}, nil
+		opts: opts,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a, nil
+}
+
+// NewApkFromReaderAt reads the apk from r, such as a bytes.Reader or a range reader of
+// object storage. Path of the returned apk is empty, so an output path is required to
+// generate new apks from it.
+func NewApkFromReaderAt(r io.ReaderAt, size int64, opts ...func(*apk)) (*apk, error) {
+	if r == nil {
+		return nil, errors.New("reader is nil")
+	}
+	info, err := readInfoAt(r, size)
+	if err != nil {
+		return nil, err
+	}
+	a := &apk{
+		info: info,
+		src:  r,
+		size: size,
+		opts: opts,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a, nil
 }
 
 func (a *apk) PutChannel(ch, newPath string) (*apk, error) {
@@ -95,17 +188,39 @@ func (a *apk) BatchChannelsWithExtra(chs []string, extra map[string]string) ([]*
 }
 
 func (a *apk) generate(out string, channels []string, extras map[string]string) ([]*apk, error) {
-	z, err := newZipSections(a.path)
+	z, closer, err := a.open()
 	if err != nil {
 		return nil, newErrf("Error occurred on parsing apk %s, %s", a.path, err)
 	}
+	defer closer.Close()
+	if a.path == "" && out == "" && len(channels) > 0 {
+		return nil, errors.New("output path is required for apk without path")
+	}
+
+	var signer *apkSigner
+	if a.assets != nil {
+		if signer, err = newApkSigner(a.assetsKey, a.assetsCerts); err != nil {
+			return nil, newErrf("Error occurred on re-signing apk %s, %s", a.path, err)
+		}
+		signer.v3 = true
+		if err := signer.setJarMinSdkVersion(a.assetsMinSdk); err != nil {
+			return nil, newErrf("Error occurred on re-signing apk %s, %s", a.path, err)
+		}
+	}
+	// the v4 signer is of the base apk, or of every re-signed apk with assets
+	var v4 *v4Signer
+	if a.v4Key != nil && signer == nil {
+		if v4, err = newV4Signer(a.v4Key, a.v4Certs, z.signingBlock); err != nil {
+			return nil, newErrf("Error occurred on APK Signature Scheme v4 signing, %s", err)
+		}
+	}
 
 	inputDir := filepath.Dir(a.path)
 	outputDir := filepath.Dir(out)
 	_, err = os.Stat(outputDir)
 	if err != nil {
 		if os.IsNotExist(err) {
-			if err := os.MkdirAll(outputDir, os.ModeDir); err != nil {
+			if err := os.MkdirAll(outputDir, 0755); err != nil {
 				return nil, err
 			}
 		} else {
@@ -120,15 +235,110 @@ func (a *apk) generate(out string, channels []string, extras map[string]string)
 		if output == "" {
 			output = filepath.Join(inputDir, name+"-"+channel+ext)
 		}
-		c := channelInfo{channel: channel, extras: extras}
-		err = gen(c, z, output)
-		if err != nil {
-			return nil, newErrf("Error occurred on generating channel %s, %s", channel, err)
+		if err := a.genChannel(channelInfo{channel: channel, extras: extras}, z, output, signer, v4); err != nil {
+			return nil, err
 		}
-		outs[i], err = NewApk(output)
+		outs[i], err = NewApk(output, a.opts...)
 		if err != nil {
 			return nil, err
 		}
 	}
 	return outs, nil
 }
+
+// genChannel generates the apk of info from z to output, with assets re-signed by
+// signer if it is not nil, and v4 signed by v4 if it is not nil.
+func (a *apk) genChannel(info channelInfo, z zipSections, output string, signer *apkSigner, v4 *v4Signer) error {
+	channel := info.channel
+	if signer != nil {
+		signed, closer, err := a.injectAssets(info, z, signer, filepath.Dir(output))
+		if err != nil {
+			return newErrf("Error occurred on injecting assets of channel %s, %s", channel, err)
+		}
+		defer closer.Close()
+		z = signed
+		if a.v4Key != nil {
+			if v4, err = newV4Signer(a.v4Key, a.v4Certs, z.signingBlock); err != nil {
+				return newErrf("Error occurred on APK Signature Scheme v4 signing, %s", err)
+			}
+		}
+	}
+	if err := gen(info, z, output); err != nil {
+		return newErrf("Error occurred on generating channel %s, %s", channel, err)
+	}
+	if a.selfCheck {
+		if err := checkOutput(z, info, output); err != nil {
+			_ = os.Remove(output)
+			return newErrf("Error occurred on checking channel %s, %s", channel, err)
+		}
+	}
+	if a.verify {
+		if err := verifyOutput(output); err != nil {
+			_ = os.Remove(output)
+			return newErrf("Error occurred on verifying channel %s, %s", channel, err)
+		}
+	}
+	if v4 != nil {
+		if err := v4.signFile(output); err != nil {
+			_ = os.Remove(output)
+			return newErrf("Error occurred on v4 signing channel %s, %s", channel, err)
+		}
+	}
+	return nil
+}
+
+// injectAssets writes the apk of z with the assets of info re-signed by s to a
+// temporary file in dir, and returns its sections. closer closes and removes the file.
+func (a *apk) injectAssets(info channelInfo, z zipSections, s *apkSigner, dir string) (zipSections, io.Closer, error) {
+	assets, err := a.assets(info.channel, info.extras)
+	if err != nil {
+		return zipSections{}, nil, err
+	}
+	f, err := os.CreateTemp(dir, ".walle-*.apk")
+	if err != nil {
+		return zipSections{}, nil, err
+	}
+	closer := tempFile{f}
+	signed, err := func() (*zipSections, error) {
+		if err := rewriteZip(&z, assets, s, f); err != nil {
+			return nil, err
+		}
+		size, err := fileSize(f)
+		if err != nil {
+			return nil, err
+		}
+		unsigned, err := newUnsignedZipSectionsAt(f, size)
+		if err != nil {
+			return nil, err
+		}
+		return s.transform(&unsigned)
+	}()
+	if err != nil {
+		closer.Close()
+		return zipSections{}, nil, err
+	}
+	return *signed, closer, nil
+}
+
+// open reads the sections of the apk, closer must be closed once the sections are no
+// longer used since bytes before signing block are read from the apk on demand.
+func (a *apk) open() (zipSections, io.Closer, error) {
+	if a.src != nil {
+		z, err := newZipSectionsAt(a.src, a.size)
+		return z, nopCloser{}, err
+	}
+	f, closer := a.file, io.Closer(nopCloser{})
+	if f == nil {
+		var err error
+		if f, err = os.Open(a.path); err != nil {
+			return zipSections{}, nil, err
+		}
+		closer = f
+	}
+	z, err := defaultSectionsCache.sections(a.path, f)
+	if err != nil {
+		closer.Close()
+		return zipSections{}, nil, err
+	}
+	return z, closer, nil
+}
@@ -0,0 +1,157 @@
+package _go
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newTestApk writes a small zip with a fake APK Signing Block into dir, the block
+// holds a dummy v2 pair followed by the given pairs.
+func newTestApk(t *testing.T, dir string, pairs ...[]byte) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"AndroidManifest.xml", "classes.dex", "res/layout/main.xml"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(bytes.Repeat([]byte(name), 64)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	eocd := b[len(b)-_ZIP_EOCD_REC_MIN_SIZE:]
+	cdOffset := getEocdCentralDirectoryOffset(eocd)
+
+	v2 := appendIdValue(nil, APK_SIGNATURE_SCHEME_V2_BLOCK_ID, bytes.Repeat([]byte{0x5a}, 100))
+	block := testSigningBlock(append(v2, bytes.Join(pairs, nil)...))
+
+	out := append([]byte{}, b[:cdOffset]...)
+	out = append(out, block...)
+	out = append(out, b[cdOffset:]...)
+	setEocdCentralDirectoryOffset(out[len(out)-_ZIP_EOCD_REC_MIN_SIZE:], cdOffset+uint32(len(block)))
+
+	path := filepath.Join(dir, "base.apk")
+	if err := os.WriteFile(path, out, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testSigningBlock wraps raw ID-value pairs into an APK Signing Block.
+func testSigningBlock(pairs []byte) []byte {
+	block := make([]byte, 8+len(pairs)+24)
+	putUint64(uint64(len(block)-8), block, 0)
+	copy(block[8:], pairs)
+	putUint64(uint64(len(block)-8), block, 8+len(pairs))
+	putUint64(_APK_SIG_BLOCK_MAGIC_LO, block, len(block)-16)
+	putUint64(_APK_SIG_BLOCK_MAGIC_HI, block, len(block)-8)
+	return block
+}
+
+func TestApk_PutChannelWithExtra(t *testing.T) {
+	tests := []struct {
+		name  string
+		pairs [][]byte
+	}{
+		{"v2 only", nil},
+		{"padded", [][]byte{appendIdValue(nil, VERITY_PADDING_BLOCK_ID, make([]byte, 64))}},
+		{"channel exists", [][]byte{appendIdValue(nil, APK_CHANNEL_BLOCK_ID, []byte(`{"channel":"old"}`))}},
+	}
+	extras := map[string]string{"version_code": "10012"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			a, err := NewApk(newTestApk(t, dir, tt.pairs...), WithSelfCheck())
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := a.PutChannelWithExtra("xiaomi", extras, filepath.Join(dir, "out.apk"))
+			if err != nil {
+				t.Fatalf("PutChannelWithExtra() error = %v", err)
+			}
+			if out.Channel() != "xiaomi" || !reflect.DeepEqual(out.Extras(), extras) {
+				t.Errorf("PutChannelWithExtra() got %v", out.All())
+			}
+			z, err := newZipSections(out.Path())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(z.signingBlock)%ANDROID_COMMON_PAGE_ALIGNMENT_BYTES != 0 {
+				t.Errorf("signing block size %d is not page aligned", len(z.signingBlock))
+			}
+		})
+	}
+}
+
+func TestNewApkFromReaderAt(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewApk(newTestApk(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := a.PutChannelWithExtra("xiaomi", map[string]string{"k": "v"}, filepath.Join(dir, "out.apk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewApkFromReaderAt(bytes.NewReader(b), int64(len(b)), WithSelfCheck())
+	if err != nil {
+		t.Fatalf("NewApkFromReaderAt() error = %v", err)
+	}
+	if r.Channel() != "xiaomi" || r.Extras()["k"] != "v" {
+		t.Errorf("NewApkFromReaderAt() got %v", r.All())
+	}
+	if _, err := r.PutChannel("oppo", ""); err == nil {
+		t.Errorf("PutChannel() without output path should fail")
+	}
+	o, err := r.PutChannel("oppo", filepath.Join(dir, "oppo.apk"))
+	if err != nil {
+		t.Fatalf("PutChannel() error = %v", err)
+	}
+	if o.Channel() != "oppo" || o.Extras()["k"] != "" {
+		t.Errorf("PutChannel() got %v", o.All())
+	}
+
+	if _, err := NewApkFromReaderAt(bytes.NewReader(b[:100]), 100); err == nil {
+		t.Errorf("NewApkFromReaderAt() on truncated apk should fail")
+	}
+}
+
+func TestApk_PutChannelInPlace(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewApk(newTestApk(t, dir), WithSelfCheck())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := a.PutChannel("xiaomi", a.Path())
+	if err != nil {
+		t.Fatalf("PutChannel() in place error = %v", err)
+	}
+	if out.Channel() != "xiaomi" {
+		t.Errorf("PutChannel() in place got %v", out.All())
+	}
+	// the file is replaced, so the apk is read again for the next channel
+	a, err = NewApk(a.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err = a.PutChannel("oppo", a.Path()); err != nil || out.Channel() != "oppo" {
+		t.Fatalf("PutChannel() in place again got %v, %v", out, err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Errorf("files left in directory %v, %v", entries, err)
+	}
+}
@@ -0,0 +1,226 @@
+package _go
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scope is what an API key is allowed to do.
+type Scope string
+
+const (
+	// ScopeRead lists bases and inspects apks.
+	ScopeRead Scope = "read"
+	// ScopeDownload downloads channel apks.
+	ScopeDownload Scope = "download"
+	// ScopeAdminUpload uploads and deletes bases and sets aliases.
+	ScopeAdminUpload Scope = "admin-upload"
+)
+
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+)
+
+// APIKey is a key of a tenant with its scopes.
+type APIKey struct {
+	Key    string  `json:"key"`
+	Tenant string  `json:"tenant"`
+	Scopes []Scope `json:"scopes"`
+}
+
+func (k *APIKey) has(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// keyStoreReloadInterval is how often KeyStore checks its file for changes.
+const keyStoreReloadInterval = time.Second
+
+// KeyStore holds the API keys loaded from a JSON file:
+//
+//	[{"key": "...", "tenant": "team-a", "scopes": ["read", "download"]}]
+//
+// The file is reloaded when its mtime changes, checked at most once a second on lookup.
+// A file that fails to load keeps the previous keys. It is safe for concurrent use.
+type KeyStore struct {
+	path string
+
+	mu      sync.RWMutex
+	keys    map[[sha256.Size]byte]*APIKey
+	modTime time.Time
+	checked time.Time
+}
+
+// LoadKeyStore loads the API keys in path.
+func LoadKeyStore(path string) (*KeyStore, error) {
+	ks := &KeyStore{path: path}
+	if err := ks.Reload(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Reload loads the keys file again.
+func (ks *KeyStore) Reload() error {
+	fi, err := os.Stat(ks.path)
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(ks.path)
+	if err != nil {
+		return err
+	}
+	var list []*APIKey
+	if err := json.Unmarshal(b, &list); err != nil {
+		return newErrf("Error occurred on loading keys %s, %s", ks.path, err)
+	}
+	keys := make(map[[sha256.Size]byte]*APIKey, len(list))
+	for i, k := range list {
+		if k.Key == "" || !registryName.MatchString(k.Tenant) {
+			return newErrf("Error occurred on loading keys %s, key #%d has no key or an invalid tenant", ks.path, i)
+		}
+		keys[sha256.Sum256([]byte(k.Key))] = k
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys, ks.modTime, ks.checked = keys, fi.ModTime(), time.Now()
+	return nil
+}
+
+// lookup finds key, the keys are looked up by hash so the lookup time does not depend
+// on how much of a key matches.
+func (ks *KeyStore) lookup(key string) (*APIKey, bool) {
+	ks.mu.RLock()
+	stale := time.Since(ks.checked) > keyStoreReloadInterval
+	ks.mu.RUnlock()
+	if stale {
+		ks.mu.Lock()
+		ks.checked = time.Now()
+		modTime := ks.modTime
+		ks.mu.Unlock()
+		if fi, err := os.Stat(ks.path); err == nil && !fi.ModTime().Equal(modTime) {
+			_ = ks.Reload()
+		}
+	}
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	k, ok := ks.keys[sha256.Sum256([]byte(key))]
+	return k, ok
+}
+
+type apiKeyContextKey struct{}
+
+// KeyFromContext returns the API key of a request authenticated by RequireScope.
+func KeyFromContext(ctx context.Context) (*APIKey, bool) {
+	k, ok := ctx.Value(apiKeyContextKey{}).(*APIKey)
+	return k, ok
+}
+
+// TenantFromContext returns the tenant of a request authenticated by RequireScope, or
+// an empty string.
+func TenantFromContext(ctx context.Context) string {
+	if k, ok := KeyFromContext(ctx); ok {
+		return k.Tenant
+	}
+	return ""
+}
+
+// requestKey returns the key in "Authorization: Bearer <key>" or "X-API-Key: <key>".
+func requestKey(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(h[len("Bearer "):])
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// RequireScope only passes the requests with an API key of ks that has scope, the key
+// is put into the request context, see KeyFromContext and TenantFromContext. Requests
+// without a known key get 401, keys without scope get 403.
+func RequireScope(ks *KeyStore, scope Scope, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := requestKey(r)
+		if key == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, newErrf("missing API key: %w", ErrUnauthorized))
+			return
+		}
+		k, ok := ks.lookup(key)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, newErrf("unknown API key: %w", ErrUnauthorized))
+			return
+		}
+		if !k.has(scope) {
+			writeError(w, newErrf("API key has no %s scope: %w", scope, ErrForbidden))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, k)))
+	})
+}
+
+// WithAuth requires API keys of ks, and every tenant only sees its own bases, registry
+// and channel allowlist:
+//
+//	GET /apk/...           download scope, or a signed URL if WithURLSigning is set
+//	GET /bases...          read scope
+//	PUT, DELETE /bases...  admin-upload scope
+//	POST /inspect          read scope
+//	GET /channels          read scope
+//	POST /sign             download scope
+func WithAuth(ks *KeyStore) func(*Server) {
+	return func(s *Server) {
+		s.keys = ks
+	}
+}
+
+// authDownload lets signed URLs through when there is no API key, they are checked by
+// serveApk.
+func (s *Server) authDownload(next http.Handler) http.Handler {
+	if s.keys == nil {
+		return next
+	}
+	withKey := RequireScope(s.keys, ScopeDownload, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.secret != nil && requestKey(r) == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		withKey.ServeHTTP(w, r)
+	})
+}
+
+// authBases requires read scope for GET and admin-upload scope otherwise.
+func (s *Server) authBases(next http.Handler) http.Handler {
+	if s.keys == nil {
+		return next
+	}
+	read := RequireScope(s.keys, ScopeRead, next)
+	admin := RequireScope(s.keys, ScopeAdminUpload, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			read.ServeHTTP(w, r)
+			return
+		}
+		admin.ServeHTTP(w, r)
+	})
+}
+
+// authScope requires scope if WithAuth is set.
+func (s *Server) authScope(scope Scope, next http.Handler) http.Handler {
+	if s.keys == nil {
+		return next
+	}
+	return RequireScope(s.keys, scope, next)
+}
@@ -0,0 +1,107 @@
+package _go
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestKeys(t *testing.T, path, keys string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(keys), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServer_WithAuth(t *testing.T) {
+	dir := t.TempDir()
+	keysPath := filepath.Join(dir, "keys.json")
+	writeTestKeys(t, keysPath, `[
+		{"key": "a-admin", "tenant": "team-a", "scopes": ["read", "download", "admin-upload"]},
+		{"key": "a-read", "tenant": "team-a", "scopes": ["read"]},
+		{"key": "b-all", "tenant": "team-b", "scopes": ["read", "download", "admin-upload"]}
+	]`, time.Now().Add(-time.Hour))
+	ks, err := LoadKeyStore(keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("s3cret")
+	s := NewServer(WithAuth(ks), WithURLSigning(secret),
+		WithTenantRegistries(filepath.Join(dir, "registries")),
+		WithTenantChannels("team-a", "xiaomi"))
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	base := newTestApk(t, t.TempDir())
+	do := func(method, path, key string, body io.Reader) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, body)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	f, err := os.Open(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := do(http.MethodPut, "/bases/demo/1.0", "a-admin", f)
+	f.Close()
+	if code != http.StatusCreated {
+		t.Fatalf("upload by team-a got status %d", code)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		key        string
+		wantStatus int
+	}{
+		{"missing key", http.MethodGet, "/bases", "", http.StatusUnauthorized},
+		{"sign without key", http.MethodPost, "/sign", "", http.StatusUnauthorized},
+		{"unknown key", http.MethodGet, "/bases", "nope", http.StatusUnauthorized},
+		{"read", http.MethodGet, "/bases/demo", "a-read", http.StatusOK},
+		{"upload without scope", http.MethodDelete, "/bases/demo/1.0", "a-read", http.StatusForbidden},
+		{"download without scope", http.MethodGet, "/apk/demo/xiaomi", "a-read", http.StatusForbidden},
+		{"download", http.MethodGet, "/apk/demo/xiaomi", "a-admin", http.StatusOK},
+		{"channel not allowed for tenant", http.MethodGet, "/apk/demo/huawei", "a-admin", http.StatusNotFound},
+		{"other tenant base", http.MethodGet, "/bases/demo", "b-all", http.StatusNotFound},
+		{"other tenant download", http.MethodGet, "/apk/demo/huawei", "b-all", http.StatusNotFound},
+		{"signed url", http.MethodGet, SignTenantURL(secret, "team-a", "demo", "xiaomi", nil, time.Now().Add(time.Hour)), "", http.StatusOK},
+		{"signed url of other tenant", http.MethodGet, SignTenantURL(secret, "team-b", "demo", "xiaomi", nil, time.Now().Add(time.Hour)), "", http.StatusNotFound},
+		{"signed url without tenant", http.MethodGet, SignURL(secret, "demo", "xiaomi", nil, time.Now().Add(time.Hour)), "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := do(tt.method, tt.path, tt.key, nil); code != tt.wantStatus {
+				t.Errorf("%s %s got status %d, want %d", tt.method, tt.path, code, tt.wantStatus)
+			}
+		})
+	}
+
+	t.Run("reload", func(t *testing.T) {
+		writeTestKeys(t, keysPath, `[{"key": "a-new", "tenant": "team-a", "scopes": ["read"]}]`, time.Now())
+		ks.mu.Lock()
+		ks.checked = time.Time{}
+		ks.mu.Unlock()
+		if code := do(http.MethodGet, "/bases/demo", "a-new", nil); code != http.StatusOK {
+			t.Errorf("new key got status %d", code)
+		}
+		if code := do(http.MethodGet, "/bases/demo", "a-read", nil); code != http.StatusUnauthorized {
+			t.Errorf("removed key got status %d", code)
+		}
+	})
+}
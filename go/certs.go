@@ -0,0 +1,340 @@
+package _go
+
+import (
+	"bytes"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Certificate describes a signing certificate with the digests apksigner verify
+// --print-certs prints.
+type Certificate struct {
+	// Subject and Issuer are distinguished names in the form of Java's
+	// X500Principal.toString, such as "CN=Android Debug, O=Android, C=US".
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	SerialNumber string    `json:"serialNumber"`
+	NotBefore    time.Time `json:"notBefore"`
+	NotAfter     time.Time `json:"notAfter"`
+	// KeyAlgorithm is the Java name of the key algorithm: RSA, EC or DSA.
+	KeyAlgorithm string `json:"keyAlgorithm"`
+	KeySize      int    `json:"keySize"`
+	// SHA256, SHA1 and MD5 are the lower-case hex digests of the DER certificate.
+	SHA256 string `json:"sha256"`
+	SHA1   string `json:"sha1"`
+	MD5    string `json:"md5"`
+}
+
+// NewCertificate describes c.
+func NewCertificate(c *x509.Certificate) *Certificate {
+	sha256Sum, sha1Sum, md5Sum := sha256.Sum256(c.Raw), sha1.Sum(c.Raw), md5.Sum(c.Raw)
+	ret := &Certificate{
+		Subject:      distinguishedName(c.RawSubject),
+		Issuer:       distinguishedName(c.RawIssuer),
+		SerialNumber: c.SerialNumber.Text(16),
+		NotBefore:    c.NotBefore,
+		NotAfter:     c.NotAfter,
+		SHA256:       hex.EncodeToString(sha256Sum[:]),
+		SHA1:         hex.EncodeToString(sha1Sum[:]),
+		MD5:          hex.EncodeToString(md5Sum[:]),
+	}
+	switch k := c.PublicKey.(type) {
+	case *rsa.PublicKey:
+		ret.KeyAlgorithm, ret.KeySize = "RSA", k.N.BitLen()
+	case *ecdsa.PublicKey:
+		ret.KeyAlgorithm, ret.KeySize = "EC", k.Curve.Params().BitSize
+	case *dsa.PublicKey:
+		ret.KeyAlgorithm, ret.KeySize = "DSA", k.P.BitLen()
+	}
+	return ret
+}
+
+// x500Keywords are the keywords of attribute types in Java's X500Principal.toString,
+// other types are written as "OID.{oid}".
+var x500Keywords = map[string]string{
+	"2.5.4.3":                    "CN",
+	"2.5.4.6":                    "C",
+	"2.5.4.7":                    "L",
+	"2.5.4.8":                    "ST",
+	"2.5.4.10":                   "O",
+	"2.5.4.11":                   "OU",
+	"2.5.4.12":                   "T",
+	"1.3.6.1.4.1.42.2.11.2.1":    "IP",
+	"2.5.4.9":                    "STREET",
+	"0.9.2342.19200300.100.1.25": "DC",
+	"2.5.4.46":                   "DNQ",
+	"2.5.4.4":                    "SURNAME",
+	"2.5.4.42":                   "GIVENNAME",
+	"2.5.4.43":                   "INITIALS",
+	"2.5.4.44":                   "GENERATION",
+	"1.2.840.113549.1.9.1":       "EMAILADDRESS",
+	"0.9.2342.19200300.100.1.1":  "UID",
+	"2.5.4.5":                    "SERIALNUMBER",
+}
+
+// x500AttributeSET is a relative distinguished name with raw values, so their string
+// types are known.
+type x500AttributeSET []struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// distinguishedName formats the DER name raw as Java's X500Principal.toString does,
+// the most specific RDN first and separated by ", ".
+func distinguishedName(raw []byte) string {
+	var seq []x500AttributeSET
+	if rest, err := asn1.Unmarshal(raw, &seq); err != nil || len(rest) > 0 {
+		var name pkix.RDNSequence
+		asn1.Unmarshal(raw, &name)
+		return name.String()
+	}
+	names := make([]string, 0, len(seq))
+	for i := len(seq) - 1; i >= 0; i-- {
+		avas := make([]string, len(seq[i]))
+		for j, ava := range seq[i] {
+			keyword, ok := x500Keywords[ava.Type.String()]
+			if !ok {
+				keyword = "OID." + ava.Type.String()
+			}
+			avas[j] = keyword + "=" + x500Value(ava.Value)
+		}
+		names = append(names, strings.Join(avas, " + "))
+	}
+	return strings.Join(names, ", ")
+}
+
+// x500Value formats an attribute value as sun.security.x509.AVA does: strings are
+// quoted if they have special characters, leading, trailing or repeated whitespace,
+// with '"' and '\' escaped, and other values are '#' and the hex of their DER.
+func x500Value(v asn1.RawValue) string {
+	s, ok := x500String(v)
+	if !ok {
+		return "#" + strings.ToUpper(hex.EncodeToString(v.FullBytes))
+	}
+	const escapees = ",+=\n<>#;\\\""
+	var b strings.Builder
+	quote, previousWhite := false, false
+	quoted := len(s) > 1 && s[0] == '"' && s[len(s)-1] == '"'
+	runes := []rune(s)
+	for i, c := range runes {
+		if quoted && (i == 0 || i == len(runes)-1) {
+			b.WriteRune(c)
+			continue
+		}
+		escapee := strings.ContainsRune(escapees, c)
+		if !isPrintableStringChar(c) && !escapee {
+			previousWhite = false
+			b.WriteRune(c)
+			continue
+		}
+		if i == 0 && (c == ' ' || c == '\n') || escapee {
+			quote = true
+		}
+		if c == ' ' || c == '\n' {
+			quote = quote || previousWhite
+			previousWhite = true
+		} else {
+			if c == '"' || c == '\\' {
+				b.WriteByte('\\')
+			}
+			previousWhite = false
+		}
+		b.WriteRune(c)
+	}
+	if n := len(runes); n > 0 && (runes[n-1] == ' ' || runes[n-1] == '\n') {
+		quote = true
+	}
+	if quote && !quoted {
+		return `"` + b.String() + `"`
+	}
+	return b.String()
+}
+
+// isPrintableStringChar reports whether c is in the ASN.1 PrintableString set.
+func isPrintableStringChar(c rune) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.ContainsRune(" '()+,-./:=?", c)
+}
+
+// x500String decodes the string types of DerValue.getAsString.
+func x500String(v asn1.RawValue) (string, bool) {
+	if v.Class != asn1.ClassUniversal {
+		return "", false
+	}
+	b := v.Bytes
+	switch v.Tag {
+	case asn1.TagUTF8String, asn1.TagPrintableString, asn1.TagIA5String, 27: // GeneralString
+		return string(b), true
+	case asn1.TagT61String:
+		// ISO-8859-1
+		r := make([]rune, len(b))
+		for i, c := range b {
+			r[i] = rune(c)
+		}
+		return string(r), true
+	case asn1.TagBMPString:
+		u := make([]uint16, len(b)/2)
+		for i := range u {
+			u[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+		}
+		return string(utf16.Decode(u)), true
+	case 28: // UniversalString
+		r := make([]rune, len(b)/4)
+		for i := range r {
+			r[i] = rune(b[4*i])<<24 | rune(b[4*i+1])<<16 | rune(b[4*i+2])<<8 | rune(b[4*i+3])
+		}
+		return string(r), true
+	}
+	return "", false
+}
+
+// SignerCertificates returns the certificates of the signers of the latest scheme below
+// v3.1 that is present, v3, v2 then v1, as apksigner prints them.
+func (v *Verification) SignerCertificates() []*Certificate {
+	for _, s := range []*SchemeVerification{v.V3, v.V2, v.V1} {
+		if s == nil {
+			continue
+		}
+		var ret []*Certificate
+		for _, signer := range s.Signers {
+			if signer.Certificate != nil {
+				ret = append(ret, signer.Certificate)
+			}
+		}
+		return ret
+	}
+	return nil
+}
+
+// PrintCerts writes the signer certificates in the format of apksigner verify
+// --print-certs, with the v3.1 signers after the others.
+func (v *Verification) PrintCerts(w io.Writer) error {
+	var buf bytes.Buffer
+	printCert := func(prefix string, c *Certificate) {
+		fmt.Fprintf(&buf, "%s certificate DN: %s\n", prefix, c.Subject)
+		fmt.Fprintf(&buf, "%s certificate SHA-256 digest: %s\n", prefix, c.SHA256)
+		fmt.Fprintf(&buf, "%s certificate SHA-1 digest: %s\n", prefix, c.SHA1)
+		fmt.Fprintf(&buf, "%s certificate MD5 digest: %s\n", prefix, c.MD5)
+	}
+	for i, c := range v.SignerCertificates() {
+		printCert(fmt.Sprintf("Signer #%d", i+1), c)
+	}
+	if v.V31 != nil {
+		for _, s := range v.V31.Signers {
+			if s.Certificate != nil {
+				printCert(fmt.Sprintf("Signer (minSdkVersion=%d, maxSdkVersion=%d)", s.MinSDK, s.MaxSDK), s.Certificate)
+			}
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// signerSet returns the SHA-256 digests of the signer certificates by scheme.
+func (v *Verification) signerSet() map[string][]string {
+	ret := make(map[string][]string)
+	for name, s := range map[string]*SchemeVerification{"v1": v.V1, "v2": v.V2, "v3": v.V3, "v3.1": v.V31} {
+		if s == nil {
+			continue
+		}
+		digests := []string{}
+		for _, signer := range s.Signers {
+			if signer.Certificate != nil {
+				digests = append(digests, signer.Certificate.SHA256)
+			}
+		}
+		sort.Strings(digests)
+		ret[name] = digests
+	}
+	return ret
+}
+
+// SignerCheck is the result of CheckSigners for one apk.
+type SignerCheck struct {
+	Path string
+	// Err is set when the apk cannot be read, does not verify or is not signed by the
+	// signers of the base apk.
+	Err error
+}
+
+// CheckSigners checks that the apks at paths verify and have the same signer
+// certificates as the base apk in every signature scheme, such as the outputs of
+// BatchChannels. An error is only returned if the base apk cannot be read or does not
+// verify.
+func CheckSigners(base string, paths ...string) ([]SignerCheck, error) {
+	res, err := VerifyFile(base)
+	if err != nil {
+		return nil, err
+	}
+	if !res.Verified {
+		return nil, fmt.Errorf("base apk does not verify: %s", res.firstError())
+	}
+	want, err := json.Marshal(res.signerSet())
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]SignerCheck, 0, len(paths))
+	for _, p := range paths {
+		check := SignerCheck{Path: p}
+		res, err := VerifyFile(p)
+		switch {
+		case err != nil:
+			check.Err = err
+		case !res.Verified:
+			check.Err = errors.New(res.firstError())
+		default:
+			// maps are marshaled with sorted keys
+			got, err := json.Marshal(res.signerSet())
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(got, want) {
+				check.Err = fmt.Errorf("signers %s differ from the base apk's %s", got, want)
+			}
+		}
+		ret = append(ret, check)
+	}
+	return ret, nil
+}
+
+// CheckSignersDir is CheckSigners of the apks in dir, a directory or a glob pattern as
+// of Scan. The base apk is skipped if it is in dir.
+func CheckSignersDir(base, dir string) ([]SignerCheck, error) {
+	files, err := listApks(dir)
+	if err != nil {
+		return nil, err
+	}
+	paths := files[:0]
+	for _, f := range files {
+		if !sameFile(f, base) {
+			paths = append(paths, f)
+		}
+	}
+	return CheckSigners(base, paths...)
+}
+
+func sameFile(a, b string) bool {
+	a, errA := filepath.Abs(a)
+	b, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && a == b
+}
@@ -0,0 +1,158 @@
+package _go
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(0x1234),
+		Subject:      pkix.Name{CommonName: "Android Debug", Organization: []string{"Android"}, Country: []string{"US"}},
+		NotBefore:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCertificate(cert)
+	if c.Subject != "CN=Android Debug, O=Android, C=US" || c.Issuer != c.Subject || c.SerialNumber != "1234" ||
+		!c.NotAfter.Equal(tmpl.NotAfter) || c.KeyAlgorithm != "EC" || c.KeySize != 256 {
+		t.Errorf("got %+v", c)
+	}
+	if len(c.SHA256) != 64 || len(c.SHA1) != 40 || len(c.MD5) != 32 {
+		t.Errorf("got digests %s %s %s", c.SHA256, c.SHA1, c.MD5)
+	}
+}
+
+func TestDistinguishedName(t *testing.T) {
+	attr := func(oid asn1.ObjectIdentifier, v interface{}) pkix.RelativeDistinguishedNameSET {
+		return pkix.RelativeDistinguishedNameSET{{Type: oid, Value: v}}
+	}
+	ia5 := func(s string) asn1.RawValue {
+		return asn1.RawValue{Tag: asn1.TagIA5String, Bytes: []byte(s)}
+	}
+	var (
+		oidCN    = asn1.ObjectIdentifier{2, 5, 4, 3}
+		oidO     = asn1.ObjectIdentifier{2, 5, 4, 10}
+		oidOU    = asn1.ObjectIdentifier{2, 5, 4, 11}
+		oidST    = asn1.ObjectIdentifier{2, 5, 4, 8}
+		oidEmail = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+	)
+	tests := []struct {
+		name string
+		seq  pkix.RDNSequence
+		want string
+	}{
+		{"email", pkix.RDNSequence{attr(oidO, "Android"), attr(oidCN, "Android Debug"), attr(oidEmail, ia5("android@example.com"))},
+			"EMAILADDRESS=android@example.com, CN=Android Debug, O=Android"},
+		{"state", pkix.RDNSequence{attr(oidST, "California")}, "ST=California"},
+		{"special characters", pkix.RDNSequence{attr(oidCN, "Doe, John")}, `CN="Doe, John"`},
+		{"quote and backslash", pkix.RDNSequence{attr(oidO, `a"b\c`)}, `O="a\"b\\c"`},
+		{"repeated whitespace", pkix.RDNSequence{attr(oidO, "a  b")}, `O="a  b"`},
+		{"trailing whitespace", pkix.RDNSequence{attr(oidO, "a ")}, `O="a "`},
+		{"unknown type", pkix.RDNSequence{attr(asn1.ObjectIdentifier{1, 2, 3, 4}, "x")}, "OID.1.2.3.4=x"},
+		{"not a string", pkix.RDNSequence{attr(oidO, 5)}, "O=#020105"},
+		{"multi-valued in DER order", pkix.RDNSequence{{{Type: oidOU, Value: "a"}, {Type: oidCN, Value: "b"}}}, "CN=b + OU=a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			der, err := asn1.Marshal(tt.seq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := distinguishedName(der); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckSigners(t *testing.T) {
+	var keys [2]*ecdsa.PrivateKey
+	for i := range keys {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = k
+	}
+	dir := t.TempDir()
+	unsigned := readTestApk(t, newTestApk(t, dir))
+	write := func(name string, b []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	cert := testCert(t, keys[0])
+	base := write("base.apk", testSignV2(t, unsigned, SIGNATURE_ECDSA_WITH_SHA256, keys[0], cert))
+	other := write("other.apk", testSignV2(t, unsigned, SIGNATURE_ECDSA_WITH_SHA256, keys[1], testCert(t, keys[1])))
+
+	res, err := VerifyFile(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := res.SignerCertificates()
+	if len(certs) != 1 {
+		t.Fatalf("got %d signer certificates", len(certs))
+	}
+	var out bytes.Buffer
+	if err := res.PrintCerts(&out); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("Signer #1 certificate SHA-256 digest: %s\n", certs[0].SHA256); !strings.Contains(out.String(), want) {
+		t.Errorf("PrintCerts got %q, want line %q", out.String(), want)
+	}
+
+	a, err := NewApk(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs, err := a.BatchChannels([]string{"xiaomi", "huawei"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks, err := CheckSigners(base, outputs[0].Path(), outputs[1].Path(), other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 3 || checks[0].Err != nil || checks[1].Err != nil || checks[2].Err == nil {
+		t.Errorf("got %+v", checks)
+	}
+
+	checks, err = CheckSignersDir(base, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// base.apk itself is skipped
+	if len(checks) != 3 || checks[0].Err != nil || checks[1].Err != nil || filepath.Base(checks[2].Path) != "other.apk" ||
+		checks[2].Err == nil {
+		t.Errorf("got %+v", checks)
+	}
+	if _, err := CheckSigners(other + ".missing"); err == nil {
+		t.Errorf("missing base apk checks")
+	}
+}
@@ -0,0 +1,120 @@
+package _go
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// checkOutput re-reads a generated apk and makes sure that only the signing block and
+// the central directory offset of EOCD differ from the base apk.
+func checkOutput(base zipSections, info channelInfo, output string) error {
+	f, err := os.Open(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	size, err := fileSize(f)
+	if err != nil {
+		return err
+	}
+	out, err := newZipSectionsAt(f, size)
+	if err != nil {
+		return err
+	}
+
+	if out.signingBlockOffset != base.signingBlockOffset {
+		return fmt.Errorf("bytes before APK Signing Block differ from base apk")
+	}
+	if eq, err := equalReaders(out.beforeSigningBlock(), base.beforeSigningBlock()); err != nil {
+		return err
+	} else if !eq {
+		return fmt.Errorf("bytes before APK Signing Block differ from base apk")
+	}
+	if !bytes.Equal(out.centraDir, base.centraDir) {
+		return fmt.Errorf("central directory differs from base apk")
+	}
+
+	// EOCD
+	if out.centralDirOffset != out.signingBlockOffset+int64(len(out.signingBlock)) {
+		return fmt.Errorf("EOCD central directory offset %d, but APK Signing Block ends at %d",
+			out.centralDirOffset, out.signingBlockOffset+int64(len(out.signingBlock)))
+	}
+	if out.eocdOffset != out.centralDirOffset+int64(len(out.centraDir)) {
+		return fmt.Errorf("EOCD offset %d, but central directory ends at %d",
+			out.eocdOffset, out.centralDirOffset+int64(len(out.centraDir)))
+	}
+	if !bytes.Equal(makeEocd(out.eocd, uint32(base.centralDirOffset)), base.eocd) {
+		return fmt.Errorf("EOCD differs from base apk")
+	}
+
+	// ID-value pairs
+	basePairs, err := findIdValuesInApkSigningBlock(base.signingBlock)
+	if err != nil {
+		return err
+	}
+	outPairs, err := findIdValuesInApkSigningBlock(out.signingBlock)
+	if err != nil {
+		return err
+	}
+	for id, value := range basePairs {
+		if id == APK_CHANNEL_BLOCK_ID {
+			continue
+		}
+		v, ok := outPairs[id]
+		if !ok {
+			return fmt.Errorf("ID-value pair 0x%x is missing in APK Signing Block", id)
+		}
+		if !bytes.Equal(v, value) {
+			return fmt.Errorf("ID-value pair 0x%x differs from base apk", id)
+		}
+	}
+
+	// channel info
+	var bundle map[string]string
+	if raw := outPairs[APK_CHANNEL_BLOCK_ID]; len(raw) != 0 {
+		if err := json.Unmarshal(raw, &bundle); err != nil {
+			return fmt.Errorf("channel block is broken, %s", err)
+		}
+	}
+	if ch := bundle["channel"]; ch != info.channel {
+		return fmt.Errorf("channel mismatched! Expect %q but %q", info.channel, ch)
+	}
+	delete(bundle, "channel")
+	if len(bundle) != len(info.extras) {
+		return fmt.Errorf("extras mismatched! Expect %v but %v", info.extras, bundle)
+	}
+	for k, v := range info.extras {
+		if got, ok := bundle[k]; !ok || got != v {
+			return fmt.Errorf("extras mismatched! Expect %v but %v", info.extras, bundle)
+		}
+	}
+	return nil
+}
+
+// equalReaders compares two readers chunk by chunk.
+func equalReaders(a, b io.Reader) (bool, error) {
+	bufA := make([]byte, 32*1024)
+	bufB := make([]byte, 32*1024)
+	for {
+		n, errA := io.ReadFull(a, bufA)
+		m, errB := io.ReadFull(b, bufB)
+		if !bytes.Equal(bufA[:n], bufB[:m]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			if errB == io.EOF || errB == io.ErrUnexpectedEOF {
+				return false, nil
+			}
+			return false, errB
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package _go
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_checkOutput(t *testing.T) {
+	dir := t.TempDir()
+	base := newTestApk(t, dir)
+	z, err := newZipSections(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := channelInfo{channel: "huawei", extras: map[string]string{"k": "v"}}
+	output := filepath.Join(dir, "out.apk")
+	if err := gen(info, z, output); err != nil {
+		t.Fatal(err)
+	}
+	good, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		tamper  func(b []byte) []byte
+		info    channelInfo
+		wantErr bool
+	}{
+		{"ok", nil, info, false},
+		{"prefix", func(b []byte) []byte { b[10] ^= 0xff; return b }, info, true},
+		{"central dir", func(b []byte) []byte { b[len(b)-_ZIP_EOCD_REC_MIN_SIZE-10] ^= 0xff; return b }, info, true},
+		{"eocd", func(b []byte) []byte { b[len(b)-_ZIP_EOCD_REC_MIN_SIZE+8] ^= 0xff; return b }, info, true},
+		{"v2 pair", func(b []byte) []byte { b[z.signingBlockOffset+20] ^= 0xff; return b }, info, true},
+		{"channel", nil, channelInfo{channel: "oppo", extras: info.extras}, true},
+		{"extras", nil, channelInfo{channel: "huawei"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := append([]byte{}, good...)
+			if tt.tamper != nil {
+				b = tt.tamper(b)
+			}
+			if err := os.WriteFile(output, b, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := checkOutput(z, tt.info, output); (err != nil) != tt.wantErr {
+				t.Errorf("checkOutput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
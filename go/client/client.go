@@ -0,0 +1,410 @@
+// Package client calls the JSON API of the channel server, see walle.Server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	walle "github.com/GGXXLL/walle/go"
+)
+
+// Errors matched by errors.Is on the *Error returned for status codes of the server.
+var (
+	ErrNotFound     = walle.ErrNotFound
+	ErrExists       = walle.ErrExists
+	ErrInvalid      = walle.ErrInvalid
+	ErrTooLarge     = walle.ErrTooLarge
+	ErrUnauthorized = walle.ErrUnauthorized
+	ErrForbidden    = walle.ErrForbidden
+	// ErrExpired is returned for an expired signed URL.
+	ErrExpired = errors.New("expired")
+)
+
+// Error is a response of the server with a non 2xx status code.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("walle: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("walle: %d %s", e.StatusCode, e.Message)
+}
+
+// Unwrap returns the error of the status code, such as ErrNotFound for 404.
+func (e *Error) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusConflict:
+		return ErrExists
+	case http.StatusBadRequest:
+		return ErrInvalid
+	case http.StatusRequestEntityTooLarge:
+		return ErrTooLarge
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrForbidden
+	case http.StatusGone:
+		return ErrExpired
+	}
+	return nil
+}
+
+// temporary reports whether the request may succeed if sent again.
+func (e *Error) temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Default retry policy, see WithRetries.
+const (
+	DefaultRetries = 3
+	DefaultBackoff = 200 * time.Millisecond
+)
+
+// Client calls a channel server. It is safe for concurrent use.
+type Client struct {
+	base    *url.URL
+	hc      *http.Client
+	key     string
+	retries int
+	backoff time.Duration
+}
+
+// WithHTTPClient sends the requests with hc instead of http.DefaultClient.
+func WithHTTPClient(hc *http.Client) func(*Client) {
+	return func(c *Client) {
+		c.hc = hc
+	}
+}
+
+// WithAPIKey authenticates the requests with key, see walle.WithAuth.
+func WithAPIKey(key string) func(*Client) {
+	return func(c *Client) {
+		c.key = key
+	}
+}
+
+// WithRetries sends idempotent requests up to n more times on network errors and 429,
+// 502, 503 and 504 responses, waiting backoff before the first retry and twice as long
+// before every next one.
+func WithRetries(n int, backoff time.Duration) func(*Client) {
+	return func(c *Client) {
+		c.retries = n
+		c.backoff = backoff
+	}
+}
+
+// New returns a client of the server at baseURL, such as "https://walle.example.com".
+// The server may be mounted under a path prefix.
+func New(baseURL string, opts ...func(*Client)) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("walle: invalid server url %q", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	c := &Client{
+		base:    u,
+		hc:      http.DefaultClient,
+		retries: DefaultRetries,
+		backoff: DefaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Upload stores a new version of base read from apk, the version becomes the latest
+// one. It is not retried since the server refuses to overwrite a version.
+func (c *Client) Upload(ctx context.Context, base, version string, apk io.Reader) (*walle.BaseVersion, error) {
+	var v walle.BaseVersion
+	err := c.doJSON(ctx, http.MethodPut, "/bases/"+url.PathEscape(base)+"/"+url.PathEscape(version), apk, false, &v)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// List returns the bases of the registry with their versions and aliases.
+func (c *Client) List(ctx context.Context) ([]*walle.BaseMeta, error) {
+	var list []*walle.BaseMeta
+	if err := c.doJSON(ctx, http.MethodGet, "/bases", nil, true, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Versions returns the versions and aliases of base.
+func (c *Client) Versions(ctx context.Context, base string) (*walle.BaseMeta, error) {
+	var meta walle.BaseMeta
+	if err := c.doJSON(ctx, http.MethodGet, "/bases/"+url.PathEscape(base), nil, true, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// Delete removes a version of base.
+func (c *Client) Delete(ctx context.Context, base, version string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/bases/"+url.PathEscape(base)+"/"+url.PathEscape(version), nil, true, nil)
+}
+
+// SetAlias points alias of base to version.
+func (c *Client) SetAlias(ctx context.Context, base, alias, version string) (*walle.BaseMeta, error) {
+	var meta walle.BaseMeta
+	path := "/bases/" + url.PathEscape(base) + "/aliases/" + url.PathEscape(alias)
+	if err := c.doJSON(ctx, http.MethodPut, path, map[string]string{"version": version}, true, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// Channels returns the channel allowlist.
+func (c *Client) Channels(ctx context.Context) (*walle.ChannelSet, error) {
+	var set walle.ChannelSet
+	if err := c.doJSON(ctx, http.MethodGet, "/channels", nil, true, &set); err != nil {
+		return nil, err
+	}
+	return &set, nil
+}
+
+// Sign mints a signed download URL, the URL of the response is absolute. Signing has
+// no side effect on the server so it is retried. It requires an API key with the
+// download scope, see WithAPIKey.
+func (c *Client) Sign(ctx context.Context, req walle.SignRequest) (*walle.SignResponse, error) {
+	var res walle.SignResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/sign", req, true, &res); err != nil {
+		return nil, err
+	}
+	res.URL = c.url(res.URL)
+	return &res, nil
+}
+
+// Inspect returns the channel and signing details of the apk read from apk.
+func (c *Client) Inspect(ctx context.Context, apk io.Reader) (*walle.Inspection, error) {
+	var ins walle.Inspection
+	if err := c.doJSON(ctx, http.MethodPost, "/inspect", apk, false, &ins); err != nil {
+		return nil, err
+	}
+	return &ins, nil
+}
+
+// Download writes the channel apk of base with extras to w, and returns the number of
+// bytes written. An interrupted download is resumed with a Range request of the same
+// ETag, so w never gets the same bytes twice.
+func (c *Client) Download(ctx context.Context, base, channel string, extras map[string]string, w io.Writer) (int64, error) {
+	path := "/apk/" + url.PathEscape(base) + "/" + url.PathEscape(channel)
+	if len(extras) > 0 {
+		q := make(url.Values)
+		for k, v := range extras {
+			q.Set(k, v)
+		}
+		path += "?" + q.Encode()
+	}
+	return c.download(ctx, c.url(path), w)
+}
+
+// DownloadURL is Download for a signed URL made by Sign or walle.SignURL, a relative
+// URL is resolved against the server.
+func (c *Client) DownloadURL(ctx context.Context, signedURL string, w io.Writer) (int64, error) {
+	u, err := url.Parse(signedURL)
+	if err != nil {
+		return 0, err
+	}
+	if !u.IsAbs() {
+		signedURL = c.url(signedURL)
+	}
+	return c.download(ctx, signedURL, w)
+}
+
+func (c *Client) download(ctx context.Context, rawURL string, w io.Writer) (int64, error) {
+	var (
+		written int64
+		etag    string
+		ww      = &writeErrorWriter{w}
+	)
+	err := c.retry(ctx, true, func() (bool, error) {
+		req, err := c.newRequest(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return false, err
+		}
+		if written > 0 {
+			req.Header.Set("Range", "bytes="+strconv.FormatInt(written, 10)+"-")
+			req.Header.Set("If-Range", etag)
+		}
+		resp, err := c.hc.Do(req)
+		if err != nil {
+			return true, err
+		}
+		defer resp.Body.Close()
+		switch {
+		case written == 0 && resp.StatusCode == http.StatusOK:
+			etag = resp.Header.Get("ETag")
+		case written > 0 && resp.StatusCode == http.StatusPartialContent:
+		case written > 0 && resp.StatusCode == http.StatusOK:
+			return false, fmt.Errorf("walle: apk changed while resuming the download at %d bytes", written)
+		default:
+			return c.responseError(resp)
+		}
+		n, err := io.Copy(ww, resp.Body)
+		written += n
+		if err != nil {
+			// only a download with an ETag can be resumed, and not if w failed
+			var werr *writeError
+			return etag != "" && !errors.As(err, &werr), err
+		}
+		return false, nil
+	})
+	return written, err
+}
+
+// writeError tells the failures of the writer of Download from the ones of the body.
+type writeError struct{ err error }
+
+func (e *writeError) Error() string { return e.err.Error() }
+func (e *writeError) Unwrap() error { return e.err }
+
+type writeErrorWriter struct{ w io.Writer }
+
+func (w *writeErrorWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if err != nil {
+		err = &writeError{err}
+	}
+	return n, err
+}
+
+// url returns the absolute URL of path on the server.
+func (c *Client) url(path string) string {
+	u := *c.base
+	ref, err := url.Parse(path)
+	if err != nil {
+		return c.base.String() + path
+	}
+	u.Path = c.base.Path + ref.Path
+	u.RawPath = ""
+	if ref.RawPath != "" {
+		u.RawPath = c.base.EscapedPath() + ref.RawPath
+	}
+	u.RawQuery = ref.RawQuery
+	return u.String()
+}
+
+func (c *Client) newRequest(ctx context.Context, method, rawURL string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.key)
+	}
+	return req, nil
+}
+
+// doJSON sends in to path and decodes the JSON response into out if it is not nil. An
+// io.Reader in is streamed as an apk and never retried, other values are sent as JSON
+// and retried if idempotent is true.
+func (c *Client) doJSON(ctx context.Context, method, path string, in interface{}, idempotent bool, out interface{}) error {
+	var (
+		stream      io.Reader
+		body        []byte
+		contentType string
+	)
+	switch in := in.(type) {
+	case nil:
+	case io.Reader:
+		stream, contentType, idempotent = in, "application/vnd.android.package-archive", false
+	default:
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body, contentType = b, "application/json"
+	}
+	return c.retry(ctx, idempotent, func() (bool, error) {
+		r := stream
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		req, err := c.newRequest(ctx, method, c.url(path), r)
+		if err != nil {
+			return false, err
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := c.hc.Do(req)
+		if err != nil {
+			return true, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return c.responseError(resp)
+		}
+		if out == nil {
+			return false, nil
+		}
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return true, fmt.Errorf("walle: decoding response of %s %s: %w", method, path, err)
+		}
+		return false, nil
+	})
+}
+
+// retry calls do until it succeeds, returns an error that is not temporary, or the
+// retries of an idempotent request run out.
+func (c *Client) retry(ctx context.Context, idempotent bool, do func() (temporary bool, err error)) error {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		temporary, err := do()
+		if err == nil || !temporary || !idempotent || attempt >= c.retries {
+			return err
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		backoff *= 2
+	}
+}
+
+// responseError reads the error of a non 2xx response, the server writes JSON errors
+// {"error": "..."} and plain text ones.
+func (c *Client) responseError(resp *http.Response) (bool, error) {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	e := &Error{StatusCode: resp.StatusCode}
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(b, &body) == nil && body.Error != "" {
+		e.Message = body.Error
+	} else {
+		e.Message = strings.TrimSpace(string(b))
+	}
+	return e.temporary(), e
+}
@@ -0,0 +1,248 @@
+package client
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	walle "github.com/GGXXLL/walle/go"
+)
+
+// testApk returns a small zip with an APK Signing Block holding a dummy v2 pair.
+func testApk(t *testing.T, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("classes.dex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(bytes.Repeat([]byte(content), 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	eocd := b[len(b)-22:]
+	cdOffset := binary.LittleEndian.Uint32(eocd[16:])
+
+	le := binary.LittleEndian
+	pair := make([]byte, 12+100)
+	le.PutUint64(pair, 4+100)
+	le.PutUint32(pair[8:], 0x7109871a)
+	block := make([]byte, 8+len(pair)+24)
+	le.PutUint64(block, uint64(len(block)-8))
+	copy(block[8:], pair)
+	le.PutUint64(block[8+len(pair):], uint64(len(block)-8))
+	copy(block[len(block)-16:], "APK Sig Block 42")
+
+	out := append([]byte{}, b[:cdOffset]...)
+	out = append(out, block...)
+	out = append(out, b[cdOffset:]...)
+	le.PutUint32(out[len(out)-22+16:], cdOffset+uint32(len(block)))
+	return out
+}
+
+// flaky fails the first n requests with 503.
+type flaky struct {
+	h http.Handler
+	n int32
+}
+
+func (f *flaky) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.AddInt32(&f.n, -1) >= 0 {
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+		return
+	}
+	f.h.ServeHTTP(w, r)
+}
+
+// cutting aborts the first apk download after half of the body.
+type cutting struct {
+	h   http.Handler
+	cut int32
+}
+
+type halfWriter struct {
+	http.ResponseWriter
+	left int
+}
+
+func (w *halfWriter) Write(p []byte) (int, error) {
+	if len(p) > w.left {
+		_, _ = w.ResponseWriter.Write(p[:w.left])
+		w.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.left -= len(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func (c *cutting) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Range") == "" && atomic.CompareAndSwapInt32(&c.cut, 0, 1) {
+		w = &halfWriter{ResponseWriter: w, left: 3000}
+	}
+	c.h.ServeHTTP(w, r)
+}
+
+func newTestClient(t *testing.T, h http.Handler, opts ...func(*Client)) *Client {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	c, err := New(srv.URL, append([]func(*Client){WithRetries(3, time.Millisecond)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keysPath := filepath.Join(dir, "keys.json")
+	keys := `[{"key": "k", "tenant": "team", "scopes": ["read", "download", "admin-upload"]}]`
+	if err := os.WriteFile(keysPath, []byte(keys), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ks, err := walle.LoadKeyStore(keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("s3cret")
+	s := walle.NewServer(walle.WithAuth(ks), walle.WithTenantRegistries(filepath.Join(dir, "registries")),
+		walle.WithURLSigning(secret), walle.WithTenantChannels("team", "xiaomi"))
+	retried := &flaky{h: s}
+	c := newTestClient(t, retried, WithAPIKey("k"))
+	// anon downloads signed URLs only
+	anon := newTestClient(t, s)
+
+	v1, v2 := testApk(t, "v1"), testApk(t, "v2")
+	if _, err := c.Upload(ctx, "demo", "1.0", bytes.NewReader(v1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Upload(ctx, "demo", "1.0", bytes.NewReader(v1)); !errors.Is(err, ErrExists) {
+		t.Errorf("Upload existing version got %v, want ErrExists", err)
+	}
+	if _, err := c.Upload(ctx, "demo", "2.0", bytes.NewReader(v2)); err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt32(&retried.n, 2)
+	meta, err := c.Versions(ctx, "demo")
+	if err != nil {
+		t.Fatalf("Versions after 2 failures: %v", err)
+	}
+	if len(meta.Versions) != 2 || meta.Aliases[walle.AliasLatest] != "2.0" {
+		t.Errorf("Versions got %+v", meta)
+	}
+	if meta, err = c.SetAlias(ctx, "demo", "stable", "1.0"); err != nil || meta.Aliases["stable"] != "1.0" {
+		t.Errorf("SetAlias got %+v, %v", meta, err)
+	}
+	if list, err := c.List(ctx); err != nil || len(list) != 1 {
+		t.Errorf("List got %v, %v", list, err)
+	}
+	if _, err := c.Versions(ctx, "nope"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Versions of unknown base got %v, want ErrNotFound", err)
+	}
+	if set, err := c.Channels(ctx); err != nil || set.Any || len(set.Channels) != 1 {
+		t.Errorf("Channels got %+v, %v", set, err)
+	}
+
+	res, err := c.Sign(ctx, walle.SignRequest{Base: "demo@stable", Channel: "xiaomi", Extras: map[string]string{"k": "v"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var apk bytes.Buffer
+	if _, err := c.DownloadURL(ctx, res.URL, &apk); err != nil {
+		t.Fatal(err)
+	}
+	ins, err := c.Inspect(ctx, bytes.NewReader(apk.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.Channel != "xiaomi" || ins.Extras["k"] != "v" || ins.Size != int64(apk.Len()) {
+		t.Errorf("Inspect got %+v", ins)
+	}
+	if _, err := c.Inspect(ctx, bytes.NewReader([]byte("not an apk"))); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Inspect of invalid apk got %v, want ErrInvalid", err)
+	}
+
+	_, err = anon.Download(ctx, "demo", "xiaomi", nil, io.Discard)
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Download without signature got %v, want ErrUnauthorized", err)
+	}
+	expired := walle.SignTenantURL(secret, "team", "demo", "xiaomi", nil, time.Now().Add(-time.Minute))
+	if _, err := anon.DownloadURL(ctx, expired, io.Discard); !errors.Is(err, ErrExpired) {
+		t.Errorf("Download of expired URL got %v, want ErrExpired", err)
+	}
+	var e *Error
+	if _, err := c.Sign(ctx, walle.SignRequest{Base: "demo", Channel: "oppo"}); !errors.As(err, &e) || e.StatusCode != http.StatusNotFound {
+		t.Errorf("Sign of unknown channel got %v", err)
+	}
+
+	if err := c.Delete(ctx, "demo", "1.0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.DownloadURL(ctx, res.URL, io.Discard); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Download of deleted version got %v, want ErrNotFound", err)
+	}
+}
+
+func TestClient_Download(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.apk")
+	if err := os.WriteFile(base, testApk(t, "base"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := walle.NewServer()
+	if err := s.Register("demo", base); err != nil {
+		t.Fatal(err)
+	}
+	plain := newTestClient(t, s)
+	var want bytes.Buffer
+	if _, err := plain.Download(ctx, "demo", "xiaomi", map[string]string{"k": "v"}, &want); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("resume", func(t *testing.T) {
+		c := newTestClient(t, &cutting{h: s})
+		var got bytes.Buffer
+		n, err := c.Download(ctx, "demo", "xiaomi", map[string]string{"k": "v"}, &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(want.Len()) || !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("resumed download got %d bytes, want %d", n, want.Len())
+		}
+	})
+	t.Run("no retries", func(t *testing.T) {
+		c := newTestClient(t, &cutting{h: s}, WithRetries(0, 0))
+		n, err := c.Download(ctx, "demo", "xiaomi", nil, io.Discard)
+		if err == nil || n != 3000 {
+			t.Errorf("cut download got %d bytes, %v", n, err)
+		}
+	})
+	t.Run("canceled", func(t *testing.T) {
+		c := newTestClient(t, &flaky{h: s, n: 100}, WithRetries(10, time.Hour))
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := c.Download(ctx, "demo", "xiaomi", nil, io.Discard)
+		var e *Error
+		if !errors.As(err, &e) || e.StatusCode != http.StatusServiceUnavailable || time.Since(start) > 5*time.Second {
+			t.Errorf("canceled download got %v after %s", err, time.Since(start))
+		}
+	})
+}
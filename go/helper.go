@@ -1,7 +1,9 @@
 package _go
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 )
@@ -100,6 +102,26 @@ func openFile(file string, fp func(*os.File) (interface{}, error)) (ret interfac
 	return fp(f)
 }
 
+func fileSize(f *os.File) (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// readFullAt reads exactly len(buf) bytes at off, an io.EOF together with a full buffer is fine.
+func readFullAt(r io.ReaderAt, buf []byte, off int64) error {
+	n, err := r.ReadAt(buf, off)
+	if n == len(buf) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		err = fmt.Errorf("Read bytes count mismatched! Expect %d, but %d", len(buf), n)
+	}
+	return err
+}
+
 func fileNameAndExt(path string) (string, string) {
 	name := filepath.Base(path)
 	for i := len(name) - 1; i >= 0 && !os.IsPathSeparator(name[i]); i-- {
@@ -109,3 +131,76 @@ func fileNameAndExt(path string) (string, string) {
 	}
 	return name, ""
 }
+
+// multiReaderAt is the logical concatenation of its parts.
+type multiReaderAt struct {
+	parts  []sizeReaderAt
+	starts []int64
+	size   int64
+}
+
+type sizeReaderAt interface {
+	io.ReaderAt
+	Size() int64
+}
+
+func newMultiReaderAt(parts ...sizeReaderAt) *multiReaderAt {
+	m := &multiReaderAt{parts: parts, starts: make([]int64, len(parts))}
+	for i, p := range parts {
+		m.starts[i] = m.size
+		m.size += p.Size()
+	}
+	return m
+}
+
+func (m *multiReaderAt) Size() int64 {
+	return m.size
+}
+
+func (m *multiReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= m.size {
+		return 0, io.EOF
+	}
+	for i, part := range m.parts {
+		if n == len(p) {
+			break
+		}
+		end := m.starts[i] + part.Size()
+		if off >= end {
+			continue
+		}
+		want := int64(len(p) - n)
+		if want > end-off {
+			want = end - off
+		}
+		if err := readFullAt(part, p[n:n+int(want)], off-m.starts[i]); err != nil {
+			return n, err
+		}
+		n += int(want)
+		off += want
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// tempFile is a temporary file that is removed on close.
+type tempFile struct {
+	*os.File
+}
+
+func (f tempFile) Close() error {
+	err := f.File.Close()
+	if removeErr := os.Remove(f.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package _go
+
+import "os"
+
+// fileInode is not available, files are told apart by path, size and mtime only.
+func fileInode(fi os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,228 @@
+package _go
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Known IDs of APK Signing Block entries besides the ones defined in reader.go.
+const (
+	APK_SIGNATURE_SCHEME_V3_BLOCK_ID  = 0xf05368c0
+	APK_SIGNATURE_SCHEME_V31_BLOCK_ID = 0x1b93ad61
+	SOURCE_STAMP_V1_BLOCK_ID          = 0x2b09189e
+	SOURCE_STAMP_V2_BLOCK_ID          = 0x6dff800d
+	DEPENDENCY_INFO_BLOCK_ID          = 0x504b4453
+	PLAY_FROSTING_BLOCK_ID            = 0x2146444e
+	VASDOLLY_CHANNEL_BLOCK_ID         = 0x881155ff
+)
+
+var blockNames = map[uint32]string{
+	APK_SIGNATURE_SCHEME_V2_BLOCK_ID:  "APK Signature Scheme v2",
+	APK_SIGNATURE_SCHEME_V3_BLOCK_ID:  "APK Signature Scheme v3",
+	APK_SIGNATURE_SCHEME_V31_BLOCK_ID: "APK Signature Scheme v3.1",
+	APK_CHANNEL_BLOCK_ID:              "walle channel",
+	VERITY_PADDING_BLOCK_ID:           "verity padding",
+	SOURCE_STAMP_V1_BLOCK_ID:          "source stamp v1",
+	SOURCE_STAMP_V2_BLOCK_ID:          "source stamp v2",
+	DEPENDENCY_INFO_BLOCK_ID:          "dependency info",
+	PLAY_FROSTING_BLOCK_ID:            "Google Play frosting",
+	VASDOLLY_CHANNEL_BLOCK_ID:         "VasDolly channel",
+}
+
+// DefaultInspectLimit is the default size limit of apks uploaded to the inspect endpoint.
+const DefaultInspectLimit = 256 << 20
+
+// Inspection is what Inspect finds in an apk.
+type Inspection struct {
+	Size    int64             `json:"size"`
+	Channel string            `json:"channel,omitempty"`
+	Extras  map[string]string `json:"extras,omitempty"`
+	// Entries are the ID-value pairs of APK Signing Block in order.
+	Entries []BlockEntry `json:"entries"`
+	// Schemes are the signature schemes present, "v1", "v2", "v3" and "v3.1".
+	Schemes []string `json:"schemes"`
+	// Anomalies are structural problems, such as data between sections.
+	Anomalies []string `json:"anomalies"`
+}
+
+// BlockEntry is an ID-value pair of APK Signing Block.
+type BlockEntry struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	Size int    `json:"size"`
+}
+
+// Inspect reads the channel and signing details of the apk in r. An error is only
+// returned if r is not a zip, other problems are reported as anomalies.
+func Inspect(r io.ReaderAt, size int64) (*Inspection, error) {
+	eocd, eocdOffset, err := findEndOfCentralDirectoryRecord(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if eocdOffset <= 0 {
+		return nil, errors.New("Cannot find EOCD record, maybe a broken zip file.")
+	}
+	ins := &Inspection{Size: size, Entries: []BlockEntry{}, Schemes: []string{}, Anomalies: []string{}}
+	anomaly := func(format string, args ...interface{}) {
+		ins.Anomalies = append(ins.Anomalies, fmt.Sprintf(format, args...))
+	}
+
+	// EOCD and central directory
+	if n := len(eocd) - _ZIP_EOCD_REC_MIN_SIZE; n > 0 {
+		anomaly("zip comment of %d bytes after EOCD", n)
+	}
+	cdOffset := int64(getEocdCentralDirectoryOffset(eocd))
+	cdSize := int64(getEocdCentralDirectorySize(eocd))
+	if end := cdOffset + cdSize; end != eocdOffset {
+		anomaly("central directory ends at %d, but EOCD starts at %d", end, eocdOffset)
+	}
+	if getUint16(eocd, 4) != 0 || getUint16(eocd, 6) != 0 || getUint16(eocd, 8) != getUint16(eocd, 10) {
+		anomaly("multi-disk zip fields in EOCD")
+	}
+	if zr, err := zip.NewReader(r, size); err != nil {
+		anomaly("central directory unreadable: %s", err)
+	} else if hasV1Signature(zr) {
+		ins.Schemes = append(ins.Schemes, "v1")
+	}
+
+	// APK Signing Block
+	block, _, err := findApkSigningBlock(r, uint32(cdOffset))
+	if err != nil {
+		anomaly("%s", err)
+		return ins, nil
+	}
+	seen := make(map[uint32]bool)
+	padded := false
+	err = forEachIdValue(block, func(id uint32, value []byte) bool {
+		ins.Entries = append(ins.Entries, BlockEntry{ID: fmt.Sprintf("0x%08x", id), Name: blockNames[id], Size: len(value)})
+		if padded {
+			anomaly("entry 0x%08x after verity padding is ignored by readers", id)
+		}
+		if seen[id] {
+			anomaly("duplicate entry 0x%08x", id)
+		}
+		seen[id] = true
+		switch id {
+		case VERITY_PADDING_BLOCK_ID:
+			padded = true
+		case APK_CHANNEL_BLOCK_ID:
+			if info, err := parseChannelBlock(value); err != nil {
+				anomaly("walle channel is not valid JSON: %s", err)
+			} else if !padded {
+				ins.Channel, ins.Extras = info.channel, info.extras
+			}
+		}
+		return true
+	})
+	if err != nil {
+		anomaly("%s", err)
+	}
+	if len(block)%ANDROID_COMMON_PAGE_ALIGNMENT_BYTES != 0 && seen[VERITY_PADDING_BLOCK_ID] {
+		anomaly("padded APK Signing Block of %d bytes is not page aligned", len(block))
+	}
+	for _, s := range []struct {
+		id   uint32
+		name string
+	}{
+		{APK_SIGNATURE_SCHEME_V2_BLOCK_ID, "v2"},
+		{APK_SIGNATURE_SCHEME_V3_BLOCK_ID, "v3"},
+		{APK_SIGNATURE_SCHEME_V31_BLOCK_ID, "v3.1"},
+	} {
+		if seen[s.id] {
+			ins.Schemes = append(ins.Schemes, s.name)
+		}
+	}
+	if !seen[APK_SIGNATURE_SCHEME_V2_BLOCK_ID] && !seen[APK_SIGNATURE_SCHEME_V3_BLOCK_ID] {
+		anomaly("APK Signing Block has no v2 or v3 signature")
+	}
+	return ins, nil
+}
+
+// hasV1Signature reports whether there is a JAR signature file in META-INF.
+func hasV1Signature(zr *zip.Reader) bool {
+	for _, f := range zr.File {
+		dir, name := path.Split(f.Name)
+		if dir == "META-INF/" && strings.HasSuffix(strings.ToUpper(name), ".SF") {
+			return true
+		}
+	}
+	return false
+}
+
+// InspectHandler inspects the apk uploaded in the request body, or in the "apk" field of
+// a multipart form, and writes the Inspection as JSON. The upload is read into memory
+// up to limit bytes and never written to disk.
+func InspectHandler(limit int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		b, err := readUpload(w, r, limit)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		ins, err := Inspect(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			writeError(w, newErrf("%s: %w", err, ErrInvalid))
+			return
+		}
+		writeJSON(w, http.StatusOK, ins)
+	})
+}
+
+// ErrTooLarge is returned when an upload is over the size limit.
+var ErrTooLarge = errors.New("too large")
+
+// readUpload reads the request body, or the "apk" part of a multipart form, into memory.
+func readUpload(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, error) {
+	// the multipart reader reads r.Body too, so it is limited in place
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	var src io.Reader = r.Body
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "multipart/form-data" {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, newErrf("%s: %w", err, ErrInvalid)
+		}
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				return nil, newErrf("no apk field in form: %w", ErrInvalid)
+			}
+			if err != nil {
+				return nil, uploadError(err, limit)
+			}
+			if p.FormName() == "apk" {
+				src = p
+				break
+			}
+		}
+	}
+	b, err := io.ReadAll(src)
+	if err != nil {
+		return nil, uploadError(err, limit)
+	}
+	return b, nil
+}
+
+func uploadError(err error, limit int64) error {
+	if isTooLarge(err) {
+		return newErrf("upload larger than %d bytes: %w", limit, ErrTooLarge)
+	}
+	return newErrf("%s: %w", err, ErrInvalid)
+}
+
+// isTooLarge reports whether err is of a body over the limit of http.MaxBytesReader.
+func isTooLarge(err error) bool {
+	// http.MaxBytesReader has no typed error before go1.19
+	return strings.Contains(err.Error(), "request body too large")
+}
@@ -0,0 +1,103 @@
+package _go
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	dir := t.TempDir()
+	channeled := testChannelApk(t, newTestApk(t, dir), "xiaomi", map[string]string{"k": "v"})
+	broken, err := os.ReadFile(newTestApk(t, t.TempDir(),
+		appendIdValue(nil, VERITY_PADDING_BLOCK_ID, make([]byte, 8)),
+		appendIdValue(nil, APK_CHANNEL_BLOCK_ID, []byte(`{"channel":"hidden"}`)),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// zip comment
+	broken = append(broken, "hi"...)
+	putUint16(2, broken, len(broken)-2-2)
+
+	ins, err := Inspect(bytes.NewReader(channeled), int64(len(channeled)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.Channel != "xiaomi" || ins.Extras["k"] != "v" || len(ins.Anomalies) != 0 ||
+		len(ins.Schemes) != 1 || ins.Schemes[0] != "v2" {
+		t.Errorf("Inspect() = %+v", ins)
+	}
+	if len(ins.Entries) != 3 || ins.Entries[0].Name != "APK Signature Scheme v2" ||
+		ins.Entries[1].Name != "walle channel" || ins.Entries[2].Name != "verity padding" {
+		t.Errorf("Inspect() entries = %+v", ins.Entries)
+	}
+
+	ins, err = Inspect(bytes.NewReader(broken), int64(len(broken)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.Channel != "" || len(ins.Anomalies) != 3 {
+		t.Errorf("Inspect() = %+v", ins)
+	}
+
+	if _, err := Inspect(bytes.NewReader([]byte("not a zip at all, not a zip at all")), 34); err == nil {
+		t.Errorf("Inspect() of non zip should fail")
+	}
+}
+
+func TestInspectHandler(t *testing.T) {
+	channeled := testChannelApk(t, newTestApk(t, t.TempDir()), "xiaomi", nil)
+	srv := httptest.NewServer(NewServer(WithInspectLimit(int64(len(channeled)) + 1024)))
+	defer srv.Close()
+
+	multipartForm := func(apk []byte) (string, []byte) {
+		var form bytes.Buffer
+		mw := multipart.NewWriter(&form)
+		fw, _ := mw.CreateFormFile("apk", "a.apk")
+		fw.Write(apk)
+		mw.Close()
+		return mw.FormDataContentType(), form.Bytes()
+	}
+	formType, form := multipartForm(channeled)
+	largeFormType, largeForm := multipartForm(append(channeled, make([]byte, 2048)...))
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		wantStatus  int
+	}{
+		{"raw", "application/vnd.android.package-archive", channeled, http.StatusOK},
+		{"multipart", formType, form, http.StatusOK},
+		{"not zip", "application/octet-stream", []byte("hello"), http.StatusBadRequest},
+		{"too large", "application/octet-stream", append(channeled, make([]byte, 2048)...), http.StatusRequestEntityTooLarge},
+		{"multipart too large", largeFormType, largeForm, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(srv.URL+"/inspect", tt.contentType, bytes.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("POST got status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var ins Inspection
+			if err := json.NewDecoder(resp.Body).Decode(&ins); err != nil {
+				t.Fatal(err)
+			}
+			if ins.Channel != "xiaomi" {
+				t.Errorf("POST got %+v", ins)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package _go
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ErrWrongPassword is returned when a keystore or key password is wrong.
+var ErrWrongPassword = errors.New("wrong password")
+
+// Keystore is a JKS or PKCS#12 keystore as keytool and Gradle signing configs use,
+// its private keys are decrypted on Key.
+type Keystore struct {
+	entries map[string]*keystoreEntry
+}
+
+type keystoreEntry struct {
+	// decrypt returns the PKCS#8 private key, nil for trusted certificate entries.
+	decrypt func(password string) ([]byte, error)
+	// certs are the certificate chain of the key, or the trusted certificate.
+	certs []*x509.Certificate
+}
+
+// keystoreAlias folds alias as keytool does, aliases of JKS and PKCS#12 keystores are
+// case-insensitive.
+func keystoreAlias(alias string) string {
+	return strings.ToLower(alias)
+}
+
+// JKS magic numbers of keystores.
+const (
+	jksMagic   = 0xfeedfeed
+	jceksMagic = 0xcececece
+)
+
+// LoadKeystore reads the keystore at path, see ParseKeystore.
+func LoadKeystore(path, storePassword string) (*Keystore, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ks, err := ParseKeystore(b, storePassword)
+	if err != nil {
+		return nil, newErrf("%s: %w", path, err)
+	}
+	return ks, nil
+}
+
+// ParseKeystore parses a JKS or PKCS#12 keystore, the format is told by its content.
+// The integrity of the keystore is checked with storePassword, which also decrypts
+// the certificates of PKCS#12 keystores. A wrong password fails with ErrWrongPassword.
+// JCEKS keystores are not supported.
+func ParseKeystore(b []byte, storePassword string) (*Keystore, error) {
+	if len(b) >= 4 {
+		switch binary.BigEndian.Uint32(b) {
+		case jksMagic:
+			return parseJKS(b, storePassword)
+		case jceksMagic:
+			return nil, errors.New("JCEKS keystore is not supported, convert it to PKCS#12 with keytool -importkeystore")
+		}
+	}
+	return parsePKCS12(b, storePassword)
+}
+
+// Aliases returns the sorted aliases of the keystore entries.
+func (k *Keystore) Aliases() []string {
+	ret := make([]string, 0, len(k.entries))
+	for alias := range k.entries {
+		ret = append(ret, alias)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Key decrypts the private key of alias with keyPassword, which is usually the store
+// password for PKCS#12 keystores, and returns it with its certificate chain, the first
+// certificate is of the key. A wrong password fails with ErrWrongPassword, and an
+// unknown alias with ErrNotFound.
+func (k *Keystore) Key(alias, keyPassword string) (crypto.PrivateKey, []*x509.Certificate, error) {
+	e, ok := k.entries[keystoreAlias(alias)]
+	if !ok {
+		return nil, nil, newErrf("alias %s: %w", alias, ErrNotFound)
+	}
+	if e.decrypt == nil {
+		return nil, nil, newErrf("alias %s is a trusted certificate entry without private key", alias)
+	}
+	der, err := e.decrypt(keyPassword)
+	if err != nil {
+		return nil, nil, newErrf("alias %s: %w", alias, err)
+	}
+	key, err := ParsePrivateKey(der)
+	if err != nil {
+		return nil, nil, newErrf("alias %s: %w", alias, err)
+	}
+	pub, err := publicKeyOf(key)
+	if err != nil {
+		return nil, nil, newErrf("alias %s: %w", alias, err)
+	}
+	certs := keyChain(pub, e.certs)
+	if len(certs) == 0 {
+		return nil, nil, newErrf("alias %s has no certificate of its private key", alias)
+	}
+	return key, certs, nil
+}
+
+// keyChain orders certs as the chain of the certificate of pub, the certificates that
+// are not in the chain are dropped.
+func keyChain(pub crypto.PublicKey, certs []*x509.Certificate) []*x509.Certificate {
+	var chain []*x509.Certificate
+	for _, c := range certs {
+		if equalPublicKeys(pub, c.PublicKey) {
+			chain = append(chain, c)
+			break
+		}
+	}
+	for len(chain) > 0 && len(chain) <= len(certs) {
+		last := chain[len(chain)-1]
+		if bytes.Equal(last.RawIssuer, last.RawSubject) {
+			break
+		}
+		var issuer *x509.Certificate
+		for _, c := range certs {
+			if bytes.Equal(c.RawSubject, last.RawIssuer) {
+				issuer = c
+				break
+			}
+		}
+		if issuer == nil {
+			break
+		}
+		chain = append(chain, issuer)
+	}
+	return chain
+}
@@ -0,0 +1,175 @@
+package _go
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// JKS entry tags.
+const (
+	jksPrivateKeyEntry  = 1
+	jksTrustedCertEntry = 2
+)
+
+// oidJKSKeyProtector is the proprietary key protection algorithm of JKS keystores.
+var oidJKSKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+// jksReader reads the big-endian fields of a JKS keystore.
+type jksReader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (r *jksReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.b)-r.off {
+		r.err = errors.New("truncated JKS keystore")
+		return nil
+	}
+	r.off += n
+	return r.b[r.off-n : r.off]
+}
+
+func (r *jksReader) uint16() int {
+	if b := r.next(2); b != nil {
+		return int(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *jksReader) uint32() int {
+	if b := r.next(4); b != nil {
+		return int(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+// utf reads a Java modified UTF-8 string, which is UTF-8 for the usual aliases.
+func (r *jksReader) utf() string {
+	return string(r.next(r.uint16()))
+}
+
+// certificate reads a certificate, which has no type before version 2.
+func (r *jksReader) certificate(version int) *x509.Certificate {
+	if version == 2 {
+		if typ := r.utf(); r.err == nil && typ != "X.509" {
+			r.err = fmt.Errorf("unsupported certificate type %s", typ)
+		}
+	}
+	der := r.next(r.uint32())
+	if r.err != nil {
+		return nil
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		r.err = err
+	}
+	return c
+}
+
+// parseJKS parses a JKS keystore:
+//
+//	uint32 magic 0xfeedfeed, uint32 version 1 or 2, uint32 entry count
+//	repeated entries:
+//	    uint32 tag, UTF alias, uint64 creation time in milliseconds
+//	    private key entry: uint32-prefixed EncryptedPrivateKeyInfo, uint32 chain length, certificates
+//	    trusted certificate entry: certificate
+//	    certificate: UTF type since version 2, uint32-prefixed encoding
+//	SHA-1 of the password, "Mighty Aphrodite" and the bytes above
+//
+// See https://github.com/openjdk/jdk/blob/master/src/java.base/share/classes/sun/security/provider/JavaKeyStore.java
+func parseJKS(b []byte, password string) (*Keystore, error) {
+	if len(b) < sha1.Size {
+		return nil, errors.New("truncated JKS keystore")
+	}
+	data, digest := b[:len(b)-sha1.Size], b[len(b)-sha1.Size:]
+	h := sha1.New()
+	h.Write(utf16BE(password))
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(data)
+	if subtle.ConstantTimeCompare(h.Sum(nil), digest) != 1 {
+		return nil, newErrf("keystore integrity check failed: %w", ErrWrongPassword)
+	}
+
+	r := &jksReader{b: data}
+	r.uint32()
+	version := r.uint32()
+	if r.err == nil && version != 1 && version != 2 {
+		return nil, fmt.Errorf("unsupported JKS version %d", version)
+	}
+	ks := &Keystore{entries: make(map[string]*keystoreEntry)}
+	for i, n := 0, r.uint32(); i < n && r.err == nil; i++ {
+		tag := r.uint32()
+		alias := r.utf()
+		r.next(8)
+		e := new(keystoreEntry)
+		switch tag {
+		case jksPrivateKeyEntry:
+			encrypted := r.next(r.uint32())
+			for j, n := 0, r.uint32(); j < n && r.err == nil; j++ {
+				e.certs = append(e.certs, r.certificate(version))
+			}
+			e.decrypt = func(password string) ([]byte, error) {
+				return decryptJKSKey(encrypted, password)
+			}
+		case jksTrustedCertEntry:
+			e.certs = []*x509.Certificate{r.certificate(version)}
+		default:
+			if r.err == nil {
+				r.err = fmt.Errorf("unsupported JKS entry tag %d", tag)
+			}
+		}
+		// keytool stores aliases lowercased, but older tools may not
+		ks.entries[keystoreAlias(alias)] = e
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return ks, nil
+}
+
+// decryptJKSKey decrypts a private key protected with the JKS key protector: a 20-byte
+// salt, the key XORed with the chained SHA-1 of the password and salt, and the SHA-1 of
+// the password and plain key.
+func decryptJKSKey(b []byte, password string) ([]byte, error) {
+	var info struct {
+		Algorithm     pkix.AlgorithmIdentifier
+		EncryptedData []byte
+	}
+	if _, err := asn1.Unmarshal(b, &info); err != nil {
+		return nil, fmt.Errorf("malformed encrypted private key: %s", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidJKSKeyProtector) {
+		return nil, fmt.Errorf("%w %s", errPBEUnsupported, info.Algorithm.Algorithm)
+	}
+	data := info.EncryptedData
+	if len(data) < 2*sha1.Size {
+		return nil, errors.New("malformed encrypted private key")
+	}
+	salt, encrypted, check := data[:sha1.Size], data[sha1.Size:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	pw := utf16BE(password)
+	key := make([]byte, len(encrypted))
+	d := salt
+	for i := 0; i < len(encrypted); i += sha1.Size {
+		sum := sha1.Sum(append(append([]byte{}, pw...), d...))
+		d = sum[:]
+		for j := 0; j < sha1.Size && i+j < len(encrypted); j++ {
+			key[i+j] = encrypted[i+j] ^ d[j]
+		}
+	}
+	sum := sha1.Sum(append(append([]byte{}, pw...), key...))
+	if !bytes.Equal(sum[:], check) {
+		return nil, ErrWrongPassword
+	}
+	return key, nil
+}
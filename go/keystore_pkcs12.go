@@ -0,0 +1,249 @@
+package _go
+
+import (
+	"crypto/hmac"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+// PKCS#12 structures, see RFC 7292.
+var (
+	oidEncryptedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+
+	oidKeyBag              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidPKCS8ShroudedKeyBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509Certificate     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+
+	oidFriendlyName = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+)
+
+type pfxPdu struct {
+	Version  int
+	AuthSafe pkcs7ContentInfo
+	MacData  pkcs12MacData `asn1:"optional"`
+}
+
+type pkcs12MacData struct {
+	Mac struct {
+		Algorithm pkix.AlgorithmIdentifier
+		Digest    []byte
+	}
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type pkcs12EncryptedData struct {
+	Version              int
+	EncryptedContentInfo struct {
+		ContentType                asn1.ObjectIdentifier
+		ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+		EncryptedContent           asn1.RawValue `asn1:"optional,tag:0"`
+	}
+}
+
+type pkcs12SafeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue    `asn1:"tag:0"`
+	Attributes []pkcs7Attribute `asn1:"set,optional"`
+}
+
+type pkcs12CertBag struct {
+	ID   asn1.ObjectIdentifier
+	Data asn1.RawValue `asn1:"tag:0"`
+}
+
+type pkcs8Encrypted struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// pkcs12Bag is a key or certificate bag with its attributes.
+type pkcs12Bag struct {
+	// key is the PKCS#8 private key of a key bag, or encrypted of a shrouded key bag.
+	key       []byte
+	encrypted *pkcs8Encrypted
+	cert      *x509.Certificate
+	name      string
+	localID   string
+}
+
+// parsePKCS12 parses a PKCS#12 keystore, whose MAC is checked and certificates are
+// decrypted with password. Every key bag is an entry named by its friendly name, with
+// all certificates as the chain is told by Key, and named certificate bags without local
+// key ID are trusted certificate entries.
+func parsePKCS12(b []byte, password string) (*Keystore, error) {
+	var pfx pfxPdu
+	if rest, err := asn1.Unmarshal(b, &pfx); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("not a JKS or PKCS#12 keystore: %v", err)
+	}
+	if pfx.Version != 3 {
+		return nil, fmt.Errorf("unsupported PKCS#12 version %d", pfx.Version)
+	}
+	if !pfx.AuthSafe.ContentType.Equal(oidData) {
+		return nil, errors.New("PKCS#12 keystores signed with public keys are not supported")
+	}
+	var authSafe []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafe); err != nil {
+		return nil, fmt.Errorf("malformed PKCS#12 authenticated safe: %s", err)
+	}
+	if len(pfx.MacData.Mac.Digest) > 0 {
+		if err := checkPKCS12Mac(&pfx.MacData, authSafe, password); err != nil {
+			return nil, err
+		}
+	}
+
+	var contents []pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(authSafe, &contents); err != nil {
+		return nil, fmt.Errorf("malformed PKCS#12 authenticated safe: %s", err)
+	}
+	var bags []*pkcs12Bag
+	for _, ci := range contents {
+		var safe []byte
+		switch {
+		case ci.ContentType.Equal(oidData):
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &safe); err != nil {
+				return nil, fmt.Errorf("malformed PKCS#12 safe contents: %s", err)
+			}
+		case ci.ContentType.Equal(oidEncryptedData):
+			var ed pkcs12EncryptedData
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+				return nil, fmt.Errorf("malformed PKCS#12 encrypted data: %s", err)
+			}
+			eci := ed.EncryptedContentInfo
+			var err error
+			if safe, err = decryptPBE(eci.ContentEncryptionAlgorithm, password, eci.EncryptedContent.Bytes); err != nil {
+				if errors.Is(err, ErrWrongPassword) {
+					return nil, newErrf("PKCS#12 certificates: %w", err)
+				}
+				return nil, fmt.Errorf("PKCS#12 certificates: %s", err)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported PKCS#12 content type %s", ci.ContentType)
+		}
+		b, err := parsePKCS12SafeContents(safe)
+		if err != nil {
+			return nil, err
+		}
+		bags = append(bags, b...)
+	}
+
+	ks := &Keystore{entries: make(map[string]*keystoreEntry)}
+	for i, bag := range bags {
+		if bag.cert != nil {
+			continue
+		}
+		e := &keystoreEntry{}
+		if bag.encrypted != nil {
+			encrypted := bag.encrypted
+			e.decrypt = func(password string) ([]byte, error) {
+				return decryptPBE(encrypted.Algorithm, password, encrypted.EncryptedData)
+			}
+		} else {
+			key := bag.key
+			e.decrypt = func(string) ([]byte, error) { return key, nil }
+		}
+		for _, c := range bags {
+			if c.cert != nil {
+				e.certs = append(e.certs, c.cert)
+			}
+		}
+		name := bag.name
+		if name == "" {
+			name = fmt.Sprint(i + 1)
+		}
+		ks.entries[keystoreAlias(name)] = e
+	}
+	for _, c := range bags {
+		if c.cert != nil && c.localID == "" && c.name != "" {
+			if _, ok := ks.entries[keystoreAlias(c.name)]; !ok {
+				ks.entries[keystoreAlias(c.name)] = &keystoreEntry{certs: []*x509.Certificate{c.cert}}
+			}
+		}
+	}
+	return ks, nil
+}
+
+// checkPKCS12Mac checks the HMAC of the authenticated safe, keyed with the PKCS#12 key
+// derivation of password.
+func checkPKCS12Mac(md *pkcs12MacData, authSafe []byte, password string) error {
+	h, ok := pkcs7DigestAlgorithms[md.Mac.Algorithm.Algorithm.String()]
+	if !ok {
+		return fmt.Errorf("unsupported PKCS#12 MAC algorithm %s", md.Mac.Algorithm.Algorithm)
+	}
+	key := pkcs12KDF(h.New, bmpPassword(password), md.MacSalt, md.Iterations, 3, h.Size())
+	mac := hmac.New(h.New, key)
+	mac.Write(authSafe)
+	if !hmac.Equal(mac.Sum(nil), md.Mac.Digest) {
+		return newErrf("keystore integrity check failed: %w", ErrWrongPassword)
+	}
+	return nil
+}
+
+func parsePKCS12SafeContents(b []byte) ([]*pkcs12Bag, error) {
+	var safeBags []pkcs12SafeBag
+	if _, err := asn1.Unmarshal(b, &safeBags); err != nil {
+		return nil, fmt.Errorf("malformed PKCS#12 safe contents: %s", err)
+	}
+	var bags []*pkcs12Bag
+	for _, sb := range safeBags {
+		bag := new(pkcs12Bag)
+		switch {
+		case sb.ID.Equal(oidKeyBag):
+			bag.key = sb.Value.Bytes
+		case sb.ID.Equal(oidPKCS8ShroudedKeyBag):
+			bag.encrypted = new(pkcs8Encrypted)
+			if _, err := asn1.Unmarshal(sb.Value.Bytes, bag.encrypted); err != nil {
+				return nil, fmt.Errorf("malformed PKCS#12 shrouded key bag: %s", err)
+			}
+		case sb.ID.Equal(oidCertBag):
+			var cb pkcs12CertBag
+			if _, err := asn1.Unmarshal(sb.Value.Bytes, &cb); err != nil {
+				return nil, fmt.Errorf("malformed PKCS#12 certificate bag: %s", err)
+			}
+			if !cb.ID.Equal(oidX509Certificate) {
+				continue
+			}
+			var der []byte
+			if _, err := asn1.Unmarshal(cb.Data.Bytes, &der); err != nil {
+				return nil, fmt.Errorf("malformed PKCS#12 certificate bag: %s", err)
+			}
+			c, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, err
+			}
+			bag.cert = c
+		default:
+			// CRL, secret and nested bags
+			continue
+		}
+		for _, a := range sb.Attributes {
+			switch {
+			case a.Type.Equal(oidFriendlyName):
+				var v asn1.RawValue
+				if _, err := asn1.Unmarshal(a.Values.Bytes, &v); err != nil || v.Tag != asn1.TagBMPString || len(v.Bytes)%2 != 0 {
+					return nil, errors.New("malformed PKCS#12 friendly name")
+				}
+				u := make([]uint16, len(v.Bytes)/2)
+				for i := range u {
+					u[i] = uint16(v.Bytes[2*i])<<8 | uint16(v.Bytes[2*i+1])
+				}
+				bag.name = string(utf16.Decode(u))
+			case a.Type.Equal(oidLocalKeyID):
+				var id []byte
+				if _, err := asn1.Unmarshal(a.Values.Bytes, &id); err != nil {
+					return nil, errors.New("malformed PKCS#12 local key ID")
+				}
+				bag.localID = hex.EncodeToString(id)
+			}
+		}
+		bags = append(bags, bag)
+	}
+	return bags, nil
+}
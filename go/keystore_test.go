@@ -0,0 +1,139 @@
+package _go
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// keytool.jks is made by keytool with a self-signed RSA key, its alias is lowercased:
+//
+//	keytool -genkeypair -storetype JKS -keystore keytool.jks -storepass storepass -keypass keypass \
+//	    -alias Walle -keyalg RSA -keysize 2048 -validity 36500 -dname "CN=walle test keytool"
+//
+// The other keystores have the RSA or EC key "walle" signed by "walle test CA", made
+// with openssl req and x509 -req:
+//
+//	openssl pkcs12 -export -name walle -passout pass:storepass -out keystore.p12 ...
+//	openssl pkcs12 -export -legacy -name walle -passout pass:storepass -out legacy.p12 ...
+//	go run gen_jks.go -storepass storepass -keypass keypass -out keystore.jks ...
+//
+// keystore.p12 is encrypted with AES-256 and PBKDF2, legacy.p12 with 3DES and RC2 as
+// keytool did before Java 12. keystore.jks has an EC key and the trusted certificate
+// "ca", which keytool -genkeypair does not make in one step.
+func TestKeystore(t *testing.T) {
+	tests := []struct {
+		file    string
+		keyPass string
+		aliases []string
+		isRSA   bool
+		chain   []string
+	}{
+		{"keytool.jks", "keypass", []string{"walle"}, true, []string{"walle test keytool"}},
+		{"keystore.p12", "storepass", []string{"walle"}, true, []string{"walle test", "walle test CA"}},
+		{"legacy.p12", "storepass", []string{"walle"}, true, []string{"walle test", "walle test CA"}},
+		{"keystore.jks", "keypass", []string{"ca", "walle"}, false, []string{"walle test EC", "walle test CA"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			path := filepath.Join("testdata", tt.file)
+			if _, err := os.Stat(path); os.IsNotExist(err) && tt.file == "keytool.jks" {
+				t.Skip("testdata/keytool.jks is not generated yet, see the keytool command above")
+			}
+			if _, err := LoadKeystore(path, "wrong"); !errors.Is(err, ErrWrongPassword) {
+				t.Errorf("wrong store password got %v", err)
+			}
+			ks, err := LoadKeystore(path, "storepass")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ks.Aliases(); fmt.Sprint(got) != fmt.Sprint(tt.aliases) {
+				t.Errorf("Aliases() = %v, want %v", got, tt.aliases)
+			}
+			if _, _, err := ks.Key("walle", "wrong"); !errors.Is(err, ErrWrongPassword) {
+				t.Errorf("wrong key password got %v", err)
+			}
+			if _, _, err := ks.Key("missing", tt.keyPass); !errors.Is(err, ErrNotFound) {
+				t.Errorf("missing alias got %v", err)
+			}
+			// keytool looks aliases up case-insensitively
+			key, certs, err := ks.Key("Walle", tt.keyPass)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch key.(type) {
+			case *rsa.PrivateKey:
+				if !tt.isRSA {
+					t.Errorf("got RSA key")
+				}
+			case *ecdsa.PrivateKey:
+				if tt.isRSA {
+					t.Errorf("got EC key")
+				}
+			default:
+				t.Errorf("got key %T", key)
+			}
+			var chain []string
+			for _, c := range certs {
+				chain = append(chain, c.Subject.CommonName)
+			}
+			if fmt.Sprint(chain) != fmt.Sprint(tt.chain) {
+				t.Fatalf("got chain %q, want %q", chain, tt.chain)
+			}
+			// the loaded key signs apks
+			if _, err := newApkSigner(key, certs); err != nil {
+				t.Error(err)
+			}
+			if _, _, err := ks.Key("ca", tt.keyPass); err == nil && tt.file == "keystore.jks" {
+				t.Errorf("trusted certificate entry has a key")
+			}
+		})
+	}
+
+	if _, err := ParseKeystore([]byte{0xce, 0xce, 0xce, 0xce, 0, 0, 0, 2}, "storepass"); err == nil {
+		t.Errorf("JCEKS keystore parses")
+	}
+	if _, err := ParseKeystore([]byte("not a keystore"), "storepass"); err == nil {
+		t.Errorf("garbage parses")
+	}
+}
+
+// TestKeystore_jksVersion1 reads keystore.jks written as version 1, whose certificates
+// have no type.
+func TestKeystore_jksVersion1(t *testing.T) {
+	b, err := os.ReadFile(filepath.Join("testdata", "keystore.jks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = bytes.ReplaceAll(b[:len(b)-sha1.Size], []byte("\x00\x05X.509"), nil)
+	binary.BigEndian.PutUint32(b[4:], 1)
+	h := sha1.New()
+	h.Write(utf16BE("storepass"))
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(b)
+	ks, err := ParseKeystore(h.Sum(b), "storepass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, certs, err := ks.Key("walle", "keypass"); err != nil || len(certs) != 2 {
+		t.Errorf("Key() got %d certificates, %v", len(certs), err)
+	}
+}
+
+func TestRC2(t *testing.T) {
+	// RFC 2268 section 5: key 88bca90e90875a, effective key bits 64,
+	// plaintext 0000000000000000, ciphertext 6ccf4308974c267f
+	c := newRC2Cipher([]byte{0x88, 0xbc, 0xa9, 0x0e, 0x90, 0x87, 0x5a}, 64)
+	dst := make([]byte, 8)
+	c.Decrypt(dst, []byte{0x6c, 0xcf, 0x43, 0x08, 0x97, 0x4c, 0x26, 0x7f})
+	if string(dst) != string(make([]byte, 8)) {
+		t.Errorf("got %x", dst)
+	}
+}
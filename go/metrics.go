@@ -0,0 +1,220 @@
+package _go
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics receives the operational events of Server, implement it to plug them into
+// another collector. PrometheusMetrics is the builtin one.
+type Metrics interface {
+	// ObserveRequest is called once a download request is served, tenant, base and
+	// channel are empty if the request was rejected before they were known. channel is
+	// "other" unless the tenant has a channel allowlist, so the labels are bounded.
+	ObserveRequest(tenant, base, channel string, status int, bytes int64)
+	// ObserveGeneration is called with the time taken to build a channel apk.
+	ObserveGeneration(d time.Duration)
+	// ObserveCache is called on every lookup of the output cache that serves an apk.
+	ObserveCache(hit bool)
+}
+
+// metricsOtherChannel is the channel label of channels not in an allowlist.
+const metricsOtherChannel = "other"
+
+// WithMetrics reports the events of downloads to m, and m is served at /metrics if it
+// is a http.Handler, like PrometheusMetrics. /metrics requires the read scope with
+// WithAuth.
+func WithMetrics(m Metrics) func(*Server) {
+	return func(s *Server) {
+		s.metrics = m
+	}
+}
+
+// WithAccessLog writes a JSON line for every download request to w, with the channel
+// and the per-download payload if any.
+func WithAccessLog(w io.Writer) func(*Server) {
+	return func(s *Server) {
+		s.accessLog = &accessLog{w: w}
+	}
+}
+
+type accessLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+type accessLogEntry struct {
+	Time     time.Time         `json:"time"`
+	Remote   string            `json:"remote"`
+	Method   string            `json:"method"`
+	Path     string            `json:"path"`
+	Base     string            `json:"base,omitempty"`
+	Channel  string            `json:"channel,omitempty"`
+	Status   int               `json:"status"`
+	Bytes    int64             `json:"bytes"`
+	Duration float64           `json:"duration_ms"`
+	Token    map[string]string `json:"token,omitempty"`
+}
+
+func (l *accessLog) write(e *accessLogEntry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.w.Write(append(b, '\n'))
+}
+
+// statusWriter records the status code and bytes written of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// defaultLatencyBuckets are the upper bounds in seconds of the generation latency histogram.
+var defaultLatencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// PrometheusMetrics collects Metrics and serves them in Prometheus text format, with the
+// counters of the sections cache. A request with an API key, see WithAuth, only gets
+// the downloads of its tenant. It is safe for concurrent use.
+type PrometheusMetrics struct {
+	mu       sync.Mutex
+	requests map[[4]string]int64 // tenant, base, channel, code
+	bytes    map[[3]string]int64 // tenant, base, channel
+	buckets  []float64
+	counts   []int64
+	sum      float64
+	count    int64
+	cache    map[bool]int64
+}
+
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{
+		requests: make(map[[4]string]int64),
+		bytes:    make(map[[3]string]int64),
+		buckets:  defaultLatencyBuckets,
+		counts:   make([]int64, len(defaultLatencyBuckets)),
+		cache:    make(map[bool]int64),
+	}
+}
+
+func (m *PrometheusMetrics) ObserveRequest(tenant, base, channel string, status int, bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[[4]string{tenant, base, channel, strconv.Itoa(status)}]++
+	m.bytes[[3]string{tenant, base, channel}] += bytes
+}
+
+func (m *PrometheusMetrics) ObserveGeneration(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := d.Seconds()
+	for i, le := range m.buckets {
+		if s <= le {
+			m.counts[i]++
+		}
+	}
+	m.sum += s
+	m.count++
+}
+
+func (m *PrometheusMetrics) ObserveCache(hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cache[hit]++
+}
+
+// ServeHTTP writes the metrics in Prometheus text format 0.0.4.
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	k, ok := KeyFromContext(r.Context())
+	_, _ = io.WriteString(w, m.text(func(tenant string) bool { return !ok || tenant == k.Tenant }))
+}
+
+// text returns the metrics with the downloads of the tenants accepted by tenants.
+func (m *PrometheusMetrics) text(tenants func(string) bool) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var b strings.Builder
+
+	b.WriteString("# HELP walle_requests_total Download requests by tenant, base, channel and status code.\n")
+	b.WriteString("# TYPE walle_requests_total counter\n")
+	reqKeys := make([][4]string, 0, len(m.requests))
+	for k := range m.requests {
+		if tenants(k[0]) {
+			reqKeys = append(reqKeys, k)
+		}
+	}
+	sort.Slice(reqKeys, func(i, j int) bool {
+		return strings.Join(reqKeys[i][:], "\x00") < strings.Join(reqKeys[j][:], "\x00")
+	})
+	for _, k := range reqKeys {
+		fmt.Fprintf(&b, "walle_requests_total{tenant=%s,base=%s,channel=%s,code=%s} %d\n",
+			promLabel(k[0]), promLabel(k[1]), promLabel(k[2]), promLabel(k[3]), m.requests[k])
+	}
+
+	b.WriteString("# HELP walle_bytes_served_total Bytes of apks served by tenant, base and channel.\n")
+	b.WriteString("# TYPE walle_bytes_served_total counter\n")
+	byteKeys := make([][3]string, 0, len(m.bytes))
+	for k := range m.bytes {
+		if tenants(k[0]) {
+			byteKeys = append(byteKeys, k)
+		}
+	}
+	sort.Slice(byteKeys, func(i, j int) bool {
+		return strings.Join(byteKeys[i][:], "\x00") < strings.Join(byteKeys[j][:], "\x00")
+	})
+	for _, k := range byteKeys {
+		fmt.Fprintf(&b, "walle_bytes_served_total{tenant=%s,base=%s,channel=%s} %d\n",
+			promLabel(k[0]), promLabel(k[1]), promLabel(k[2]), m.bytes[k])
+	}
+
+	b.WriteString("# HELP walle_generation_seconds Time taken to build a channel apk.\n")
+	b.WriteString("# TYPE walle_generation_seconds histogram\n")
+	for i, le := range m.buckets {
+		fmt.Fprintf(&b, "walle_generation_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(le, 'g', -1, 64), m.counts[i])
+	}
+	fmt.Fprintf(&b, "walle_generation_seconds_bucket{le=\"+Inf\"} %d\n", m.count)
+	fmt.Fprintf(&b, "walle_generation_seconds_sum %s\n", strconv.FormatFloat(m.sum, 'g', -1, 64))
+	fmt.Fprintf(&b, "walle_generation_seconds_count %d\n", m.count)
+
+	sections := SectionsStats()
+	b.WriteString("# HELP walle_cache_lookups_total Cache lookups by cache and result.\n")
+	b.WriteString("# TYPE walle_cache_lookups_total counter\n")
+	fmt.Fprintf(&b, "walle_cache_lookups_total{cache=\"output\",result=\"hit\"} %d\n", m.cache[true])
+	fmt.Fprintf(&b, "walle_cache_lookups_total{cache=\"output\",result=\"miss\"} %d\n", m.cache[false])
+	fmt.Fprintf(&b, "walle_cache_lookups_total{cache=\"sections\",result=\"hit\"} %d\n", sections.Hits)
+	fmt.Fprintf(&b, "walle_cache_lookups_total{cache=\"sections\",result=\"miss\"} %d\n", sections.Misses)
+	return b.String()
+}
+
+// promLabel quotes and escapes a label value.
+func promLabel(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+	return `"` + s + `"`
+}
@@ -0,0 +1,164 @@
+package _go
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_WithMetrics(t *testing.T) {
+	var logs bytes.Buffer
+	m := NewPrometheusMetrics()
+	s, base := newTestServer(t, WithMetrics(m), WithAccessLog(&logs), WithPayload(DownloadTokenPayload))
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	for _, path := range []string{"/apk/demo/xiaomi", "/apk/nope/xiaomi"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	size := len(testChannelApk(t, base, "xiaomi", nil))
+	for _, want := range []string{
+		// channels are not labeled without an allowlist
+		`walle_requests_total{tenant="",base="demo",channel="other",code="200"} 1`,
+		`walle_requests_total{tenant="",base="",channel="",code="404"} 1`,
+		fmt.Sprintf(`walle_bytes_served_total{tenant="",base="demo",channel="other"} %d`, size),
+		`walle_generation_seconds_bucket{le="+Inf"} 1`,
+		`walle_generation_seconds_count 1`,
+		`walle_cache_lookups_total{cache="output",result="hit"} 0`,
+	} {
+		if !strings.Contains(string(body), want+"\n") {
+			t.Errorf("/metrics has no %s", want)
+		}
+	}
+
+	var entries []accessLogEntry
+	sc := bufio.NewScanner(&logs)
+	for sc.Scan() {
+		var e accessLogEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d access log entries, want 2", len(entries))
+	}
+	if e := entries[0]; e.Channel != "xiaomi" || e.Status != http.StatusOK || e.Token[PayloadDownloadID] == "" {
+		t.Errorf("access log entry = %+v", e)
+	}
+	if e := entries[1]; e.Status != http.StatusNotFound || e.Token != nil {
+		t.Errorf("access log entry = %+v", e)
+	}
+}
+
+func TestServer_WithMetricsAuth(t *testing.T) {
+	dir := t.TempDir()
+	keysPath := filepath.Join(dir, "keys.json")
+	writeTestKeys(t, keysPath, `[
+		{"key": "a", "tenant": "team-a", "scopes": ["read", "download"]},
+		{"key": "b", "tenant": "team-b", "scopes": ["read", "download"]}
+	]`, time.Now().Add(-time.Hour))
+	ks, err := LoadKeyStore(keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(WithAuth(ks), WithMetrics(NewPrometheusMetrics()), WithTenantChannels("team-a", "xiaomi"))
+	base := newTestApk(t, dir)
+	for _, tenant := range []string{"team-a", "team-b"} {
+		if err := s.RegisterTenant(tenant, "demo", base); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	get := func(path, key string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+	get("/apk/demo/xiaomi", "a")
+	get("/apk/demo/xiaomi", "b")
+	get("/apk/demo/random-1", "b")
+
+	if code, _ := get("/metrics", ""); code != http.StatusUnauthorized {
+		t.Errorf("GET /metrics without key got status %d", code)
+	}
+	_, body := get("/metrics", "a")
+	if want := `walle_requests_total{tenant="team-a",base="demo",channel="xiaomi",code="200"} 1`; !strings.Contains(body, want+"\n") {
+		t.Errorf("/metrics of team-a has no %s", want)
+	}
+	if strings.Contains(body, "team-b") {
+		t.Errorf("/metrics of team-a has team-b metrics")
+	}
+	_, body = get("/metrics", "b")
+	if want := `walle_requests_total{tenant="team-b",base="demo",channel="other",code="200"} 2`; !strings.Contains(body, want+"\n") {
+		t.Errorf("/metrics of team-b has no %s:\n%s", want, body)
+	}
+}
+
+func TestServer_WithMetricsCacheError(t *testing.T) {
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	c, err := NewOutputCache(cacheDir, 1<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewPrometheusMetrics()
+	s, _ := newTestServer(t, WithMetrics(m), WithOutputCache(c))
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	// generation fails as the cache cannot write its entry
+	if err := os.RemoveAll(cacheDir); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(srv.URL + "/apk/demo/xiaomi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("GET got status %d", resp.StatusCode)
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`walle_cache_lookups_total{cache="output",result="hit"} 0`,
+		`walle_cache_lookups_total{cache="output",result="miss"} 0`,
+	} {
+		if !strings.Contains(rec.Body.String(), want+"\n") {
+			t.Errorf("/metrics has no %s", want)
+		}
+	}
+}
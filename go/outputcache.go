@@ -0,0 +1,342 @@
+package _go
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OutputCache keeps generated channel apks on disk within a byte budget, the least
+// recently used ones are evicted first. Entries are keyed by base digest, channel and
+// extras, so a new base version never hits the entries of an old one. It is safe for
+// concurrent use, and concurrent requests for the same entry generate it only once.
+//
+// Each entry is stored as {key}.apk with its metadata in {key}.json. The sha256 of an
+// entry is checked the first time it is read after being loaded from disk, later reads
+// only check its size and mtime.
+type OutputCache struct {
+	dir    string
+	budget int64
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List // front is the most recently used
+	size     int64
+	inflight map[string]*outputCall
+	hits     int64
+	misses   int64
+}
+
+type outputEntry struct {
+	Key        string `json:"key"`
+	BaseDigest string `json:"base_digest"`
+	Size       int64  `json:"size"`
+	SHA256     string `json:"sha256"`
+
+	modTime  time.Time
+	verified bool
+}
+
+type outputCall struct {
+	done    chan struct{}
+	waiters int // guarded by OutputCache.mu, final once the call left inflight
+	file    *sharedFile
+	err     error
+}
+
+// sharedFile is the output of a call read by the caller that built it and all its
+// waiters, it is closed with the last reader.
+type sharedFile struct {
+	f    *os.File
+	size int64
+	refs int32
+}
+
+func (s *sharedFile) reader() readSeekCloser {
+	return &sharedReader{SectionReader: io.NewSectionReader(s.f, 0, s.size), file: s}
+}
+
+type sharedReader struct {
+	*io.SectionReader
+	file   *sharedFile
+	closed int32
+}
+
+func (r *sharedReader) Close() error {
+	if !atomic.CompareAndSwapInt32(&r.closed, 0, 1) {
+		return nil
+	}
+	if atomic.AddInt32(&r.file.refs, -1) == 0 {
+		return r.file.f.Close()
+	}
+	return nil
+}
+
+// OutputCacheStats is a snapshot of the counters of OutputCache.
+type OutputCacheStats struct {
+	Hits    int64
+	Misses  int64
+	Entries int
+	Size    int64
+}
+
+// NewOutputCache loads the cache in dir, dir is created if it does not exist.
+func NewOutputCache(dir string, budget int64) (*OutputCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &OutputCache{
+		dir:      dir,
+		budget:   budget,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		inflight: make(map[string]*outputCall),
+	}
+	metas, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var loaded []*outputEntry
+	for _, m := range metas {
+		b, err := os.ReadFile(m)
+		if err != nil {
+			return nil, err
+		}
+		e := new(outputEntry)
+		fi, err := os.Stat(c.path(strings.TrimSuffix(filepath.Base(m), ".json")))
+		if json.Unmarshal(b, e) != nil || err != nil || fi.Size() != e.Size {
+			c.remove(strings.TrimSuffix(filepath.Base(m), ".json"))
+			continue
+		}
+		e.modTime = fi.ModTime()
+		loaded = append(loaded, e)
+	}
+	// mtime is touched on every hit, so it orders the entries
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].modTime.After(loaded[j].modTime) })
+	for _, e := range loaded {
+		c.entries[e.Key] = c.lru.PushBack(e)
+		c.size += e.Size
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+func (c *OutputCache) path(key string) string {
+	return filepath.Join(c.dir, key+".apk")
+}
+
+// remove deletes the files of key.
+func (c *OutputCache) remove(key string) {
+	_ = os.Remove(c.path(key))
+	_ = os.Remove(filepath.Join(c.dir, key+".json"))
+}
+
+func outputKey(baseDigest string, info channelInfo) string {
+	h := sha256.New()
+	io.WriteString(h, baseDigest)
+	h.Write([]byte{0})
+	h.Write(info.Bytes())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type readSeekCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// open returns the cached apk of base and info, generating it with build on a miss.
+// The returned file must be closed, it stays readable even if evicted meanwhile. An apk
+// larger than the budget is not cached, it is built once into a removed temporary file
+// read by the caller and the concurrent ones waiting for it.
+func (c *OutputCache) open(baseDigest string, info channelInfo, build func() (*VirtualApk, error)) (readSeekCloser, error) {
+	key := outputKey(baseDigest, info)
+	for {
+		c.mu.Lock()
+		if el, ok := c.entries[key]; ok {
+			c.lru.MoveToFront(el)
+			e := el.Value.(*outputEntry)
+			c.hits++
+			c.mu.Unlock()
+			f, err := c.openEntry(e)
+			if err == nil {
+				return f, nil
+			}
+			// broken entry, drop it and generate again
+			c.mu.Lock()
+			if cur, ok := c.entries[key]; ok && cur == el {
+				c.drop(el)
+			}
+			c.hits--
+			c.mu.Unlock()
+			continue
+		}
+		if call, ok := c.inflight[key]; ok {
+			call.waiters++
+			c.mu.Unlock()
+			<-call.done
+			if call.err != nil {
+				return nil, call.err
+			}
+			c.mu.Lock()
+			c.hits++
+			c.mu.Unlock()
+			return call.file.reader(), nil
+		}
+		call := &outputCall{done: make(chan struct{})}
+		c.inflight[key] = call
+		c.misses++
+		c.mu.Unlock()
+
+		f, e, err := c.write(key, baseDigest, build)
+		c.mu.Lock()
+		delete(c.inflight, key)
+		if err == nil && e.Size <= c.budget {
+			c.entries[key] = c.lru.PushFront(e)
+			c.size += e.Size
+			c.evict()
+		}
+		waiters := call.waiters
+		c.mu.Unlock()
+		if err != nil {
+			call.err = err
+			close(call.done)
+			return nil, err
+		}
+		call.file = &sharedFile{f: f, size: e.Size, refs: int32(waiters + 1)}
+		close(call.done)
+		return call.file.reader(), nil
+	}
+}
+
+// openEntry opens the file of e and checks its integrity.
+func (c *OutputCache) openEntry(e *outputEntry) (*os.File, error) {
+	f, err := os.Open(c.path(e.Key))
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.Size() != e.Size {
+		f.Close()
+		return nil, newErrf("cache entry %s is broken", e.Key)
+	}
+	c.mu.Lock()
+	verified := e.verified && fi.ModTime().Equal(e.modTime)
+	c.mu.Unlock()
+	if !verified {
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil || hex.EncodeToString(h.Sum(nil)) != e.SHA256 {
+			f.Close()
+			return nil, newErrf("cache entry %s is broken", e.Key)
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	now := time.Now()
+	_ = os.Chtimes(c.path(e.Key), now, now)
+	if fi, err := f.Stat(); err == nil {
+		now = fi.ModTime()
+	}
+	c.mu.Lock()
+	e.verified, e.modTime = true, now
+	c.mu.Unlock()
+	return f, nil
+}
+
+// write generates the apk of key into the cache dir and returns it opened. An apk larger
+// than the budget is left in a removed temporary file.
+func (c *OutputCache) write(key, baseDigest string, build func() (*VirtualApk, error)) (*os.File, *outputEntry, error) {
+	v, err := build()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer v.Close()
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), v)
+	if err != nil {
+		tmp.Close()
+		return nil, nil, err
+	}
+	e := &outputEntry{Key: key, BaseDigest: baseDigest, Size: size, SHA256: hex.EncodeToString(h.Sum(nil)), verified: true}
+	if size > c.budget {
+		return tmp, e, nil
+	}
+	meta, err := json.Marshal(e)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(c.dir, key+".json"), meta, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path(key))
+	}
+	if err != nil {
+		tmp.Close()
+		return nil, nil, err
+	}
+	if fi, err := tmp.Stat(); err == nil {
+		e.modTime = fi.ModTime()
+	}
+	return tmp, e, nil
+}
+
+// evict drops the least recently used entries until the cache fits its budget,
+// c.mu must be held.
+func (c *OutputCache) evict() {
+	for c.size > c.budget && c.lru.Len() > 0 {
+		c.drop(c.lru.Back())
+	}
+}
+
+// drop removes an entry, c.mu must be held.
+func (c *OutputCache) drop(el *list.Element) {
+	e := el.Value.(*outputEntry)
+	c.lru.Remove(el)
+	delete(c.entries, e.Key)
+	c.size -= e.Size
+	c.remove(e.Key)
+}
+
+// InvalidateBase drops all the entries generated from the base with baseDigest.
+func (c *OutputCache) InvalidateBase(baseDigest string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*outputEntry).BaseDigest == baseDigest {
+			c.drop(el)
+		}
+		el = next
+	}
+}
+
+// Stats returns a snapshot of the counters.
+func (c *OutputCache) Stats() OutputCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return OutputCacheStats{Hits: c.hits, Misses: c.misses, Entries: c.lru.Len(), Size: c.size}
+}
+
+// WithOutputCache serves apks from c, generating them on a miss. Downloads with
+// per-download payloads are never cached. Entries of a base are dropped when it is
+// replaced, unregistered or deleted from the registry.
+func WithOutputCache(c *OutputCache) func(*Server) {
+	return func(s *Server) {
+		s.cache = c
+	}
+}
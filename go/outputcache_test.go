@@ -0,0 +1,202 @@
+package _go
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOutputCache(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewApk(newTestApk(t, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var builds int64
+	build := func(channel string) func() (*VirtualApk, error) {
+		return func() (*VirtualApk, error) {
+			atomic.AddInt64(&builds, 1)
+			return a.Virtual(channel, nil)
+		}
+	}
+	read := func(c *OutputCache, channel string) []byte {
+		t.Helper()
+		f, err := c.open("digest", channelInfo{channel: channel}, build(channel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		b, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	want := testChannelApk(t, a.Path(), "xiaomi", nil)
+
+	c, err := NewOutputCache(dir, int64(len(want))*3/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if b := read(c, "xiaomi"); !bytes.Equal(b, want) {
+				t.Errorf("open() content mismatched")
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt64(&builds); n != 1 {
+		t.Errorf("concurrent open() built %d times, want 1", n)
+	}
+	if st := c.Stats(); st.Misses != 1 || st.Hits != 7 || st.Entries != 1 {
+		t.Errorf("Stats() = %+v", st)
+	}
+
+	// budget only fits one entry, xiaomi is evicted
+	read(c, "huawei")
+	if st := c.Stats(); st.Entries != 1 || st.Size > int64(len(want))*3/2 {
+		t.Errorf("Stats() after eviction = %+v", st)
+	}
+	read(c, "huawei")
+	if n := atomic.LoadInt64(&builds); n != 2 {
+		t.Errorf("built %d times, want 2", n)
+	}
+
+	// entries are reloaded from disk and checked on the first read
+	key := outputKey("digest", channelInfo{channel: "huawei"})
+	b, err := os.ReadFile(c.path(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xff
+	if err := os.WriteFile(c.path(key), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err = NewOutputCache(dir, int64(len(want))*3/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := c.Stats(); st.Entries != 1 {
+		t.Fatalf("reloaded Stats() = %+v", st)
+	}
+	if b := read(c, "huawei"); !bytes.Equal(b, testChannelApk(t, a.Path(), "huawei", nil)) {
+		t.Errorf("broken entry was served")
+	}
+	if n := atomic.LoadInt64(&builds); n != 3 {
+		t.Errorf("built %d times, want 3", n)
+	}
+
+	c.InvalidateBase("digest")
+	if st := c.Stats(); st.Entries != 0 || st.Size != 0 {
+		t.Errorf("Stats() after InvalidateBase = %+v", st)
+	}
+}
+
+func TestOutputCache_larger(t *testing.T) {
+	a, err := NewApk(newTestApk(t, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testChannelApk(t, a.Path(), "xiaomi", nil)
+	c, err := NewOutputCache(t.TempDir(), int64(len(want))/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := channelInfo{channel: "xiaomi"}
+	key := outputKey("digest", info)
+
+	// the build is held until all the other callers wait for it
+	var builds int64
+	release := make(chan struct{})
+	go func() {
+		for {
+			c.mu.Lock()
+			call, ok := c.inflight[key]
+			joined := ok && call.waiters == 7
+			c.mu.Unlock()
+			if joined {
+				close(release)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f, err := c.open("digest", info, func() (*VirtualApk, error) {
+				atomic.AddInt64(&builds, 1)
+				<-release
+				return a.Virtual("xiaomi", nil)
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer f.Close()
+			if b, err := io.ReadAll(f); err != nil || !bytes.Equal(b, want) {
+				t.Errorf("open() content mismatched: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt64(&builds); n != 1 {
+		t.Errorf("concurrent open() built %d times, want 1", n)
+	}
+	if st := c.Stats(); st.Misses != 1 || st.Hits != 7 || st.Entries != 0 || st.Size != 0 {
+		t.Errorf("Stats() = %+v", st)
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(c.dir, ".tmp-*")); len(tmps) != 0 {
+		t.Errorf("temporary files left: %v", tmps)
+	}
+
+	f, err := c.open("digest", info, func() (*VirtualApk, error) {
+		return nil, errors.New("build failed")
+	})
+	if err == nil || f != nil {
+		t.Errorf("open() of a failed build = %v, %v", f, err)
+	}
+}
+
+func TestServer_WithOutputCache(t *testing.T) {
+	c, err := NewOutputCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, base := newTestServer(t, WithOutputCache(c))
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	want := testChannelApk(t, base, "xiaomi", nil)
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(srv.URL + "/apk/demo/xiaomi")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !bytes.Equal(b, want) {
+			t.Errorf("GET #%d content mismatched", i)
+		}
+	}
+	if st := c.Stats(); st.Hits != 1 || st.Misses != 1 {
+		t.Errorf("Stats() = %+v", st)
+	}
+	s.Unregister("demo")
+	if st := c.Stats(); st.Entries != 0 {
+		t.Errorf("Stats() after Unregister = %+v", st)
+	}
+}
@@ -0,0 +1,86 @@
+package _go
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Keys of the payload made by NewDownloadToken. Payloads are written as extras, so
+// walle readers on Android see them with the other extras.
+const (
+	PayloadDownloadID   = "download_id"
+	PayloadDownloadTime = "download_time"
+	PayloadReferrer     = "referrer"
+)
+
+// NewDownloadToken makes a payload with a random download id, the unix time and the
+// referrer if it is not empty.
+func NewDownloadToken(referrer string) (map[string]string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	token := map[string]string{
+		PayloadDownloadID:   hex.EncodeToString(id),
+		PayloadDownloadTime: strconv.FormatInt(time.Now().Unix(), 10),
+	}
+	if referrer != "" {
+		token[PayloadReferrer] = referrer
+	}
+	return token, nil
+}
+
+// VirtualWithPayload is Virtual with extras merged with the result of payload, which is
+// called once per virtual apk, e.g. NewDownloadToken. Payload keys override extras.
+// The payload is returned too so the caller can log it.
+func (a *apk) VirtualWithPayload(channel string, extras map[string]string,
+	payload func() (map[string]string, error)) (*VirtualApk, map[string]string, error) {
+	p, err := payload()
+	if err != nil {
+		return nil, nil, err
+	}
+	v, err := a.Virtual(channel, mergeExtras(extras, p))
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, p, nil
+}
+
+// WithPayload injects the result of payload into every apk served, see VirtualWithPayload.
+// Since every download differs, ranges are not supported: Range headers are ignored,
+// the full apk is always served with "Accept-Ranges: none".
+func WithPayload(payload func(r *http.Request) (map[string]string, error)) func(*Server) {
+	return func(s *Server) {
+		s.payload = payload
+	}
+}
+
+// WithPayloadHook calls hook with the payload of every apk served, e.g. for logging.
+func WithPayloadHook(hook func(r *http.Request, payload map[string]string)) func(*Server) {
+	return func(s *Server) {
+		s.payloadHook = hook
+	}
+}
+
+// DownloadTokenPayload is a payload for WithPayload made by NewDownloadToken with the
+// referrer of the request.
+func DownloadTokenPayload(r *http.Request) (map[string]string, error) {
+	return NewDownloadToken(r.Referer())
+}
+
+// mergeExtras returns a new map with the entries of all ms, later ones win.
+func mergeExtras(ms ...map[string]string) map[string]string {
+	var ret map[string]string
+	for _, m := range ms {
+		for k, v := range m {
+			if ret == nil {
+				ret = make(map[string]string)
+			}
+			ret[k] = v
+		}
+	}
+	return ret
+}
@@ -0,0 +1,91 @@
+package _go
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestApk_VirtualWithPayload(t *testing.T) {
+	a, err := NewApk(newTestApk(t, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	referrer := `https://example.com/?q="walle"&r=<1>`
+	v, token, err := a.VirtualWithPayload("xiaomi", map[string]string{"k": "v", PayloadReferrer: "x"}, func() (map[string]string, error) {
+		return NewDownloadToken(referrer)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	if len(token[PayloadDownloadID]) != 32 || token[PayloadDownloadTime] == "" || token[PayloadReferrer] != referrer {
+		t.Errorf("VirtualWithPayload() token = %v", token)
+	}
+	got, err := NewApkFromReaderAt(v, v.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	extras := got.Extras()
+	if extras["k"] != "v" || extras[PayloadReferrer] != referrer || extras[PayloadDownloadID] != token[PayloadDownloadID] {
+		t.Errorf("VirtualWithPayload() extras = %v", extras)
+	}
+}
+
+func TestServer_WithPayload(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		logged []map[string]string
+	)
+	s, _ := newTestServer(t, WithPayload(DownloadTokenPayload), WithPayloadHook(func(r *http.Request, payload map[string]string) {
+		mu.Lock()
+		defer mu.Unlock()
+		logged = append(logged, payload)
+	}))
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	etags := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/apk/demo/xiaomi", nil)
+		req.Header.Set("Referer", "https://example.com/download")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		etags[resp.Header.Get("ETag")] = true
+
+		got, err := NewApkFromReaderAt(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		token := logged[i]
+		mu.Unlock()
+		if got.Channel() != "xiaomi" || got.Extras()[PayloadDownloadID] != token[PayloadDownloadID] ||
+			got.Extras()[PayloadReferrer] != "https://example.com/download" {
+			t.Errorf("GET got %v, logged %v", got.All(), token)
+		}
+	}
+	if len(etags) != 2 {
+		t.Errorf("every download should have its own ETag, got %v", etags)
+	}
+
+	// a range of another download cannot be resumed
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/apk/demo/xiaomi", nil)
+	req.Header.Set("Range", "bytes=10-99")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Accept-Ranges") != "none" || int64(len(b)) != resp.ContentLength {
+		t.Errorf("GET with Range got status %d, Accept-Ranges %q, %d bytes", resp.StatusCode, resp.Header.Get("Accept-Ranges"), len(b))
+	}
+}
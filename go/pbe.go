@@ -0,0 +1,332 @@
+package _go
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"unicode/utf16"
+)
+
+// Password-based encryption of keystores, see RFC 7292 appendix B and RFC 8018.
+
+var (
+	oidPBEWithSHAAnd3KeyTripleDESCBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBEWithSHAAnd128BitRC2CBC     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 5}
+	oidPBEWithSHAAnd40BitRC2CBC      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}
+	oidPBES2                         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2                        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+
+	pbkdf2PRFs = map[string]func() hash.Hash{
+		"1.2.840.113549.2.7":  sha1.New,
+		"1.2.840.113549.2.9":  sha256.New,
+		"1.2.840.113549.2.10": sha512.New384,
+		"1.2.840.113549.2.11": sha512.New,
+	}
+	// pbes2Ciphers are the key sizes of the supported PBES2 CBC ciphers.
+	pbes2Ciphers = map[string]int{
+		"2.16.840.1.101.3.4.1.2":  16,
+		"2.16.840.1.101.3.4.1.22": 24,
+		"2.16.840.1.101.3.4.1.42": 32,
+		"1.2.840.113549.3.7":      24,
+	}
+)
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// utf16BE encodes password as UTF-16BE, as Java encodes a char array.
+func utf16BE(password string) []byte {
+	u := utf16.Encode([]rune(password))
+	b := make([]byte, 2*len(u))
+	for i, c := range u {
+		b[2*i], b[2*i+1] = byte(c>>8), byte(c)
+	}
+	return b
+}
+
+// bmpPassword encodes password as a null-terminated BMPString, as the PKCS#12 key
+// derivation takes it.
+func bmpPassword(password string) []byte {
+	return append(utf16BE(password), 0, 0)
+}
+
+// pkcs12KDF derives n bytes of key material for id, 1 for keys, 2 for IVs and 3 for
+// MAC keys, from the BMPString password.
+// See https://datatracker.ietf.org/doc/html/rfc7292#appendix-B.2
+func pkcs12KDF(newHash func() hash.Hash, password, salt []byte, iterations int, id byte, n int) []byte {
+	h := newHash()
+	u, v := h.Size(), h.BlockSize()
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		ret := make([]byte, v*((len(b)+v-1)/v))
+		for i := range ret {
+			ret[i] = b[i%len(b)]
+		}
+		return ret
+	}
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = id
+	}
+	I := append(fill(salt), fill(password)...)
+	one := big.NewInt(1)
+	var out []byte
+	for len(out) < n {
+		h.Reset()
+		h.Write(d)
+		h.Write(I)
+		a := h.Sum(nil)
+		for j := 1; j < iterations; j++ {
+			h.Reset()
+			h.Write(a)
+			a = h.Sum(a[:0])
+		}
+		out = append(out, a...)
+		if len(out) >= n {
+			break
+		}
+		// I_j = (I_j + B + 1) mod 2^(8v) for every v-byte block of I
+		b := new(big.Int).SetBytes(fill(a[:u])[:v])
+		b.Add(b, one)
+		for j := 0; j < len(I); j += v {
+			sum := new(big.Int).SetBytes(I[j : j+v])
+			sum.Add(sum, b)
+			s := sum.Bytes()
+			if len(s) > v {
+				s = s[len(s)-v:]
+			}
+			block := I[j : j+v]
+			for k := range block {
+				block[k] = 0
+			}
+			copy(block[v-len(s):], s)
+		}
+	}
+	return out[:n]
+}
+
+// pbkdf2 derives a key of keyLen bytes, see RFC 8018 section 5.2.
+func pbkdf2(newHash func() hash.Hash, password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(newHash, password)
+	var out []byte
+	buf := make([]byte, 4)
+	for block := uint32(1); len(out) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		buf[0], buf[1], buf[2], buf[3] = byte(block>>24), byte(block>>16), byte(block>>8), byte(block)
+		prf.Write(buf)
+		u := prf.Sum(nil)
+		t := append([]byte{}, u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		out = append(out, t...)
+	}
+	return out[:keyLen]
+}
+
+// errPBEUnsupported is returned for unsupported encryption algorithms, which keystores
+// report with the algorithm.
+var errPBEUnsupported = errors.New("unsupported encryption algorithm")
+
+// decryptPBE decrypts data encrypted with a PKCS#12 or PBES2 password-based encryption
+// algorithm. A wrong password fails with ErrWrongPassword in most cases, when the
+// padding of the decrypted data is malformed.
+func decryptPBE(alg pkix.AlgorithmIdentifier, password string, data []byte) ([]byte, error) {
+	var (
+		block cipher.Block
+		iv    []byte
+		err   error
+	)
+	switch {
+	case alg.Algorithm.Equal(oidPBEWithSHAAnd3KeyTripleDESCBC), alg.Algorithm.Equal(oidPBEWithSHAAnd128BitRC2CBC),
+		alg.Algorithm.Equal(oidPBEWithSHAAnd40BitRC2CBC):
+		var params pbeParams
+		if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+			return nil, fmt.Errorf("malformed PBE parameters: %s", err)
+		}
+		pw := bmpPassword(password)
+		iv = pkcs12KDF(sha1.New, pw, params.Salt, params.Iterations, 2, 8)
+		switch {
+		case alg.Algorithm.Equal(oidPBEWithSHAAnd3KeyTripleDESCBC):
+			block, err = des.NewTripleDESCipher(pkcs12KDF(sha1.New, pw, params.Salt, params.Iterations, 1, 24))
+		case alg.Algorithm.Equal(oidPBEWithSHAAnd128BitRC2CBC):
+			block = newRC2Cipher(pkcs12KDF(sha1.New, pw, params.Salt, params.Iterations, 1, 16), 128)
+		default:
+			block = newRC2Cipher(pkcs12KDF(sha1.New, pw, params.Salt, params.Iterations, 1, 5), 40)
+		}
+	case alg.Algorithm.Equal(oidPBES2):
+		block, iv, err = pbes2Cipher(alg, password)
+	default:
+		return nil, fmt.Errorf("%w %s", errPBEUnsupported, alg.Algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || len(data)%block.BlockSize() != 0 || len(iv) != block.BlockSize() {
+		return nil, errors.New("malformed encrypted data")
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	// PKCS#7 padding
+	n := int(out[len(out)-1])
+	if n == 0 || n > block.BlockSize() {
+		return nil, ErrWrongPassword
+	}
+	for _, b := range out[len(out)-n:] {
+		if int(b) != n {
+			return nil, ErrWrongPassword
+		}
+	}
+	return out[:len(out)-n], nil
+}
+
+func pbes2Cipher(alg pkix.AlgorithmIdentifier, password string) (cipher.Block, []byte, error) {
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+		return nil, nil, fmt.Errorf("malformed PBES2 parameters: %s", err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, nil, fmt.Errorf("%w PBES2 with %s", errPBEUnsupported, params.KeyDerivationFunc.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, nil, fmt.Errorf("malformed PBKDF2 parameters: %s", err)
+	}
+	prf := sha1.New
+	if len(kdf.PRF.Algorithm) > 0 {
+		var ok bool
+		if prf, ok = pbkdf2PRFs[kdf.PRF.Algorithm.String()]; !ok {
+			return nil, nil, fmt.Errorf("%w PBKDF2 with %s", errPBEUnsupported, kdf.PRF.Algorithm)
+		}
+	}
+	scheme := params.EncryptionScheme.Algorithm.String()
+	keyLen, ok := pbes2Ciphers[scheme]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w PBES2 with %s", errPBEUnsupported, scheme)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, nil, fmt.Errorf("malformed PBES2 IV: %s", err)
+	}
+	key := pbkdf2(prf, []byte(password), kdf.Salt, kdf.Iterations, keyLen)
+	if scheme == "1.2.840.113549.3.7" {
+		block, err := des.NewTripleDESCipher(key)
+		return block, iv, err
+	}
+	block, err := aes.NewCipher(key)
+	return block, iv, err
+}
+
+// rc2Cipher is the decryption of RC2, which legacy PKCS#12 files encrypt certificates
+// with. See https://datatracker.ietf.org/doc/html/rfc2268
+type rc2Cipher struct {
+	k [64]uint16
+}
+
+var rc2PITable = [256]byte{
+	0xd9, 0x78, 0xf9, 0xc4, 0x19, 0xdd, 0xb5, 0xed, 0x28, 0xe9, 0xfd, 0x79, 0x4a, 0xa0, 0xd8, 0x9d,
+	0xc6, 0x7e, 0x37, 0x83, 0x2b, 0x76, 0x53, 0x8e, 0x62, 0x4c, 0x64, 0x88, 0x44, 0x8b, 0xfb, 0xa2,
+	0x17, 0x9a, 0x59, 0xf5, 0x87, 0xb3, 0x4f, 0x13, 0x61, 0x45, 0x6d, 0x8d, 0x09, 0x81, 0x7d, 0x32,
+	0xbd, 0x8f, 0x40, 0xeb, 0x86, 0xb7, 0x7b, 0x0b, 0xf0, 0x95, 0x21, 0x22, 0x5c, 0x6b, 0x4e, 0x82,
+	0x54, 0xd6, 0x65, 0x93, 0xce, 0x60, 0xb2, 0x1c, 0x73, 0x56, 0xc0, 0x14, 0xa7, 0x8c, 0xf1, 0xdc,
+	0x12, 0x75, 0xca, 0x1f, 0x3b, 0xbe, 0xe4, 0xd1, 0x42, 0x3d, 0xd4, 0x30, 0xa3, 0x3c, 0xb6, 0x26,
+	0x6f, 0xbf, 0x0e, 0xda, 0x46, 0x69, 0x07, 0x57, 0x27, 0xf2, 0x1d, 0x9b, 0xbc, 0x94, 0x43, 0x03,
+	0xf8, 0x11, 0xc7, 0xf6, 0x90, 0xef, 0x3e, 0xe7, 0x06, 0xc3, 0xd5, 0x2f, 0xc8, 0x66, 0x1e, 0xd7,
+	0x08, 0xe8, 0xea, 0xde, 0x80, 0x52, 0xee, 0xf7, 0x84, 0xaa, 0x72, 0xac, 0x35, 0x4d, 0x6a, 0x2a,
+	0x96, 0x1a, 0xd2, 0x71, 0x5a, 0x15, 0x49, 0x74, 0x4b, 0x9f, 0xd0, 0x5e, 0x04, 0x18, 0xa4, 0xec,
+	0xc2, 0xe0, 0x41, 0x6e, 0x0f, 0x51, 0xcb, 0xcc, 0x24, 0x91, 0xaf, 0x50, 0xa1, 0xf4, 0x70, 0x39,
+	0x99, 0x7c, 0x3a, 0x85, 0x23, 0xb8, 0xb4, 0x7a, 0xfc, 0x02, 0x36, 0x5b, 0x25, 0x55, 0x97, 0x31,
+	0x2d, 0x5d, 0xfa, 0x98, 0xe3, 0x8a, 0x92, 0xae, 0x05, 0xdf, 0x29, 0x10, 0x67, 0x6c, 0xba, 0xc9,
+	0xd3, 0x00, 0xe6, 0xcf, 0xe1, 0x9e, 0xa8, 0x2c, 0x63, 0x16, 0x01, 0x3f, 0x58, 0xe2, 0x89, 0xa9,
+	0x0d, 0x38, 0x34, 0x1b, 0xab, 0x33, 0xff, 0xb0, 0xbb, 0x48, 0x0c, 0x5f, 0xb9, 0xb1, 0xcd, 0x2e,
+	0xc5, 0xf3, 0xdb, 0x47, 0xe5, 0xa5, 0x9c, 0x77, 0x0a, 0xa6, 0x20, 0x68, 0xfe, 0x7f, 0xc1, 0xad,
+}
+
+// newRC2Cipher expands key with effective key bits t1.
+func newRC2Cipher(key []byte, t1 int) *rc2Cipher {
+	var l [128]byte
+	t := len(key)
+	copy(l[:], key)
+	for i := t; i < 128; i++ {
+		l[i] = rc2PITable[l[i-1]+l[i-t]]
+	}
+	t8 := (t1 + 7) / 8
+	tm := byte(255 >> uint(8*t8-t1))
+	l[128-t8] = rc2PITable[l[128-t8]&tm]
+	for i := 127 - t8; i >= 0; i-- {
+		l[i] = rc2PITable[l[i+1]^l[i+t8]]
+	}
+	c := new(rc2Cipher)
+	for i := range c.k {
+		c.k[i] = uint16(l[2*i]) | uint16(l[2*i+1])<<8
+	}
+	return c
+}
+
+func (c *rc2Cipher) BlockSize() int { return 8 }
+
+func (c *rc2Cipher) Encrypt(dst, src []byte) {
+	panic("rc2: encryption is not supported")
+}
+
+func (c *rc2Cipher) Decrypt(dst, src []byte) {
+	var r [4]uint16
+	for i := range r {
+		r[i] = uint16(src[2*i]) | uint16(src[2*i+1])<<8
+	}
+	j := 63
+	mix := func() {
+		for i, s := range [4]uint{5, 3, 2, 1} {
+			i = 3 - i
+			r[i] = r[i]>>s | r[i]<<(16-s)
+			r[i] -= c.k[j] + (r[(i+3)%4] & r[(i+2)%4]) + (^r[(i+3)%4] & r[(i+1)%4])
+			j--
+		}
+	}
+	mash := func() {
+		for i := 3; i >= 0; i-- {
+			r[i] -= c.k[r[(i+3)%4]&63]
+		}
+	}
+	for round := 0; round < 16; round++ {
+		mix()
+		if round == 4 || round == 10 {
+			mash()
+		}
+	}
+	for i, v := range r {
+		dst[2*i], dst[2*i+1] = byte(v), byte(v>>8)
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"os"
+	"sort"
 )
 
 const (
@@ -65,21 +67,24 @@ func (c *channelInfo) Bytes() []byte {
 	buf.WriteByte('{')
 	if len(c.channel) != 0 {
 		buf.WriteString("\"channel\":")
-		buf.WriteByte('"')
-		buf.WriteString(c.channel)
-		buf.WriteByte('"')
+		writeJSONString(&buf, c.channel)
 		buf.WriteByte(',')
 	}
 
 	if c.extras != nil {
-		for k, v := range c.extras {
-			buf.WriteByte('"')
-			buf.WriteString(k)
-			buf.WriteByte('"')
+		// sorted, so the same info always makes the same bytes
+		keys := make([]string, 0, len(c.extras))
+		for k := range c.extras {
+			// the channel is reserved, an extra must not override it
+			if k != "channel" {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			writeJSONString(&buf, k)
 			buf.WriteByte(':')
-			buf.WriteByte('"')
-			buf.WriteString(v)
-			buf.WriteByte('"')
+			writeJSONString(&buf, c.extras[k])
 			buf.WriteByte(',')
 		}
 	}
@@ -92,12 +97,37 @@ func (c *channelInfo) Bytes() []byte {
 	return buf.Bytes()
 }
 
+// writeJSONString writes s as a quoted and escaped JSON string, payloads such as a
+// referrer may contain any character.
+func writeJSONString(buf *bytes.Buffer, s string) {
+	b, _ := json.Marshal(s)
+	buf.Write(b)
+}
+
 func readInfo(file string) (c channelInfo, err error) {
-	block, err := readChannelBlock(file)
+	ret, err := openFile(file, func(f *os.File) (interface{}, error) {
+		size, err := fileSize(f)
+		if err != nil {
+			return nil, err
+		}
+		return readInfoAt(f, size)
+	})
 	if err != nil {
 		return c, err
 	}
+	return ret.(channelInfo), nil
+}
+
+func readInfoAt(r io.ReaderAt, size int64) (c channelInfo, err error) {
+	block, err := readChannelBlockAt(r, size)
+	if err != nil {
+		return c, err
+	}
+	return parseChannelBlock(block)
+}
 
+// parseChannelBlock decodes the value associated to APK_CHANNEL_BLOCK_ID.
+func parseChannelBlock(block []byte) (c channelInfo, err error) {
 	if block != nil {
 		var bundle map[string]string
 		err := json.Unmarshal(block, &bundle)
@@ -113,8 +143,8 @@ func readInfo(file string) (c channelInfo, err error) {
 }
 
 // read block associated to APK_CHANNEL_BLOCK_ID
-func readChannelBlock(file string) ([]byte, error) {
-	m, err := readIdValues(file, APK_CHANNEL_BLOCK_ID)
+func readChannelBlockAt(r io.ReaderAt, size int64) ([]byte, error) {
+	m, err := readIdValuesAt(r, size, APK_CHANNEL_BLOCK_ID)
 	if err != nil {
 		return nil, err
 	}
@@ -122,12 +152,21 @@ func readChannelBlock(file string) ([]byte, error) {
 }
 
 func readIdValues(file string, ids ...uint32) (map[uint32][]byte, error) {
-	f, err := os.Open(file)
+	ret, err := openFile(file, func(f *os.File) (interface{}, error) {
+		size, err := fileSize(f)
+		if err != nil {
+			return nil, err
+		}
+		return readIdValuesAt(f, size, ids...)
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	eocd, offset, err := findEndOfCentralDirectoryRecord(f)
+	return ret.(map[uint32][]byte), nil
+}
+
+func readIdValuesAt(r io.ReaderAt, size int64, ids ...uint32) (map[uint32][]byte, error) {
+	eocd, offset, err := findEndOfCentralDirectoryRecord(r, size)
 	if err != nil {
 		return nil, err
 	}
@@ -135,7 +174,7 @@ func readIdValues(file string, ids ...uint32) (map[uint32][]byte, error) {
 		return nil, errors.New("Cannot find EOCD record, maybe a broken zip file.")
 	}
 	centralDirOffset := getEocdCentralDirectoryOffset(eocd)
-	block, _, err := findApkSigningBlock(f, centralDirOffset)
+	block, _, err := findApkSigningBlock(r, centralDirOffset)
 	if err != nil {
 		return nil, err
 	}
@@ -157,19 +196,15 @@ func readIdValues(file string, ids ...uint32) (map[uint32][]byte, error) {
 // For a zip with no archive comment, the
 // end-of-central-directory record will be 22 bytes long, so
 // we expect to find the EOCD marker 22 bytes from the end.
-func findEndOfCentralDirectoryRecord(f *os.File) ([]byte, int64, error) {
-	fi, err := f.Stat()
-	if err != nil {
-		return nil, -1, err
-	}
-	if fi.Size() < _ZIP_EOCD_REC_MIN_SIZE {
+func findEndOfCentralDirectoryRecord(r io.ReaderAt, size int64) ([]byte, int64, error) {
+	if size < _ZIP_EOCD_REC_MIN_SIZE {
 		// No space for EoCD record in the file.
 		return nil, -1, nil
 	}
 	// Optimization: 99.99% of APKs have a zero-length comment field in the EoCD record and thus
 	// the EoCD record offset is known in advance. Try that offset first to avoid unnecessarily
 	// reading more data.
-	ret, offset, err := findEOCDRecord(f, 0)
+	ret, offset, err := findEOCDRecord(r, size, 0)
 	if err != nil {
 		return nil, -1, err
 	}
@@ -179,41 +214,36 @@ func findEndOfCentralDirectoryRecord(f *os.File) ([]byte, int64, error) {
 	// EoCD does not start where we expected it to. Perhaps it contains a non-empty comment
 	// field. Expand the search. The maximum size of the comment field in EoCD is 65535 because
 	// the comment length field is an unsigned 16-bit number.
-	return findEOCDRecord(f, math.MaxUint16)
+	return findEOCDRecord(r, size, math.MaxUint16)
 }
 
-func findEOCDRecord(f *os.File, maxCommentSize uint16) ([]byte, int64, error) {
+func findEOCDRecord(r io.ReaderAt, fileSize int64, maxCommentSize uint16) ([]byte, int64, error) {
 	if (maxCommentSize < 0) || maxCommentSize > math.MaxUint16 {
 		return nil, -1, os.ErrInvalid
 	}
-	fi, err := f.Stat()
-	if err != nil {
-		return nil, -1, err
-	}
-	fileSize := fi.Size()
 	if fileSize < _ZIP_EOCD_REC_MIN_SIZE {
 		// No space for EoCD record in the file.
 		return nil, -1, nil
 	}
 	// Lower maxCommentSize if the file is too small.
-	if s := uint16(fileSize - _ZIP_EOCD_REC_MIN_SIZE); maxCommentSize > s {
-		maxCommentSize = s
+	if s := fileSize - _ZIP_EOCD_REC_MIN_SIZE; int64(maxCommentSize) > s {
+		maxCommentSize = uint16(s)
 	}
-	maxEocdSize := _ZIP_EOCD_REC_MIN_SIZE + maxCommentSize
+	maxEocdSize := _ZIP_EOCD_REC_MIN_SIZE + int(maxCommentSize)
 	bufOffsetInFile := fileSize - int64(maxEocdSize)
 	buf := make([]byte, maxEocdSize)
-	n, e := f.ReadAt(buf, bufOffsetInFile)
-	if e != nil {
+	if err := readFullAt(r, buf, bufOffsetInFile); err != nil {
 		return nil, -1, err
 	}
+	n := len(buf)
 	eocdOffsetInFile := func() int64 {
 		eocdWithEmptyCommentStartPosition := n - _ZIP_EOCD_REC_MIN_SIZE
-		for expectedCommentLength := uint16(0); expectedCommentLength < maxCommentSize; expectedCommentLength++ {
-			eocdStartPos := eocdWithEmptyCommentStartPosition - int(expectedCommentLength)
+		for expectedCommentLength := 0; expectedCommentLength <= int(maxCommentSize); expectedCommentLength++ {
+			eocdStartPos := eocdWithEmptyCommentStartPosition - expectedCommentLength
 			if getUint32(buf, eocdStartPos) == _ZIP_EOCD_REC_SIG {
 				n := eocdStartPos + _ZIP_EOCD_COMMENT_LENGTH_FIELD_OFFSET
 				actualCommentLength := getUint16(buf, n)
-				if actualCommentLength == expectedCommentLength {
+				if int(actualCommentLength) == expectedCommentLength {
 					return int64(eocdStartPos)
 				}
 			}
@@ -251,36 +281,50 @@ func isExpected(ids []uint32, test uint32) bool {
 
 func findIdValuesInApkSigningBlock(block []byte, ids ...uint32) (map[uint32][]byte, error) {
 	ret := make(map[uint32][]byte)
+	err := forEachIdValue(block, func(id uint32, value []byte) bool {
+		if id == VERITY_PADDING_BLOCK_ID {
+			return false
+		}
+		if len(ids) == 0 || isExpected(ids, id) {
+			ret[id] = value
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// forEachIdValue calls fn for every ID-value pair of the APK Signing Block in order,
+// including the padding pair, until fn returns false.
+func forEachIdValue(block []byte, fn func(id uint32, value []byte) bool) error {
 	position := 8
 	limit := len(block) - 24
 	entryCount := 0
 	for limit > position { // has remaining bytes
 		entryCount++
 		if limit-position < 8 { // but not enough
-			return nil, fmt.Errorf("APK Signing Block broken on entry #%d", entryCount)
+			return fmt.Errorf("APK Signing Block broken on entry #%d", entryCount)
 		}
 
 		length := int(getUint64(block, position))
 		position += 8
 
 		if length < 4 || length > limit-position {
-			return nil, fmt.Errorf("APK Signing Block broken on entry #%d,"+
+			return fmt.Errorf("APK Signing Block broken on entry #%d,"+
 				" size out of range: length=%d, remaining=%d", entryCount, length, limit-position)
 		}
 		nextEntryPosition := position + length
 		id := getUint32(block, position)
-		if id == VERITY_PADDING_BLOCK_ID {
-			return ret, nil
-		}
 		position += 4
 
-		if len(ids) == 0 || isExpected(ids, id) {
-			ret[id] = block[position : position+length-4]
+		if !fn(id, block[position:position+length-4]) {
+			return nil
 		}
 		position = nextEntryPosition
-
 	}
-	return ret, nil
+	return nil
 }
 
 // Find the APK Signing Block. The block immediately precedes the Central Directory.
@@ -293,7 +337,7 @@ func findIdValuesInApkSigningBlock(block []byte, ids ...uint32) (map[uint32][]by
 //	     (size - 4) bytes: value
 //	 uint64:  size (same as the one above)
 //	 uint128: magic
-func findApkSigningBlock(f *os.File, centralDirOffset uint32) (block []byte, offset int64, err error) {
+func findApkSigningBlock(r io.ReaderAt, centralDirOffset uint32) (block []byte, offset int64, err error) {
 	if centralDirOffset < _APK_SIG_BLOCK_MIN_SIZE {
 		return block, offset, fmt.Errorf("APK too small for APK Signing Block."+
 			" ZIP Central Directory offset: %d", centralDirOffset)
@@ -301,7 +345,7 @@ func findApkSigningBlock(f *os.File, centralDirOffset uint32) (block []byte, off
 	// Read the footer of APK signing block
 	// 24 = sizeof(uint128) + sizeof(uint64)
 	footer := make([]byte, 24)
-	_, err = f.ReadAt(footer, int64(centralDirOffset-24))
+	err = readFullAt(r, footer, int64(centralDirOffset-24))
 	if err != nil {
 		return
 	}
@@ -323,7 +367,7 @@ func findApkSigningBlock(f *os.File, centralDirOffset uint32) (block []byte, off
 		return block, offset, fmt.Errorf("invalid offset for APK Signing Block %d", offset)
 	}
 	block = make([]byte, totalSize)
-	_, err = f.ReadAt(block, offset)
+	err = readFullAt(r, block, offset)
 	if err != nil {
 		return
 	}
@@ -344,6 +388,9 @@ func findApkSigningBlock(f *os.File, centralDirOffset uint32) (block []byte, off
 // (extra dummy ID-value for padding to make block size a multiple of 4096 bytes)
 // uint64:  size (same as the one above)
 // uint128: magic
+//
+// Any existing channel and padding pairs are dropped, the channel pair is appended
+// after the remaining pairs and a new padding pair goes last.
 func makeSigningBlockWithInfo(info channelInfo, signingBlock []byte) ([]byte, int, error) {
 	signingBlockSize := getUint64(signingBlock, 0)
 	signingBlockLen := len(signingBlock)
@@ -351,19 +398,33 @@ func makeSigningBlockWithInfo(info channelInfo, signingBlock []byte) ([]byte, in
 		return nil, 0, fmt.Errorf("APK Signing Block is illegal! Expect size %d but %d", signingBlockSize, n)
 	}
 
-	channelValue := info.Bytes()
-	channelValueSize := uint64(4 + len(channelValue))
-	resultSize := 8 + signingBlockSize + 8 + channelValueSize
+	var pairs []byte
+	err := forEachIdValue(signingBlock, func(id uint32, value []byte) bool {
+		if id != APK_CHANNEL_BLOCK_ID && id != VERITY_PADDING_BLOCK_ID {
+			pairs = appendIdValue(pairs, id, value)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	pairs = appendIdValue(pairs, APK_CHANNEL_BLOCK_ID, info.Bytes())
+	newBlock := makeSigningBlock(pairs)
+	return newBlock, len(newBlock) - signingBlockLen, nil
+}
 
+// makeSigningBlock makes an APK Signing Block of the ID-value pairs, with a padding pair
+// last to make its size a multiple of 4096 bytes.
+func makeSigningBlock(pairs []byte) []byte {
+	// 8 (size) + pairs + 8 (size) + 16 (magic)
+	resultSize := uint64(8 + len(pairs) + 24)
 	if s := resultSize % ANDROID_COMMON_PAGE_ALIGNMENT_BYTES; s != 0 {
 		padding := ANDROID_COMMON_PAGE_ALIGNMENT_BYTES - s
-		if padding <= 0 {
+		// the padding pair needs at least its size and ID fields
+		if padding < 12 {
 			padding += ANDROID_COMMON_PAGE_ALIGNMENT_BYTES
 		}
-		b := make([]byte, padding)
-		putUint32(VERITY_PADDING_BLOCK_ID, channelValue, 0)
-		channelValue = append(channelValue, b...)
-		channelValueSize += padding
+		pairs = appendIdValue(pairs, VERITY_PADDING_BLOCK_ID, make([]byte, padding-12))
 		resultSize += padding
 	}
 
@@ -371,25 +432,21 @@ func makeSigningBlockWithInfo(info channelInfo, signingBlock []byte) ([]byte, in
 	position := 0
 	putUint64(resultSize-8, newBlock, position)
 	position += 8
-	// copy raw id-value pairs
-	n, _ := copyBytes(signingBlock, 8, newBlock, position, int(signingBlockSize)-16-8)
-	position += n
-	putUint64(channelValueSize, newBlock, position)
-	position += 8
-	putUint32(APK_CHANNEL_BLOCK_ID, newBlock, position)
-	position += 4
-	n, _ = copyBytes(channelValue, 0, newBlock, position, len(channelValue))
-	position += n
-
+	position += copy(newBlock[position:], pairs)
 	putUint64(resultSize-8, newBlock, position)
 	position += 8
-	_, _ = copyBytes(signingBlock, signingBlockLen-16, newBlock, int(resultSize-16), 16)
-	position += 16
+	putUint64(_APK_SIG_BLOCK_MAGIC_LO, newBlock, position)
+	putUint64(_APK_SIG_BLOCK_MAGIC_HI, newBlock, position+8)
+	return newBlock
+}
 
-	if position != int(resultSize) {
-		return nil, -1, fmt.Errorf("count mismatched ! %d vs %d", position, resultSize)
-	}
-	return newBlock, int(resultSize) - signingBlockLen, nil
+// appendIdValue appends an ID-value pair to b.
+func appendIdValue(b []byte, id uint32, value []byte) []byte {
+	pair := make([]byte, 12+len(value))
+	putUint64(uint64(4+len(value)), pair, 0)
+	putUint32(id, pair, 8)
+	copy(pair[12:], value)
+	return append(b, pair...)
 }
 
 func makeEocd(origin []byte, newCentralDirOffset uint32) []byte {
@@ -0,0 +1,44 @@
+package _go
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+)
+
+func Test_findEndOfCentralDirectoryRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment string
+	}{
+		{"no comment", ""},
+		{"comment", "hello walle"},
+		{"long comment", string(bytes.Repeat([]byte{'c'}, 60000))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			zw := zip.NewWriter(&buf)
+			if _, err := zw.Create("a.txt"); err != nil {
+				t.Fatal(err)
+			}
+			if err := zw.SetComment(tt.comment); err != nil {
+				t.Fatal(err)
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			b := buf.Bytes()
+			eocd, offset, err := findEndOfCentralDirectoryRecord(bytes.NewReader(b), int64(len(b)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := int64(len(b) - _ZIP_EOCD_REC_MIN_SIZE - len(tt.comment)); offset != want {
+				t.Errorf("findEndOfCentralDirectoryRecord() offset = %d, want %d", offset, want)
+			}
+			if !bytes.Equal(eocd, b[offset:]) {
+				t.Errorf("findEndOfCentralDirectoryRecord() returned wrong record")
+			}
+		})
+	}
+}
@@ -0,0 +1,447 @@
+package _go
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AliasLatest always points to the last uploaded version of a base.
+const AliasLatest = "latest"
+
+// DefaultUploadLimit is the default size limit of base apks uploaded to the registry API.
+const DefaultUploadLimit = 1 << 30
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrExists   = errors.New("already exists")
+	ErrInvalid  = errors.New("invalid")
+)
+
+var registryName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// BaseVersion is one uploaded version of a base apk.
+type BaseVersion struct {
+	Version  string    `json:"version"`
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256"`
+	Uploaded time.Time `json:"uploaded"`
+}
+
+// BaseMeta is the metadata of a base apk, stored as meta.json next to its versions.
+type BaseMeta struct {
+	Name     string         `json:"name"`
+	Versions []*BaseVersion `json:"versions"`
+	// Aliases maps alias to version, AliasLatest is maintained by the registry, other
+	// aliases are pinned by SetAlias.
+	Aliases map[string]string `json:"aliases"`
+}
+
+func (m *BaseMeta) version(v string) *BaseVersion {
+	for _, bv := range m.Versions {
+		if bv.Version == v {
+			return bv
+		}
+	}
+	return nil
+}
+
+func (m *BaseMeta) clone() *BaseMeta {
+	c := &BaseMeta{Name: m.Name, Versions: append([]*BaseVersion{}, m.Versions...), Aliases: make(map[string]string)}
+	for k, v := range m.Aliases {
+		c.Aliases[k] = v
+	}
+	return c
+}
+
+// Registry stores versions of base apks in a local directory:
+//
+//	{dir}/{base}/{version}.apk
+//	{dir}/{base}/meta.json
+//
+// Metadata changes are written to a temporary file and renamed, then swapped in memory,
+// so a download resolving base@latest sees either the old or the new version. A download
+// opens the file of its version when resolving it, so deleting the version meanwhile only
+// unlinks the file and the download keeps working.
+type Registry struct {
+	dir string
+
+	mu    sync.RWMutex
+	bases map[string]*BaseMeta
+	// apks of every version, keyed by "base@version"
+	apks map[string]*serverBase
+	// removed are called with the digest of deleted versions
+	removed []func(digest string)
+}
+
+// onRemove calls fn with the sha256 of every version deleted from now on.
+func (r *Registry) onRemove(fn func(digest string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removed = append(r.removed, fn)
+}
+
+// NewRegistry loads the registry in dir, dir is created if it does not exist.
+func NewRegistry(dir string) (*Registry, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	r := &Registry{dir: dir, bases: make(map[string]*BaseMeta), apks: make(map[string]*serverBase)}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !registryName.MatchString(e.Name()) {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name(), "meta.json"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var meta BaseMeta
+		if err := json.Unmarshal(b, &meta); err != nil {
+			return nil, newErrf("Error occurred on loading base %s, %s", e.Name(), err)
+		}
+		for _, v := range meta.Versions {
+			sb, err := r.load(meta.Name, v)
+			if err != nil {
+				return nil, err
+			}
+			r.apks[meta.Name+"@"+v.Version] = sb
+		}
+		r.bases[meta.Name] = &meta
+	}
+	return r, nil
+}
+
+func (r *Registry) path(base, version string) string {
+	return filepath.Join(r.dir, base, version+".apk")
+}
+
+func (r *Registry) load(base string, v *BaseVersion) (*serverBase, error) {
+	a, err := NewApk(r.path(base, v.Version))
+	if err != nil {
+		return nil, err
+	}
+	return &serverBase{name: base + "-" + v.Version, apk: a, digest: v.SHA256, modTime: v.Uploaded}, nil
+}
+
+// List returns the metadata of all bases sorted by name.
+func (r *Registry) List() []*BaseMeta {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ret := make([]*BaseMeta, 0, len(r.bases))
+	for _, m := range r.bases {
+		ret = append(ret, m.clone())
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+// Get returns the metadata of base.
+func (r *Registry) Get(base string) (*BaseMeta, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	m, ok := r.bases[base]
+	if !ok {
+		return nil, newErrf("base %s: %w", base, ErrNotFound)
+	}
+	return m.clone(), nil
+}
+
+// Upload stores a new version of base read from body. The apk is validated before it
+// becomes visible, and AliasLatest is moved to it. version must not be an alias of
+// base.
+func (r *Registry) Upload(base, version string, body io.Reader) (*BaseVersion, error) {
+	if !registryName.MatchString(base) || !registryName.MatchString(version) || version == AliasLatest {
+		return nil, newErrf("base %q or version %q: %w", base, version, ErrInvalid)
+	}
+	if err := os.MkdirAll(filepath.Join(r.dir, base), 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Join(r.dir, base), ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	size, err := io.Copy(tmp, io.TeeReader(body, h))
+	if err == nil {
+		err = validateApk(tmp, size)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	v := &BaseVersion{Version: version, Size: size, SHA256: hex.EncodeToString(h.Sum(nil)), Uploaded: time.Now().UTC()}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	meta, ok := r.bases[base]
+	if !ok {
+		meta = &BaseMeta{Name: base, Aliases: make(map[string]string)}
+	}
+	if meta.version(version) != nil {
+		return nil, newErrf("base %s@%s: %w", base, version, ErrExists)
+	}
+	if _, ok := meta.Aliases[version]; ok {
+		return nil, newErrf("version %q is an alias: %w", version, ErrInvalid)
+	}
+	if err := os.Rename(tmp.Name(), r.path(base, version)); err != nil {
+		return nil, err
+	}
+	sb, err := r.load(base, v)
+	if err != nil {
+		os.Remove(r.path(base, version))
+		return nil, err
+	}
+	meta = meta.clone()
+	meta.Versions = append(meta.Versions, v)
+	meta.Aliases[AliasLatest] = version
+	if err := r.save(meta); err != nil {
+		os.Remove(r.path(base, version))
+		return nil, err
+	}
+	r.bases[base] = meta
+	r.apks[base+"@"+version] = sb
+	return v, nil
+}
+
+// validateApk makes sure the upload has a parseable EOCD and APK Signing Block.
+func validateApk(f *os.File, size int64) error {
+	if _, err := newZipSectionsAt(f, size); err != nil {
+		return newErrf("%s: %w", err, ErrInvalid)
+	}
+	return nil
+}
+
+// Delete removes a version of base, aliases to it are removed and AliasLatest moves
+// back to the last remaining version. The base is removed with its last version.
+func (r *Registry) Delete(base, version string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	meta, ok := r.bases[base]
+	if !ok || meta.version(version) == nil {
+		return newErrf("base %s@%s: %w", base, version, ErrNotFound)
+	}
+	meta = meta.clone()
+	versions := meta.Versions[:0]
+	for _, v := range meta.Versions {
+		if v.Version != version {
+			versions = append(versions, v)
+		}
+	}
+	meta.Versions = versions
+	for alias, v := range meta.Aliases {
+		if v == version {
+			delete(meta.Aliases, alias)
+		}
+	}
+	if n := len(meta.Versions); n > 0 {
+		meta.Aliases[AliasLatest] = meta.Versions[n-1].Version
+		if err := r.save(meta); err != nil {
+			return err
+		}
+		r.bases[base] = meta
+	} else {
+		if err := os.Remove(filepath.Join(r.dir, base, "meta.json")); err != nil {
+			return err
+		}
+		delete(r.bases, base)
+	}
+	sb := r.apks[base+"@"+version]
+	delete(r.apks, base+"@"+version)
+	for _, fn := range r.removed {
+		fn(sb.digest)
+	}
+	if err := os.Remove(r.path(base, version)); err != nil {
+		return err
+	}
+	if len(meta.Versions) == 0 {
+		// fails if anything else is left in the directory
+		_ = os.Remove(filepath.Join(r.dir, base))
+	}
+	return nil
+}
+
+// SetAlias pins alias of base to version, e.g. "stable".
+func (r *Registry) SetAlias(base, alias, version string) error {
+	if !registryName.MatchString(alias) || alias == AliasLatest {
+		return newErrf("alias %q: %w", alias, ErrInvalid)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	meta, ok := r.bases[base]
+	if !ok || meta.version(version) == nil {
+		return newErrf("base %s@%s: %w", base, version, ErrNotFound)
+	}
+	if meta.version(alias) != nil {
+		return newErrf("alias %q is a version: %w", alias, ErrInvalid)
+	}
+	meta = meta.clone()
+	meta.Aliases[alias] = version
+	if err := r.save(meta); err != nil {
+		return err
+	}
+	r.bases[base] = meta
+	return nil
+}
+
+// save writes meta.json atomically.
+func (r *Registry) save(meta *BaseMeta) error {
+	b, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(r.dir, meta.Name)
+	tmp, err := os.CreateTemp(dir, ".meta-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, "meta.json"))
+}
+
+// resolve finds "base@version", "base@alias" or "base", which means base@latest. The
+// file of the version is opened for the returned base, which must be released.
+func (r *Registry) resolve(ref string) (*serverBase, error) {
+	base, version := ref, AliasLatest
+	if i := strings.IndexByte(ref, '@'); i >= 0 {
+		base, version = ref[:i], ref[i+1:]
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	meta, ok := r.bases[base]
+	if !ok {
+		return nil, newErrf("base %s: %w", base, ErrNotFound)
+	}
+	if v, ok := meta.Aliases[version]; ok {
+		version = v
+	}
+	sb, ok := r.apks[base+"@"+version]
+	if !ok {
+		return nil, newErrf("base %s@%s: %w", base, version, ErrNotFound)
+	}
+	// opened with the lock held, Delete unlinks it after
+	f, err := os.Open(r.path(base, version))
+	if err != nil {
+		return nil, err
+	}
+	a := *sb.apk
+	a.file = f
+	opened := *sb
+	opened.apk, opened.file = &a, f
+	return &opened, nil
+}
+
+// WithRegistry serves the bases of reg too, downloads resolve "base@version",
+// "base@alias" or "base" as base@latest, and the registry API is served:
+//
+//	GET    /bases
+//	GET    /bases/{base}
+//	PUT    /bases/{base}/{version}          upload the apk in request body
+//	DELETE /bases/{base}/{version}
+//	PUT    /bases/{base}/aliases/{alias}    {"version": "..."}
+//
+// Uploads over the limit of WithUploadLimit get 413.
+func WithRegistry(reg *Registry) func(*Server) {
+	return func(s *Server) {
+		s.tenant("").registry = reg
+	}
+}
+
+// WithTenantRegistries gives every tenant its own registry in {dir}/{tenant}, opened on
+// first use, see WithRegistry and WithAuth.
+func WithTenantRegistries(dir string) func(*Server) {
+	return func(s *Server) {
+		s.tenantRegistries = dir
+	}
+}
+
+func (s *Server) serveBases(w http.ResponseWriter, r *http.Request) {
+	reg, err := s.registry(TenantFromContext(r.Context()))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if reg == nil {
+		http.NotFound(w, r)
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/bases"), "/"), "/")
+	if parts[0] == "" {
+		parts = nil
+	}
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, reg.List())
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		meta, err := reg.Get(parts[0])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, meta)
+	case len(parts) == 2 && (r.Method == http.MethodPut || r.Method == http.MethodPost):
+		v, err := reg.Upload(parts[0], parts[1], http.MaxBytesReader(w, r.Body, s.uploadLimit))
+		if err != nil && isTooLarge(err) {
+			err = newErrf("upload larger than %d bytes: %w", s.uploadLimit, ErrTooLarge)
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, v)
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		if err := reg.Delete(parts[0], parts[1]); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 3 && parts[1] == "aliases" && r.Method == http.MethodPut:
+		var req struct {
+			Version string `json:"version"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, newErrf("%s: %w", err, ErrInvalid))
+			return
+		}
+		if err := reg.SetAlias(parts[0], parts[2], req.Version); err != nil {
+			writeError(w, err)
+			return
+		}
+		meta, err := reg.Get(parts[0])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, meta)
+	case len(parts) <= 2 || (len(parts) == 3 && parts[1] == "aliases"):
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
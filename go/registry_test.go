@@ -0,0 +1,172 @@
+package _go
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	dir := t.TempDir()
+	reg, err := NewRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewServer(WithRegistry(reg)))
+	defer srv.Close()
+
+	v1 := newTestApk(t, t.TempDir())
+	v2 := newTestApk(t, t.TempDir(), appendIdValue(nil, 0x1234, []byte("v2")))
+	do := func(method, path string, body io.Reader) (int, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, body)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, b
+	}
+	upload := func(version, path string) int {
+		t.Helper()
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		code, _ := do(http.MethodPut, "/bases/demo/"+version, f)
+		return code
+	}
+	download := func(ref string) []byte {
+		t.Helper()
+		code, b := do(http.MethodGet, "/apk/"+ref+"/xiaomi", nil)
+		if code != http.StatusOK {
+			t.Fatalf("GET %s got status %d", ref, code)
+		}
+		return b
+	}
+	want1, want2 := testChannelApk(t, v1, "xiaomi", nil), testChannelApk(t, v2, "xiaomi", nil)
+
+	if code := upload("1.0", v1); code != http.StatusCreated {
+		t.Fatalf("upload 1.0 got status %d", code)
+	}
+	if code := upload("1.0", v2); code != http.StatusConflict {
+		t.Errorf("upload 1.0 again got status %d", code)
+	}
+	if code, _ := do(http.MethodPut, "/bases/demo/bad", strings.NewReader("not an apk")); code != http.StatusBadRequest {
+		t.Errorf("upload invalid apk got status %d", code)
+	}
+	if code := upload("2.0", v2); code != http.StatusCreated {
+		t.Fatalf("upload 2.0 got status %d", code)
+	}
+	if !bytes.Equal(download("demo"), want2) || !bytes.Equal(download("demo@latest"), want2) ||
+		!bytes.Equal(download("demo@1.0"), want1) {
+		t.Errorf("downloads do not match the uploaded versions")
+	}
+
+	// in-flight download of the version being deleted
+	sb, err := reg.resolve("demo@2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sb.release()
+	inflight, err := sb.apk.Virtual("xiaomi", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inflight.Close()
+
+	if code, _ := do(http.MethodPut, "/bases/demo/aliases/stable", strings.NewReader(`{"version":"1.0"}`)); code != http.StatusOK {
+		t.Errorf("set alias got status %d", code)
+	}
+	if code := upload("stable", v2); code != http.StatusBadRequest {
+		t.Errorf("upload version named as an alias got status %d", code)
+	}
+	if code, _ := do(http.MethodDelete, "/bases/demo/2.0", nil); code != http.StatusNoContent {
+		t.Errorf("delete 2.0 got status %d", code)
+	}
+	if !bytes.Equal(download("demo@stable"), want1) || !bytes.Equal(download("demo"), want1) {
+		t.Errorf("downloads after delete do not match 1.0")
+	}
+	if code, _ := do(http.MethodGet, "/apk/demo@2.0/xiaomi", nil); code != http.StatusNotFound {
+		t.Errorf("GET deleted version got status %d", code)
+	}
+	if b, err := io.ReadAll(inflight); err != nil || !bytes.Equal(b, want2) {
+		t.Errorf("in-flight download broken by delete, %v", err)
+	}
+
+	// reload from dir
+	reg, err = NewRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := reg.Get("demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.Versions) != 1 || meta.Aliases[AliasLatest] != "1.0" || meta.Aliases["stable"] != "1.0" {
+		t.Errorf("reloaded meta = %+v", meta)
+	}
+
+	// a download resolved before the delete opens the apk after it
+	sb, err = reg.resolve("demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sb.release()
+	if err := reg.Delete("demo", "1.0"); err != nil {
+		t.Fatal(err)
+	}
+	v, err := sb.apk.Virtual("xiaomi", nil)
+	if err != nil {
+		t.Fatalf("open after delete: %v", err)
+	}
+	defer v.Close()
+	if b, err := io.ReadAll(v); err != nil || !bytes.Equal(b, want1) {
+		t.Errorf("download resolved before delete broken, %v", err)
+	}
+
+	// the base goes with its last version
+	if _, err := reg.Get("demo"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after deleting the last version got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "demo")); !os.IsNotExist(err) {
+		t.Errorf("base dir left after deleting the last version: %v", err)
+	}
+	if reg, err = NewRegistry(dir); err != nil || len(reg.List()) != 0 {
+		t.Errorf("reloaded registry has bases %v, %v", reg.List(), err)
+	}
+}
+
+func TestRegistry_uploadLimit(t *testing.T) {
+	dir := t.TempDir()
+	reg, err := NewRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewServer(WithRegistry(reg), WithUploadLimit(100)))
+	defer srv.Close()
+	b, err := os.ReadFile(newTestApk(t, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/bases/demo/1.0", bytes.NewReader(b))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("upload over the limit got status %d", resp.StatusCode)
+	}
+	if entries, err := os.ReadDir(filepath.Join(dir, "demo")); err != nil || len(entries) > 0 {
+		t.Errorf("files left in registry %v, %v", entries, err)
+	}
+}
@@ -0,0 +1,168 @@
+package _go
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type remote struct {
+	client *http.Client
+	header http.Header
+}
+
+// WithHTTPClient sets the client used by NewRemoteApk, http.DefaultClient by default.
+func WithHTTPClient(c *http.Client) func(*remote) {
+	return func(r *remote) {
+		r.client = c
+	}
+}
+
+// WithHTTPHeader adds a header to every request made by NewRemoteApk, e.g. Authorization.
+func WithHTTPHeader(key, value string) func(*remote) {
+	return func(r *remote) {
+		if r.header == nil {
+			r.header = make(http.Header)
+		}
+		r.header.Add(key, value)
+	}
+}
+
+// NewRemoteApk reads the channel info of the apk at url with a few HTTP Range requests:
+// one for the file size, then the EOCD, the signing block footer and the signing block.
+// If the server ignores Range, the whole file is downloaded once and read from memory.
+//
+// The returned apk reads through HTTP too, so generating from it downloads the base apk.
+func NewRemoteApk(ctx context.Context, url string, opts ...func(*remote)) (*apk, error) {
+	r := &remote{client: http.DefaultClient}
+	for _, opt := range opts {
+		opt(r)
+	}
+	src, size, err := r.open(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return NewApkFromReaderAt(src, size)
+}
+
+// open probes the size of url with the first byte, the returned reader is a
+// httpReaderAt if Range is supported, or the whole body otherwise.
+func (r *remote) open(ctx context.Context, url string) (io.ReaderAt, int64, error) {
+	resp, err := r.get(ctx, url, 0, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		_, _, size, err := contentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, 0, err
+		}
+		return &httpReaderAt{ctx: ctx, remote: r, url: url, size: size}, size, nil
+	case http.StatusOK:
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, 0, err
+		}
+		return bytes.NewReader(b), int64(len(b)), nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		// empty file
+		return bytes.NewReader(nil), 0, nil
+	default:
+		return nil, 0, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+}
+
+func (r *remote) get(ctx context.Context, url string, off, n int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	return r.client.Do(req)
+}
+
+// contentRange parses the first and last byte and the complete length of "bytes 0-0/1234".
+func contentRange(s string) (first, last, size int64, err error) {
+	invalid := fmt.Errorf("invalid Content-Range %q", s)
+	r := strings.TrimPrefix(s, "bytes ")
+	i := strings.IndexByte(r, '-')
+	j := strings.LastIndexByte(r, '/')
+	if r == s || i < 0 || j < i {
+		return 0, 0, 0, invalid
+	}
+	if first, err = strconv.ParseInt(r[:i], 10, 64); err != nil {
+		return 0, 0, 0, invalid
+	}
+	if last, err = strconv.ParseInt(r[i+1:j], 10, 64); err != nil || last < first {
+		return 0, 0, 0, invalid
+	}
+	if size, err = strconv.ParseInt(r[j+1:], 10, 64); err != nil || last >= size {
+		return 0, 0, 0, invalid
+	}
+	return first, last, size, nil
+}
+
+// httpReaderAt makes one Range request for each ReadAt.
+type httpReaderAt struct {
+	ctx    context.Context
+	remote *remote
+	url    string
+	size   int64
+}
+
+func (h *httpReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= h.size {
+		return 0, io.EOF
+	}
+	want := int64(len(p))
+	if off+want > h.size {
+		want = h.size - off
+	}
+	if want == 0 {
+		return 0, nil
+	}
+	resp, err := h.remote.get(h.ctx, h.url, off, want)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// a proxy may answer with another range than asked, never copy it into p
+		first, last, _, err := contentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return 0, err
+		}
+		if first != off || last-first+1 < want {
+			return 0, fmt.Errorf("GET %s: got Content-Range %q for bytes %d-%d",
+				h.url, resp.Header.Get("Content-Range"), off, off+want-1)
+		}
+	case http.StatusOK:
+		// Range ignored this time, skip to off in the whole body
+		if _, err := io.CopyN(io.Discard, resp.Body, off); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("GET %s: %s", h.url, resp.Status)
+	}
+	n, err := io.ReadFull(resp.Body, p[:want])
+	if err == nil && want < int64(len(p)) {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,110 @@
+package _go
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewRemoteApk(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewApk(newTestApk(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := a.PutChannelWithExtra("xiaomi", map[string]string{"k": "v"}, filepath.Join(dir, "out.apk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		maxRequests int64
+		wantErr     bool
+	}{
+		{"range", func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "out.apk", time.Time{}, bytes.NewReader(b))
+		}, 5, false},
+		{"ignore range", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(b)
+		}, 1, false},
+		{"other range", func(w http.ResponseWriter, r *http.Request) {
+			// a proxy answering every request from the start of the file
+			var first, last int64
+			fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &first, &last)
+			r.Header.Set("Range", fmt.Sprintf("bytes=0-%d", last-first))
+			http.ServeContent(w, r, "out.apk", time.Time{}, bytes.NewReader(b))
+		}, 5, true},
+		{"short range", func(w http.ResponseWriter, r *http.Request) {
+			var first, last int64
+			fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &first, &last)
+			r.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", first, first))
+			http.ServeContent(w, r, "out.apk", time.Time{}, bytes.NewReader(b))
+		}, 5, true},
+		{"not found", http.NotFound, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt64(&requests, 1)
+				if r.Header.Get("X-Token") != "t" {
+					http.Error(w, "forbidden", http.StatusForbidden)
+					return
+				}
+				tt.handler(w, r)
+			}))
+			defer srv.Close()
+
+			got, err := NewRemoteApk(context.Background(), srv.URL+"/out.apk",
+				WithHTTPClient(srv.Client()), WithHTTPHeader("X-Token", "t"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewRemoteApk() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Channel() != "xiaomi" || got.Extras()["k"] != "v" {
+				t.Errorf("NewRemoteApk() got %v", got.All())
+			}
+			if n := atomic.LoadInt64(&requests); n > tt.maxRequests {
+				t.Errorf("NewRemoteApk() made %d requests, want at most %d", n, tt.maxRequests)
+			}
+		})
+	}
+}
+
+func TestHttpReaderAt_contentRange(t *testing.T) {
+	b := []byte("0123456789")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a proxy answering with the start of the file whatever was asked
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-3/%d", len(b)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(b[:4])
+	}))
+	defer srv.Close()
+
+	h := &httpReaderAt{ctx: context.Background(), remote: &remote{client: srv.Client()}, url: srv.URL, size: int64(len(b))}
+	p := make([]byte, 4)
+	if _, err := h.ReadAt(p, 0); err != nil || string(p) != "0123" {
+		t.Errorf("ReadAt(0) = %q, %v", p, err)
+	}
+	if _, err := h.ReadAt(p, 4); err == nil {
+		t.Errorf("ReadAt(4) of another range = %q", p)
+	}
+	if _, err := h.ReadAt(make([]byte, 6), 0); err == nil {
+		t.Errorf("ReadAt(0) of a shorter range succeeded")
+	}
+}
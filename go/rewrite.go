@@ -0,0 +1,364 @@
+package _go
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ZIP records, see https://en.wikipedia.org/wiki/Zip_(file_format)
+const (
+	_ZIP_LOCAL_FILE_HEADER_SIG      = 0x04034b50
+	_ZIP_LOCAL_FILE_HEADER_SIZE     = 30
+	_ZIP_CENTRAL_DIR_HEADER_SIG     = 0x02014b50
+	_ZIP_CENTRAL_DIR_HEADER_SIZE    = 46
+	_ZIP_DATA_DESCRIPTOR_SIG        = 0x08074b50
+	_ZIP_FLAG_DATA_DESCRIPTOR       = 0x0008
+	_ZIP_FLAG_UTF8                  = 0x0800
+	_ZIP_METHOD_STORED              = 0
+	_ZIP_METHOD_DEFLATED            = 8
+	_ZIP_ALIGNMENT_EXTRA_FIELD_ID   = 0xd935
+	_ZIP_ALIGNMENT_EXTRA_FIELD_SIZE = 6
+
+	// zipDosDate is 1981-01-01, the time of entries written by walle as apksigner does,
+	// so the outputs are reproducible.
+	zipDosDate = 1<<9 | 1<<5 | 1
+)
+
+// zipEntry is an entry of the central directory.
+type zipEntry struct {
+	name   string
+	flags  uint16
+	method uint16
+	// compressedSize is of the data after the local file header.
+	compressedSize int64
+	// localOffset is the offset of the local file header.
+	localOffset int64
+	// header is the central directory file header.
+	header []byte
+}
+
+// readZipEntries parses the central directory of z.
+func readZipEntries(z *zipSections) ([]*zipEntry, error) {
+	var entries []*zipEntry
+	cd := z.centraDir
+	for off := 0; off < len(cd); {
+		if len(cd)-off < _ZIP_CENTRAL_DIR_HEADER_SIZE || getUint32(cd, off) != _ZIP_CENTRAL_DIR_HEADER_SIG {
+			return nil, fmt.Errorf("malformed central directory file header at %d", off)
+		}
+		n := _ZIP_CENTRAL_DIR_HEADER_SIZE + int(getUint16(cd, off+28)) + int(getUint16(cd, off+30)) + int(getUint16(cd, off+32))
+		if len(cd)-off < n {
+			return nil, fmt.Errorf("truncated central directory file header at %d", off)
+		}
+		h := cd[off : off+n]
+		entries = append(entries, &zipEntry{
+			name:           string(h[_ZIP_CENTRAL_DIR_HEADER_SIZE : _ZIP_CENTRAL_DIR_HEADER_SIZE+int(getUint16(h, 28))]),
+			flags:          getUint16(h, 8),
+			method:         getUint16(h, 10),
+			compressedSize: int64(getUint32(h, 20)),
+			localOffset:    int64(getUint32(h, 42)),
+			header:         h,
+		})
+		off += n
+	}
+	return entries, nil
+}
+
+// aligned reports whether the data of e must be aligned, stored files must be.
+func (e *zipEntry) aligned() bool {
+	return e.method == _ZIP_METHOD_STORED && !strings.HasSuffix(e.name, "/")
+}
+
+// zipAlignment returns the alignment of the data of stored entries, 4096 for native
+// libraries to be mapped from the apk and 4 for the others, as zipalign -p does.
+func zipAlignment(name string) int64 {
+	if strings.HasSuffix(name, ".so") {
+		return ANDROID_COMMON_PAGE_ALIGNMENT_BYTES
+	}
+	return 4
+}
+
+// alignExtra returns the extra field at extraOffset of a local file header with an
+// alignment field of apksigner appended, so the data after it is aligned. Former
+// alignment fields and zero padding of zipalign are dropped.
+func alignExtra(extra []byte, extraOffset, alignment int64) []byte {
+	var kept []byte
+	for b := extra; ; {
+		if len(b) == 0 {
+			extra = kept
+			break
+		}
+		if len(b) < 4 || len(b)-4 < int(getUint16(b, 2)) {
+			// not a sequence of fields, it is kept as it is
+			break
+		}
+		n := 4 + int(getUint16(b, 2))
+		if id := getUint16(b, 0); id != _ZIP_ALIGNMENT_EXTRA_FIELD_ID && id != 0 {
+			kept = append(kept, b[:n]...)
+		}
+		b = b[n:]
+	}
+	dataOffset := extraOffset + int64(len(extra))
+	pad := (alignment - dataOffset%alignment) % alignment
+	if pad == 0 {
+		return extra
+	}
+	for pad < _ZIP_ALIGNMENT_EXTRA_FIELD_SIZE {
+		pad += alignment
+	}
+	field := make([]byte, pad)
+	putUint16(_ZIP_ALIGNMENT_EXTRA_FIELD_ID, field, 0)
+	putUint16(uint16(pad-4), field, 2)
+	putUint16(uint16(alignment), field, 4)
+	return append(append([]byte{}, extra...), field...)
+}
+
+// zipFile is a new entry of a rewritten zip.
+type zipFile struct {
+	name string
+	data []byte
+}
+
+// sortedZipFiles returns files by name.
+func sortedZipFiles(files map[string][]byte) []*zipFile {
+	ret := make([]*zipFile, 0, len(files))
+	for name, data := range files {
+		ret = append(ret, &zipFile{name: name, data: data})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].name < ret[j].name })
+	return ret
+}
+
+// zipRewriter writes a zip whose stored entries are aligned, the central directory is
+// written on close.
+type zipRewriter struct {
+	w   io.Writer
+	off int64
+	cd  bytes.Buffer
+	n   int
+}
+
+func (zw *zipRewriter) write(b []byte) error {
+	n, err := zw.w.Write(b)
+	zw.off += int64(n)
+	return err
+}
+
+// readLocalHeader reads the local file header of e in r, and returns it without name
+// and extra field, the name, the extra field and the offset of the data.
+func (e *zipEntry) readLocalHeader(r io.ReaderAt) (local, name, extra []byte, dataOffset int64, err error) {
+	local = make([]byte, _ZIP_LOCAL_FILE_HEADER_SIZE)
+	if err = readFullAt(r, local, e.localOffset); err != nil {
+		return
+	}
+	if getUint32(local, 0) != _ZIP_LOCAL_FILE_HEADER_SIG {
+		err = fmt.Errorf("malformed local file header of %s", e.name)
+		return
+	}
+	nameLen, extraLen := int64(getUint16(local, 26)), int64(getUint16(local, 28))
+	nameExtra := make([]byte, nameLen+extraLen)
+	if err = readFullAt(r, nameExtra, e.localOffset+_ZIP_LOCAL_FILE_HEADER_SIZE); err != nil {
+		return
+	}
+	dataOffset = e.localOffset + _ZIP_LOCAL_FILE_HEADER_SIZE + nameLen + extraLen
+	return local, nameExtra[:nameLen], nameExtra[nameLen:], dataOffset, nil
+}
+
+// copyEntry copies e of the zip in r as it is, only the extra field of its local file
+// header is changed if its data would not be aligned. It reports whether it is.
+func (zw *zipRewriter) copyEntry(r io.ReaderAt, e *zipEntry) (changed bool, err error) {
+	local, name, extra, dataOffset, err := e.readLocalHeader(r)
+	if err != nil {
+		return false, err
+	}
+	dataSize := e.compressedSize
+	if e.flags&_ZIP_FLAG_DATA_DESCRIPTOR != 0 {
+		sig := make([]byte, 4)
+		if err := readFullAt(r, sig, dataOffset+dataSize); err != nil {
+			return false, err
+		}
+		// crc-32, compressed and uncompressed sizes with an optional signature
+		dataSize += 12
+		if getUint32(sig, 0) == _ZIP_DATA_DESCRIPTOR_SIG {
+			dataSize += 4
+		}
+	}
+
+	nameEnd := zw.off + _ZIP_LOCAL_FILE_HEADER_SIZE + int64(len(name))
+	if alignment := zipAlignment(e.name); e.aligned() && (nameEnd+int64(len(extra)))%alignment != 0 {
+		extra = alignExtra(extra, nameEnd, alignment)
+		putUint16(uint16(len(extra)), local, 28)
+		changed = true
+	}
+	header := append([]byte{}, e.header...)
+	putUint32(uint32(zw.off), header, 42)
+	zw.cd.Write(header)
+	zw.n++
+	if err := zw.write(append(append(local, name...), extra...)); err != nil {
+		return false, err
+	}
+	n, err := io.Copy(zw.w, io.NewSectionReader(r, dataOffset, dataSize))
+	zw.off += n
+	return changed, err
+}
+
+// addFile writes f deflated, or stored and aligned if it does not get smaller.
+func (zw *zipRewriter) addFile(f *zipFile) error {
+	var deflated bytes.Buffer
+	fw, err := flate.NewWriter(&deflated, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(f.data); err != nil {
+		return err
+	}
+	if err := fw.Close(); err != nil {
+		return err
+	}
+	method, data := uint16(_ZIP_METHOD_DEFLATED), deflated.Bytes()
+	var extra []byte
+	if len(data) >= len(f.data) {
+		method, data = _ZIP_METHOD_STORED, f.data
+		extra = alignExtra(nil, zw.off+_ZIP_LOCAL_FILE_HEADER_SIZE+int64(len(f.name)), zipAlignment(f.name))
+	}
+	var flags uint16
+	for _, c := range f.name {
+		if c >= 0x80 {
+			flags |= _ZIP_FLAG_UTF8
+			break
+		}
+	}
+
+	// fields from version needed to extract to extra field length are the same in
+	// local file header and central directory file header
+	common := make([]byte, 26)
+	putUint16(20, common, 0)
+	putUint16(flags, common, 2)
+	putUint16(method, common, 4)
+	putUint16(zipDosDate, common, 8)
+	putUint32(crc32.ChecksumIEEE(f.data), common, 10)
+	putUint32(uint32(len(data)), common, 14)
+	putUint32(uint32(len(f.data)), common, 18)
+	putUint16(uint16(len(f.name)), common, 22)
+
+	header := make([]byte, _ZIP_CENTRAL_DIR_HEADER_SIZE)
+	putUint32(_ZIP_CENTRAL_DIR_HEADER_SIG, header, 0)
+	putUint16(20, header, 4)
+	copy(header[6:], common)
+	putUint32(uint32(zw.off), header, 42)
+	zw.cd.Write(header)
+	zw.cd.WriteString(f.name)
+	zw.n++
+
+	local := make([]byte, 4, _ZIP_LOCAL_FILE_HEADER_SIZE)
+	putUint32(_ZIP_LOCAL_FILE_HEADER_SIG, local, 0)
+	local = append(local, common...)
+	putUint16(uint16(len(extra)), local, 28)
+	local = append(append(local, f.name...), extra...)
+	return zw.write(append(local, data...))
+}
+
+// close writes signingBlock, the central directory and the EOCD with the comment of
+// eocd.
+func (zw *zipRewriter) close(signingBlock, eocd []byte) error {
+	if err := zw.write(signingBlock); err != nil {
+		return err
+	}
+	if zw.n > 0xffff || zw.off > 0xffffffff {
+		return errors.New("zip64 is not supported")
+	}
+	cdOffset := zw.off
+	if err := zw.write(zw.cd.Bytes()); err != nil {
+		return err
+	}
+	rec := append([]byte{}, eocd...)
+	putUint16(uint16(zw.n), rec, 8)
+	putUint16(uint16(zw.n), rec, 10)
+	putUint32(uint32(zw.cd.Len()), rec, _ZIP_EOCD_CENTRAL_DIR_SIZE_FIELD_OFFSET)
+	putUint32(uint32(cdOffset), rec, _ZIP_EOCD_CENTRAL_DIR_OFFSET_FIELD_OFFSET)
+	return zw.write(rec)
+}
+
+// rewriteZip writes the apk of z to w with files added or replaced and JAR signed by
+// s, without APK Signing Block. The entries of z are kept in order, the old JAR
+// signature files are dropped and the new entries and the signature are appended.
+func rewriteZip(z *zipSections, files map[string][]byte, s *apkSigner, w io.Writer) error {
+	entries, err := readZipEntries(z)
+	if err != nil {
+		return err
+	}
+	r, size := z.readerAt()
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	opened := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		opened[f.Name] = f
+	}
+
+	var kept []*zipEntry
+	var digests []*zipFile
+	for _, e := range entries {
+		if _, ok := files[e.name]; ok || isJarSignatureFile(e.name) {
+			continue
+		}
+		kept = append(kept, e)
+		if !jarEntryNeedsDigest(e.name) {
+			continue
+		}
+		f, ok := opened[e.name]
+		if !ok {
+			return fmt.Errorf("entry %s is not found", e.name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		h := s.jarDigest().New()
+		_, err = io.Copy(h, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("entry %s: %s", e.name, err)
+		}
+		digests = append(digests, &zipFile{name: e.name, data: h.Sum(nil)})
+	}
+	added := sortedZipFiles(files)
+	for _, f := range added {
+		if strings.HasSuffix(f.name, "/") || isJarSignatureFile(f.name) {
+			return fmt.Errorf("entry %s cannot be added", f.name)
+		}
+		h := s.jarDigest().New()
+		h.Write(f.data)
+		digests = append(digests, &zipFile{name: f.name, data: h.Sum(nil)})
+	}
+	signature, err := s.signJar(digests)
+	if err != nil {
+		return err
+	}
+
+	zw := &zipRewriter{w: w}
+	for _, e := range kept {
+		if _, err := zw.copyEntry(z.source, e); err != nil {
+			return err
+		}
+	}
+	for _, f := range append(added, signature...) {
+		if err := zw.addFile(f); err != nil {
+			return err
+		}
+	}
+	return zw.close(nil, z.eocd)
+}
+
+// isJarSignatureFile reports whether name is the manifest or a signature file of JAR
+// signatures.
+func isJarSignatureFile(name string) bool {
+	return strings.HasPrefix(name, "META-INF/") && !strings.HasSuffix(name, "/") && !jarEntryNeedsDigest(name)
+}
@@ -0,0 +1,245 @@
+package _go
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWithAssets(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldCert := testCertOf(t, oldKey.Public(), oldKey)
+	jar := testJarSign(t, testJar{key: oldKey, cert: oldCert.Raw})
+	var signed bytes.Buffer
+	if err := Sign(bytes.NewReader(jar), int64(len(jar)), &signed, oldKey, []*x509.Certificate{oldCert}); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.apk")
+	if err := os.WriteFile(base, signed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := testCertOf(t, key.Public(), key)
+	lib := make([]byte, 5000)
+	if _, err := rand.Read(lib); err != nil {
+		t.Fatal(err)
+	}
+	assets := func(channel string, extras map[string]string) (map[string][]byte, error) {
+		return map[string][]byte{
+			"assets/partner.json":       []byte(`{"partner":"` + channel + `","id":"` + extras["id"] + `"}`),
+			"classes.dex":               bytes.Repeat([]byte(channel), 100),
+			"lib/arm64-v8a/libwalle.so": lib,
+		}, nil
+	}
+	a, err := NewApk(base, WithAssets(key, []*x509.Certificate{cert}, 24, assets), WithSelfCheck(), WithVerify())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outs, err := a.BatchChannelsWithExtra([]string{"huawei", "xiaomi"}, map[string]string{"id": "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, out := range outs {
+		channel := out.Channel()
+		if channel != "huawei" && channel != "xiaomi" || out.Extras()["id"] != "42" {
+			t.Errorf("channel %s, extras %v", channel, out.Extras())
+		}
+		v, err := VerifyFile(out.Path())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !v.Verified || v.V1 == nil || v.V2 == nil || v.V3 == nil {
+			t.Fatalf("%s is not signed with v1, v2 and v3: %s", out.Path(), v.firstError())
+		}
+		for _, s := range []*SchemeVerification{v.V1, v.V2, v.V3} {
+			if len(s.Signers) != 1 || !s.Signers[0].Certificates[0].Equal(cert) {
+				t.Errorf("%s is not re-signed", out.Path())
+			}
+		}
+
+		zr, err := zip.OpenReader(out.Path())
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := make(map[string]*zip.File)
+		for _, f := range zr.File {
+			files[f.Name] = f
+			if f.Method == zip.Store && !strings.HasSuffix(f.Name, "/") {
+				off, err := f.DataOffset()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if off%zipAlignment(f.Name) != 0 {
+					t.Errorf("%s at %d is not aligned", f.Name, off)
+				}
+			}
+		}
+		for name, want := range map[string]string{
+			"assets/partner.json":       `{"partner":"` + channel + `","id":"42"}`,
+			"classes.dex":               string(bytes.Repeat([]byte(channel), 100)),
+			"lib/arm64-v8a/libwalle.so": string(lib),
+			"AndroidManifest.xml":       "manifest",
+			"META-INF/services/a.b.C":   "service",
+		} {
+			f, ok := files[name]
+			if !ok {
+				t.Errorf("%s is missing", name)
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want {
+				t.Errorf("%s = %.20q, want %.20q", name, got, want)
+			}
+		}
+		if files["lib/arm64-v8a/libwalle.so"].Method != zip.Store {
+			t.Errorf("incompressible entry is deflated")
+		}
+		if _, ok := files["META-INF/CERT.RSA"]; ok {
+			t.Errorf("old signature is kept")
+		}
+		zr.Close()
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("%d files left in output directory", len(entries))
+	}
+}
+
+func TestWithAssets_minSdkVersion(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaCert := testCertOf(t, rsaKey.Public(), rsaKey)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecCert := testCertOf(t, ecKey.Public(), ecKey)
+	jar := testJarSign(t, testJar{key: rsaKey, cert: rsaCert.Raw})
+	var signed bytes.Buffer
+	if err := Sign(bytes.NewReader(jar), int64(len(jar)), &signed, rsaKey, []*x509.Certificate{rsaCert}); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.apk")
+	if err := os.WriteFile(base, signed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assets := func(channel string, extras map[string]string) (map[string][]byte, error) {
+		return map[string][]byte{"assets/partner.json": []byte(channel)}, nil
+	}
+
+	tests := []struct {
+		name          string
+		key           crypto.PrivateKey
+		cert          *x509.Certificate
+		minSdkVersion int
+		wantDigest    string
+		wantErr       bool
+	}{
+		{"rsa legacy", rsaKey, rsaCert, 14, "SHA1-Digest", false},
+		{"rsa", rsaKey, rsaCert, 18, "SHA-256-Digest", false},
+		{"ec legacy", ecKey, ecCert, 14, "", true},
+		{"ec", ecKey, ecCert, 18, "SHA-256-Digest", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewApk(base, WithAssets(tt.key, []*x509.Certificate{tt.cert}, tt.minSdkVersion, assets), WithVerify())
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := a.PutChannel("xiaomi", filepath.Join(t.TempDir(), "out.apk"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PutChannel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			zr, err := zip.OpenReader(out.Path())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer zr.Close()
+			for _, f := range zr.File {
+				if f.Name != jarManifest {
+					continue
+				}
+				rc, err := f.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				manifest, _ := ioutil.ReadAll(rc)
+				rc.Close()
+				if !strings.Contains(string(manifest), "\r\n"+tt.wantDigest+": ") {
+					t.Errorf("manifest has no %s:\n%s", tt.wantDigest, manifest)
+				}
+			}
+		})
+	}
+}
+
+func TestAlignExtra(t *testing.T) {
+	field := func(id uint16, data ...byte) []byte {
+		b := make([]byte, 4)
+		putUint16(id, b, 0)
+		putUint16(uint16(len(data)), b, 2)
+		return append(b, data...)
+	}
+	tests := []struct {
+		name      string
+		extra     []byte
+		offset    int64
+		alignment int64
+		want      int
+	}{
+		{"aligned", nil, 100, 4, 0},
+		{"small padding", nil, 101, 4, 7},
+		{"page", nil, 100, 4096, 3996},
+		{"other field kept", field(0xcafe, 1, 2), 100, 4, 6 + 6},
+		{"old alignment dropped", field(_ZIP_ALIGNMENT_EXTRA_FIELD_ID, 4, 0, 0, 0), 100, 4, 0},
+		{"zipalign padding dropped", make([]byte, 8), 100, 4, 0},
+		{"malformed kept", []byte{1, 2, 3}, 100, 4, 3 + 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := alignExtra(tt.extra, tt.offset, tt.alignment)
+			if len(got) != tt.want {
+				t.Fatalf("got %d bytes, want %d", len(got), tt.want)
+			}
+			if (tt.offset+int64(len(got)))%tt.alignment != 0 {
+				t.Errorf("not aligned")
+			}
+		})
+	}
+}
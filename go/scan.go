@@ -0,0 +1,211 @@
+package _go
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ScanResult is the channel info of one file found by Scan.
+type ScanResult struct {
+	Path    string
+	Channel string
+	Extras  map[string]string
+	// Err is set when the file could not be read, the other fields are empty then.
+	Err error
+}
+
+type scanner struct {
+	concurrency int
+	channels    map[string]bool
+	extraKeys   []string
+	cache       *ScanCache
+}
+
+// WithScanConcurrency sets how many files are read at the same time, runtime.NumCPU() by default.
+func WithScanConcurrency(n int) func(*scanner) {
+	return func(s *scanner) {
+		s.concurrency = n
+	}
+}
+
+// WithChannelFilter keeps only the apks with one of the given channels.
+func WithChannelFilter(channels ...string) func(*scanner) {
+	return func(s *scanner) {
+		if s.channels == nil {
+			s.channels = make(map[string]bool)
+		}
+		for _, ch := range channels {
+			s.channels[ch] = true
+		}
+	}
+}
+
+// WithExtraKeyFilter keeps only the apks whose extras contain all of the given keys.
+func WithExtraKeyFilter(keys ...string) func(*scanner) {
+	return func(s *scanner) {
+		s.extraKeys = append(s.extraKeys, keys...)
+	}
+}
+
+// WithScanCache reuses the channel info of files whose size and mtime did not change
+// since they were read by an earlier Scan with the same cache.
+func WithScanCache(c *ScanCache) func(*scanner) {
+	return func(s *scanner) {
+		s.cache = c
+	}
+}
+
+// Scan reads the channel info of the apks in dir. If dir is a directory, all the *.apk
+// files under it are read, otherwise dir is treated as a glob pattern such as "out/*".
+// Files that fail to read are always kept in the results with Err set, filters only apply
+// to the files read successfully. Results are sorted by path.
+func Scan(dir string, opts ...func(*scanner)) ([]ScanResult, error) {
+	s := &scanner{concurrency: runtime.NumCPU()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.concurrency <= 0 {
+		s.concurrency = 1
+	}
+
+	files, err := listApks(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]ScanResult, len(files))
+	var wg sync.WaitGroup
+	idx := make(chan int)
+	for i := 0; i < s.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range idx {
+				results[i] = s.read(files[i])
+			}
+		}()
+	}
+	for i := range files {
+		idx <- i
+	}
+	close(idx)
+	wg.Wait()
+
+	ret := results[:0]
+	for _, r := range results {
+		if r.Err != nil || s.match(r) {
+			ret = append(ret, r)
+		}
+	}
+	return ret, nil
+}
+
+func listApks(dir string) ([]string, error) {
+	var files []string
+	if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() && strings.EqualFold(filepath.Ext(path), ".apk") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		matches, err := filepath.Glob(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			if isRegularFile(m) == nil {
+				files = append(files, m)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (s *scanner) read(path string) ScanResult {
+	res := ScanResult{Path: path}
+	fi, err := os.Stat(path)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	if s.cache != nil {
+		if info, ok := s.cache.get(path, fi); ok {
+			res.Channel, res.Extras = info.channel, info.extras
+			return res
+		}
+	}
+	m, err := readIdValues(path, APK_CHANNEL_BLOCK_ID)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	info, err := parseChannelBlock(m[APK_CHANNEL_BLOCK_ID])
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	if s.cache != nil {
+		s.cache.put(path, fi, info)
+	}
+	res.Channel, res.Extras = info.channel, info.extras
+	return res
+}
+
+func (s *scanner) match(r ScanResult) bool {
+	if s.channels != nil && !s.channels[r.Channel] {
+		return false
+	}
+	for _, k := range s.extraKeys {
+		if _, ok := r.Extras[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ScanCache keeps the channel info read by Scan, keyed by file path, size and mtime.
+// It is safe for concurrent use.
+type ScanCache struct {
+	mu      sync.Mutex
+	entries map[string]scanCacheEntry
+}
+
+type scanCacheEntry struct {
+	size    int64
+	modTime time.Time
+	info    channelInfo
+}
+
+func NewScanCache() *ScanCache {
+	return &ScanCache{entries: make(map[string]scanCacheEntry)}
+}
+
+func (c *ScanCache) get(path string, fi os.FileInfo) (channelInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[path]
+	if !ok || e.size != fi.Size() || !e.modTime.Equal(fi.ModTime()) {
+		return channelInfo{}, false
+	}
+	return e.info, true
+}
+
+func (c *ScanCache) put(path string, fi os.FileInfo, info channelInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[path] = scanCacheEntry{size: fi.Size(), modTime: fi.ModTime(), info: info}
+}
@@ -0,0 +1,103 @@
+package _go
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewApk(newTestApk(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.BatchChannelsWithExtra([]string{"huawei", "xiaomi"}, map[string]string{"k": "v"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.PutChannel("oppo", filepath.Join(dir, "sub", "base-oppo.apk")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.apk"), []byte("not a zip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		dir  string
+		opts []func(*scanner)
+		want map[string]string // base name -> channel, "!" for errors
+	}{
+		{"dir", dir, nil, map[string]string{
+			"base.apk": "", "base-huawei.apk": "huawei", "base-xiaomi.apk": "xiaomi", "base-oppo.apk": "oppo", "broken.apk": "!",
+		}},
+		{"glob", filepath.Join(dir, "base-*"), nil, map[string]string{
+			"base-huawei.apk": "huawei", "base-xiaomi.apk": "xiaomi",
+		}},
+		{"channel", dir, []func(*scanner){WithChannelFilter("oppo", "huawei")}, map[string]string{
+			"base-huawei.apk": "huawei", "base-oppo.apk": "oppo", "broken.apk": "!",
+		}},
+		{"extra key", dir, []func(*scanner){WithExtraKeyFilter("k"), WithScanConcurrency(1)}, map[string]string{
+			"base-huawei.apk": "huawei", "base-xiaomi.apk": "xiaomi", "broken.apk": "!",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Scan(tt.dir, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Scan() got %d results, want %d: %v", len(got), len(tt.want), got)
+			}
+			for _, r := range got {
+				want, ok := tt.want[filepath.Base(r.Path)]
+				if !ok || (want == "!") != (r.Err != nil) || (r.Err == nil && r.Channel != want) {
+					t.Errorf("Scan() unexpected result %+v", r)
+				}
+			}
+		})
+	}
+}
+
+func TestScanCache(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewApk(newTestApk(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := a.PutChannel("huawei", filepath.Join(dir, "out", "a.apk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(out.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := NewScanCache()
+	if _, err := Scan(filepath.Dir(out.Path()), WithScanCache(cache)); err != nil {
+		t.Fatal(err)
+	}
+
+	// same size and mtime, the cached channel is returned
+	if _, err := a.PutChannel("xiaomi", out.Path()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(out.Path(), fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Scan(filepath.Dir(out.Path()), WithScanCache(cache))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Channel != "huawei" {
+		t.Errorf("Scan() with cache got %v, want huawei", got)
+	}
+	got, err = Scan(filepath.Dir(out.Path()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Channel != "xiaomi" {
+		t.Errorf("Scan() without cache got %v, want xiaomi", got)
+	}
+}
@@ -0,0 +1,114 @@
+package _go
+
+import (
+	"container/list"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultSectionsCacheLimit is the default number of base apks whose sections are cached.
+const DefaultSectionsCacheLimit = 64
+
+// sectionsCache keeps the parsed sections of base apks, so generating many channels
+// from the same base does not search EOCD and read the signing block and central
+// directory again. Entries are keyed by path, size, mtime and inode, a replaced file
+// is parsed again. Cached sections have no source, it is set to the opened file.
+type sectionsCache struct {
+	mu      sync.Mutex
+	limit   int
+	entries map[sectionsKey]*list.Element
+	lru     *list.List // front is the most recently used
+	hits    int64
+	misses  int64
+}
+
+type sectionsKey struct {
+	path    string
+	size    int64
+	modTime time.Time
+	inode   uint64
+}
+
+type sectionsEntry struct {
+	key      sectionsKey
+	sections zipSections
+}
+
+// SectionsCacheStats is a snapshot of the counters of the sections cache.
+type SectionsCacheStats struct {
+	Hits    int64
+	Misses  int64
+	Entries int
+	Limit   int
+}
+
+var defaultSectionsCache = newSectionsCache(DefaultSectionsCacheLimit)
+
+func newSectionsCache(limit int) *sectionsCache {
+	return &sectionsCache{limit: limit, entries: make(map[sectionsKey]*list.Element), lru: list.New()}
+}
+
+// SetSectionsCacheLimit sets how many base apks have their sections cached, 0 disables
+// the cache.
+func SetSectionsCacheLimit(n int) {
+	c := defaultSectionsCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limit = n
+	c.evict()
+}
+
+// SectionsStats returns the counters of the sections cache used by generating and
+// virtual apks.
+func SectionsStats() SectionsCacheStats {
+	c := defaultSectionsCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return SectionsCacheStats{Hits: c.hits, Misses: c.misses, Entries: c.lru.Len(), Limit: c.limit}
+}
+
+// sections returns the sections of f opened from path, reading from the cache if possible.
+func (c *sectionsCache) sections(path string, f *os.File) (zipSections, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return zipSections{}, err
+	}
+	key := sectionsKey{path: path, size: fi.Size(), modTime: fi.ModTime(), inode: fileInode(fi)}
+
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(el)
+		c.hits++
+		z := el.Value.(*sectionsEntry).sections
+		c.mu.Unlock()
+		z.source = f
+		return z, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	z, err := newZipSectionsAt(f, fi.Size())
+	if err != nil {
+		return z, err
+	}
+	cached := z
+	cached.source = nil
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && c.limit > 0 {
+		c.entries[key] = c.lru.PushFront(&sectionsEntry{key: key, sections: cached})
+		c.evict()
+	}
+	return z, nil
+}
+
+// evict drops the least recently used entries over the limit, c.mu must be held.
+func (c *sectionsCache) evict() {
+	for c.lru.Len() > c.limit && c.lru.Len() > 0 {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.entries, el.Value.(*sectionsEntry).key)
+	}
+}
@@ -0,0 +1,87 @@
+package _go
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_sectionsCache(t *testing.T) {
+	dir := t.TempDir()
+	path := newTestApk(t, dir)
+	c := newSectionsCache(1)
+	read := func(path string) zipSections {
+		t.Helper()
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		z, err := c.sections(path, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if z.source != f {
+			t.Errorf("sections() source is not the opened file")
+		}
+		return z
+	}
+	stats := func() (int64, int64) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.hits, c.misses
+	}
+
+	read(path)
+	read(path)
+	if hits, misses := stats(); hits != 1 || misses != 1 {
+		t.Errorf("hits %d misses %d, want 1 and 1", hits, misses)
+	}
+
+	// replaced file is parsed again
+	other := newTestApk(t, t.TempDir(), appendIdValue(nil, 0x1234, []byte("other")))
+	b, err := os.ReadFile(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	z := read(path)
+	if m, _ := findIdValuesInApkSigningBlock(z.signingBlock, 0x1234); m[0x1234] == nil {
+		t.Errorf("sections() returned stale sections")
+	}
+	if hits, misses := stats(); hits != 1 || misses != 2 {
+		t.Errorf("hits %d misses %d, want 1 and 2", hits, misses)
+	}
+
+	// limit 1, the first one is evicted
+	read(other)
+	read(path)
+	if hits, misses := stats(); hits != 1 || misses != 4 {
+		t.Errorf("hits %d misses %d, want 1 and 4", hits, misses)
+	}
+}
+
+func TestSectionsStats(t *testing.T) {
+	a, err := NewApk(newTestApk(t, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := SectionsStats()
+	for _, ch := range []string{"xiaomi", "huawei"} {
+		v, err := a.Virtual(ch, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v.Close()
+	}
+	after := SectionsStats()
+	if after.Hits-before.Hits != 1 || after.Misses-before.Misses != 1 {
+		t.Errorf("SectionsStats() before %+v after %+v", before, after)
+	}
+}
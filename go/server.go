@@ -0,0 +1,480 @@
+package _go
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is a http.Handler that serves channel apks built on the fly from registered
+// base apks, nothing is written to disk:
+//
+//	GET /apk/{base}/{channel}?key=value
+//
+// Query parameters are written as extras, except "expires", "sig" and "tenant" of
+// signed URLs, and a "channel" one is rejected with 400. Range, If-Range and
+// If-None-Match requests are served with http.ServeContent semantics, the ETag is
+// deterministic for the same base, channel and extras. With WithPayload, the full apk
+// is always served.
+//
+// The apks uploaded to POST /inspect are inspected too, see InspectHandler. GET
+// /channels lists the channel allowlist and POST /sign makes signed URLs with WithAuth,
+// see WithAdminUI.
+//
+// Bases, registries and channel allowlists belong to tenants, see WithAuth. Without
+// it, every request is served by the tenant named "".
+type Server struct {
+	mu      sync.RWMutex
+	tenants map[string]*tenant
+	// tenantRegistries is the dir of per-tenant registries, see WithTenantRegistries.
+	tenantRegistries string
+	// payload makes per-download extras, see WithPayload.
+	payload     func(r *http.Request) (map[string]string, error)
+	payloadHook func(r *http.Request, payload map[string]string)
+	// secret signs download URLs, see WithURLSigning.
+	secret []byte
+	// cache keeps generated apks on disk, see WithOutputCache.
+	cache *OutputCache
+	// inspectLimit is the size limit of POST /inspect, see WithInspectLimit.
+	inspectLimit int64
+	// uploadLimit is the size limit of uploaded bases, see WithUploadLimit.
+	uploadLimit int64
+	metrics     Metrics
+	accessLog   *accessLog
+	keys        *KeyStore
+	// adminUI serves the admin page at /admin/, see WithAdminUI.
+	adminUI bool
+
+	mux *http.ServeMux
+}
+
+type tenant struct {
+	bases map[string]*serverBase
+	// channels is the allowlist of channel names, nil allows any channel.
+	channels map[string]bool
+	// registry stores versioned bases, see WithRegistry.
+	registry *Registry
+}
+
+type serverBase struct {
+	name    string
+	apk     *apk
+	digest  string
+	modTime time.Time
+	// file is the apk opened by Registry.resolve, closed by release.
+	file io.Closer
+}
+
+// release closes the file opened for a download of b.
+func (b *serverBase) release() {
+	if b.file != nil {
+		b.file.Close()
+	}
+}
+
+// WithInspectLimit sets the size limit of apks uploaded to POST /inspect, see InspectHandler.
+func WithInspectLimit(n int64) func(*Server) {
+	return func(s *Server) {
+		s.inspectLimit = n
+	}
+}
+
+// WithUploadLimit sets the size limit of base apks uploaded to the registry API, see
+// WithRegistry.
+func WithUploadLimit(n int64) func(*Server) {
+	return func(s *Server) {
+		s.uploadLimit = n
+	}
+}
+
+// WithAllowedChannels only serves the given channels, other channels are not found.
+func WithAllowedChannels(channels ...string) func(*Server) {
+	return WithTenantChannels("", channels...)
+}
+
+// WithTenantChannels only serves the given channels to tenant.
+func WithTenantChannels(tenant string, channels ...string) func(*Server) {
+	return func(s *Server) {
+		t := s.tenant(tenant)
+		if t.channels == nil {
+			t.channels = make(map[string]bool)
+		}
+		for _, ch := range channels {
+			t.channels[ch] = true
+		}
+	}
+}
+
+func NewServer(opts ...func(*Server)) *Server {
+	s := &Server{
+		tenants:      make(map[string]*tenant),
+		inspectLimit: DefaultInspectLimit,
+		uploadLimit:  DefaultUploadLimit,
+		mux:          http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.cache != nil {
+		for _, t := range s.tenants {
+			if t.registry != nil {
+				t.registry.onRemove(s.cache.InvalidateBase)
+			}
+		}
+	}
+	s.mux.Handle("/apk/", s.authDownload(http.HandlerFunc(s.serveApk)))
+	s.mux.Handle("/bases", s.authBases(http.HandlerFunc(s.serveBases)))
+	s.mux.Handle("/bases/", s.authBases(http.HandlerFunc(s.serveBases)))
+	s.mux.Handle("/inspect", s.authScope(ScopeRead, InspectHandler(s.inspectLimit)))
+	s.mux.Handle("/channels", s.authScope(ScopeRead, http.HandlerFunc(s.serveChannels)))
+	if s.keys != nil {
+		// signed URLs are as good as a download key, so they are only made for one
+		s.mux.Handle("/sign", s.authScope(ScopeDownload, http.HandlerFunc(s.serveSign)))
+	}
+	if s.adminUI {
+		s.mux.Handle("/admin/", adminHandler())
+	}
+	if h, ok := s.metrics.(http.Handler); ok {
+		s.mux.Handle("/metrics", s.authScope(ScopeRead, h))
+	}
+	return s
+}
+
+// tenant returns the state of tenant name, creating it if needed.
+func (s *Server) tenant(name string) *tenant {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tenants[name]
+	if !ok {
+		t = &tenant{bases: make(map[string]*serverBase)}
+		s.tenants[name] = t
+	}
+	return t
+}
+
+// registry returns the registry of tenant name, which is opened on first use if
+// WithTenantRegistries is set. It is nil if the tenant has no registry.
+func (s *Server) registry(name string) (*Registry, error) {
+	t := s.tenant(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.registry == nil && s.tenantRegistries != "" && name != "" {
+		reg, err := NewRegistry(filepath.Join(s.tenantRegistries, name))
+		if err != nil {
+			return nil, err
+		}
+		if s.cache != nil {
+			reg.onRemove(s.cache.InvalidateBase)
+		}
+		t.registry = reg
+	}
+	return t.registry, nil
+}
+
+// Register makes the base apk at path downloadable by name, an existing base with the
+// same name is replaced.
+func (s *Server) Register(name, path string) error {
+	return s.RegisterTenant("", name, path)
+}
+
+// RegisterTenant is Register for the bases of tenant.
+func (s *Server) RegisterTenant(tenant, name, path string) error {
+	if name == "" || strings.ContainsAny(name, "/@") {
+		return newErrf("invalid base name %q", name)
+	}
+	a, err := NewApk(path)
+	if err != nil {
+		return err
+	}
+	_, closer, err := a.open()
+	if err != nil {
+		return newErrf("Error occurred on parsing apk %s, %s", path, err)
+	}
+	closer.Close()
+	digest, modTime, err := fileDigest(path)
+	if err != nil {
+		return err
+	}
+	t := s.tenant(tenant)
+	s.mu.Lock()
+	old, ok := t.bases[name]
+	t.bases[name] = &serverBase{name: name, apk: a, digest: digest, modTime: modTime}
+	s.mu.Unlock()
+	if ok && s.cache != nil && old.digest != digest {
+		s.cache.InvalidateBase(old.digest)
+	}
+	return nil
+}
+
+// Unregister removes the base apk registered by name.
+func (s *Server) Unregister(name string) {
+	s.UnregisterTenant("", name)
+}
+
+// UnregisterTenant is Unregister for the bases of tenant.
+func (s *Server) UnregisterTenant(tenant, name string) {
+	t := s.tenant(tenant)
+	s.mu.Lock()
+	old, ok := t.bases[name]
+	delete(t.bases, name)
+	s.mu.Unlock()
+	if ok && s.cache != nil {
+		s.cache.InvalidateBase(old.digest)
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// base finds a registered base of tenant by name, then a base of its registry. The
+// returned base must be released.
+func (s *Server) base(tenant, name string) (*serverBase, bool) {
+	t := s.tenant(tenant)
+	s.mu.RLock()
+	b, ok := t.bases[name]
+	s.mu.RUnlock()
+	if !ok {
+		reg, err := s.registry(tenant)
+		if err != nil || reg == nil {
+			return nil, false
+		}
+		sb, err := reg.resolve(name)
+		return sb, err == nil
+	}
+	return b, ok
+}
+
+func (s *Server) allowed(tenant, channel string) bool {
+	t := s.tenant(tenant)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return t.channels == nil || t.channels[channel]
+}
+
+// channelLabel returns the metrics label of a channel served to tenant, channels are
+// only labeled by name if the tenant has an allowlist, so the labels are bounded.
+func (s *Server) channelLabel(tenant, channel string) string {
+	if channel == "" {
+		return ""
+	}
+	t := s.tenant(tenant)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if t.channels[channel] {
+		return channel
+	}
+	return metricsOtherChannel
+}
+
+func (s *Server) serveApk(w http.ResponseWriter, r *http.Request) {
+	var (
+		start         = time.Now()
+		sw            = &statusWriter{ResponseWriter: w}
+		tenantName    string
+		name, channel string
+		payload       map[string]string
+	)
+	if s.metrics != nil || s.accessLog != nil {
+		w = sw
+		defer func() {
+			if s.metrics != nil {
+				s.metrics.ObserveRequest(tenantName, name, s.channelLabel(tenantName, channel), sw.status, sw.bytes)
+			}
+			if s.accessLog != nil {
+				s.accessLog.write(&accessLogEntry{
+					Time: start, Remote: r.RemoteAddr, Method: r.Method, Path: r.URL.Path,
+					Base: name, Channel: channel, Status: sw.status, Bytes: sw.bytes,
+					Duration: float64(time.Since(start)) / float64(time.Millisecond), Token: payload,
+				})
+			}
+		}()
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/apk/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	extras, err := queryExtras(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tenant := TenantFromContext(r.Context())
+	if _, authed := KeyFromContext(r.Context()); s.secret != nil && !authed {
+		tenant = r.URL.Query().Get(signURLTenantParam)
+		if code, msg := checkSignedURL(s.secret, r, tenant, parts[0], parts[1], extras, time.Now()); code != 0 {
+			http.Error(w, msg, code)
+			return
+		}
+	}
+	if !s.allowed(tenant, parts[1]) {
+		http.Error(w, "unknown channel", http.StatusNotFound)
+		return
+	}
+	base, ok := s.base(tenant, parts[0])
+	if !ok {
+		http.Error(w, "unknown base apk", http.StatusNotFound)
+		return
+	}
+	defer base.release()
+	tenantName, name, channel = tenant, parts[0], parts[1]
+
+	var (
+		content readSeekCloser
+		info    = channelInfo{channel: channel, extras: extras}
+		built   bool
+	)
+	genStart := time.Now()
+	switch {
+	case s.payload != nil:
+		var v *VirtualApk
+		v, payload, err = base.apk.VirtualWithPayload(channel, extras, func() (map[string]string, error) {
+			return s.payload(r)
+		})
+		if err == nil {
+			content, info, built = v, v.info, true
+			if s.payloadHook != nil {
+				s.payloadHook(r, payload)
+			}
+		}
+	case s.cache != nil:
+		content, err = s.cache.open(base.digest, info, func() (*VirtualApk, error) {
+			built = true
+			return base.apk.Virtual(channel, extras)
+		})
+		if err == nil && s.metrics != nil {
+			s.metrics.ObserveCache(!built)
+		}
+	default:
+		content, err = base.apk.Virtual(channel, extras)
+		built = true
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+	if built && s.metrics != nil {
+		s.metrics.ObserveGeneration(time.Since(genStart))
+	}
+
+	filename := base.name + "-" + channel + ".apk"
+	h := w.Header()
+	h.Set("ETag", apkETag(base.digest, info))
+	h.Set("Content-Type", "application/vnd.android.package-archive")
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	if s.payload != nil {
+		// every download is a different apk, a range of it cannot resume another one
+		serveWhole(w, r, content)
+		return
+	}
+	http.ServeContent(w, r, filename, base.modTime, content)
+}
+
+// serveWhole serves content without ranges and conditional requests.
+func serveWhole(w http.ResponseWriter, r *http.Request, content io.ReadSeeker) {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = content.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h := w.Header()
+	h.Set("Accept-Ranges", "none")
+	h.Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		_, _ = io.Copy(w, content)
+	}
+}
+
+// queryExtras returns the first value of every query parameter except the ones of
+// signed URLs. The channel is in the path, a channel parameter is rejected.
+func queryExtras(r *http.Request) (map[string]string, error) {
+	var extras map[string]string
+	for k, vs := range r.URL.Query() {
+		if k == signURLExpiresParam || k == signURLSigParam || k == signURLTenantParam {
+			continue
+		}
+		if k == "channel" {
+			return nil, errors.New("channel is not an extra")
+		}
+		if extras == nil {
+			extras = make(map[string]string)
+		}
+		extras[k] = vs[0]
+	}
+	return extras, nil
+}
+
+// apkETag identifies the content of a channel apk, info bytes are deterministic.
+func apkETag(baseDigest string, info channelInfo) string {
+	h := sha256.New()
+	io.WriteString(h, baseDigest)
+	h.Write([]byte{0})
+	h.Write(info.Bytes())
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// fileDigest returns the hex sha256 and mtime of file.
+func fileDigest(file string) (string, time.Time, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", time.Time{}, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), fi.ModTime(), nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes err as {"error": "..."} with the status code of its kind.
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, ErrExists):
+		code = http.StatusConflict
+	case errors.Is(err, ErrInvalid):
+		code = http.StatusBadRequest
+	case errors.Is(err, ErrTooLarge):
+		code = http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrUnauthorized):
+		code = http.StatusUnauthorized
+	case errors.Is(err, ErrForbidden):
+		code = http.StatusForbidden
+	}
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
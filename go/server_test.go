@@ -0,0 +1,97 @@
+package _go
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestServer(t *testing.T, opts ...func(*Server)) (*Server, string) {
+	t.Helper()
+	dir := t.TempDir()
+	s := NewServer(opts...)
+	path := newTestApk(t, dir)
+	if err := s.Register("demo", path); err != nil {
+		t.Fatal(err)
+	}
+	return s, path
+}
+
+// testChannelApk generates the channel apk with PutChannelWithExtra for comparison.
+func testChannelApk(t *testing.T, base, channel string, extras map[string]string) []byte {
+	t.Helper()
+	a, err := NewApk(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := a.PutChannelWithExtra(channel, extras, filepath.Join(t.TempDir(), "out.apk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestServer_serveApk(t *testing.T) {
+	s, base := newTestServer(t, WithAllowedChannels("xiaomi", "huawei"))
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	want := testChannelApk(t, base, "xiaomi", map[string]string{"k": "v"})
+
+	resp, err := http.Get(srv.URL + "/apk/demo/xiaomi?k=v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(got) != string(want) {
+		t.Fatalf("GET got status %d, %d bytes, want %d bytes", resp.StatusCode, len(got), len(want))
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" || resp.ContentLength != int64(len(want)) {
+		t.Errorf("GET got ETag %q, Content-Length %d", etag, resp.ContentLength)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		header     map[string]string
+		wantStatus int
+		wantBody   string
+	}{
+		{"range", "/apk/demo/xiaomi?k=v", map[string]string{"Range": "bytes=10-99"}, http.StatusPartialContent, string(want[10:100])},
+		{"if-none-match", "/apk/demo/xiaomi?k=v", map[string]string{"If-None-Match": etag}, http.StatusNotModified, ""},
+		{"other extras", "/apk/demo/xiaomi?k=x", map[string]string{"If-None-Match": etag}, http.StatusOK, ""},
+		{"if-range mismatch", "/apk/demo/huawei?k=v", map[string]string{"Range": "bytes=0-9", "If-Range": etag}, http.StatusOK, ""},
+		{"unknown channel", "/apk/demo/oppo", nil, http.StatusNotFound, ""},
+		{"channel extra", "/apk/demo/xiaomi?channel=evil", nil, http.StatusBadRequest, ""},
+		{"unknown base", "/apk/nope/xiaomi", nil, http.StatusNotFound, ""},
+		{"bad path", "/apk/demo/xiaomi/x", nil, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+tt.path, nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Errorf("got body of %d bytes, want %d bytes", len(body), len(tt.wantBody))
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package _go
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+)
+
+// SignatureAlgorithm is the ID of a signature algorithm of APK Signature Scheme v2 and
+// later.
+// See https://android.googlesource.com/platform/tools/apksig/+/master/src/main/java/com/android/apksig/internal/apk/SignatureAlgorithm.java
+type SignatureAlgorithm uint32
+
+const (
+	SIGNATURE_RSA_PSS_WITH_SHA256               SignatureAlgorithm = 0x0101
+	SIGNATURE_RSA_PSS_WITH_SHA512               SignatureAlgorithm = 0x0102
+	SIGNATURE_RSA_PKCS1_V1_5_WITH_SHA256        SignatureAlgorithm = 0x0103
+	SIGNATURE_RSA_PKCS1_V1_5_WITH_SHA512        SignatureAlgorithm = 0x0104
+	SIGNATURE_ECDSA_WITH_SHA256                 SignatureAlgorithm = 0x0201
+	SIGNATURE_ECDSA_WITH_SHA512                 SignatureAlgorithm = 0x0202
+	SIGNATURE_DSA_WITH_SHA256                   SignatureAlgorithm = 0x0301
+	SIGNATURE_VERITY_RSA_PKCS1_V1_5_WITH_SHA256 SignatureAlgorithm = 0x0421
+	SIGNATURE_VERITY_ECDSA_WITH_SHA256          SignatureAlgorithm = 0x0423
+	SIGNATURE_VERITY_DSA_WITH_SHA256            SignatureAlgorithm = 0x0425
+)
+
+var signatureAlgorithmNames = map[SignatureAlgorithm]string{
+	SIGNATURE_RSA_PSS_WITH_SHA256:               "RSA-PSS with SHA-256",
+	SIGNATURE_RSA_PSS_WITH_SHA512:               "RSA-PSS with SHA-512",
+	SIGNATURE_RSA_PKCS1_V1_5_WITH_SHA256:        "RSA PKCS#1 v1.5 with SHA-256",
+	SIGNATURE_RSA_PKCS1_V1_5_WITH_SHA512:        "RSA PKCS#1 v1.5 with SHA-512",
+	SIGNATURE_ECDSA_WITH_SHA256:                 "ECDSA with SHA-256",
+	SIGNATURE_ECDSA_WITH_SHA512:                 "ECDSA with SHA-512",
+	SIGNATURE_DSA_WITH_SHA256:                   "DSA with SHA-256",
+	SIGNATURE_VERITY_RSA_PKCS1_V1_5_WITH_SHA256: "verity RSA PKCS#1 v1.5 with SHA-256",
+	SIGNATURE_VERITY_ECDSA_WITH_SHA256:          "verity ECDSA with SHA-256",
+	SIGNATURE_VERITY_DSA_WITH_SHA256:            "verity DSA with SHA-256",
+}
+
+func (a SignatureAlgorithm) String() string {
+	if name, ok := signatureAlgorithmNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown signature algorithm 0x%04x", uint32(a))
+}
+
+func (a SignatureAlgorithm) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// contentDigestAlgorithm is how the signed contents of an apk are digested.
+type contentDigestAlgorithm int
+
+const (
+	contentDigestChunkedSHA256 contentDigestAlgorithm = iota + 1
+	contentDigestChunkedSHA512
+	contentDigestVeritySHA256
+)
+
+// contentDigest returns the content digest algorithm of a, 0 for unknown algorithms.
+func (a SignatureAlgorithm) contentDigest() contentDigestAlgorithm {
+	switch a {
+	case SIGNATURE_RSA_PSS_WITH_SHA256, SIGNATURE_RSA_PKCS1_V1_5_WITH_SHA256,
+		SIGNATURE_ECDSA_WITH_SHA256, SIGNATURE_DSA_WITH_SHA256:
+		return contentDigestChunkedSHA256
+	case SIGNATURE_RSA_PSS_WITH_SHA512, SIGNATURE_RSA_PKCS1_V1_5_WITH_SHA512, SIGNATURE_ECDSA_WITH_SHA512:
+		return contentDigestChunkedSHA512
+	case SIGNATURE_VERITY_RSA_PKCS1_V1_5_WITH_SHA256, SIGNATURE_VERITY_ECDSA_WITH_SHA256,
+		SIGNATURE_VERITY_DSA_WITH_SHA256:
+		return contentDigestVeritySHA256
+	}
+	return 0
+}
+
+// supported reports whether the signature and content digest of a can be verified.
+func (a SignatureAlgorithm) supported() bool {
+	d := a.contentDigest()
+	return d == contentDigestChunkedSHA256 || d == contentDigestChunkedSHA512
+}
+
+// stronger reports whether a is preferred over b, the one with the stronger content
+// digest is, as the platform does.
+func (a SignatureAlgorithm) stronger(b SignatureAlgorithm) bool {
+	rank := func(d contentDigestAlgorithm) int {
+		switch d {
+		case contentDigestChunkedSHA512:
+			return 3
+		case contentDigestChunkedSHA256:
+			return 2
+		case contentDigestVeritySHA256:
+			return 1
+		}
+		return 0
+	}
+	return rank(a.contentDigest()) > rank(b.contentDigest())
+}
+
+func (a SignatureAlgorithm) hash() crypto.Hash {
+	if a.contentDigest() == contentDigestChunkedSHA512 {
+		return crypto.SHA512
+	}
+	return crypto.SHA256
+}
+
+// verify checks sig of data made by the key of pub with a.
+func (a SignatureAlgorithm) verify(pub crypto.PublicKey, data, sig []byte) error {
+	h := a.hash().New()
+	h.Write(data)
+	hashed := h.Sum(nil)
+	switch a {
+	case SIGNATURE_RSA_PSS_WITH_SHA256, SIGNATURE_RSA_PSS_WITH_SHA512:
+		k, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s signature with %T", a, pub)
+		}
+		return rsa.VerifyPSS(k, a.hash(), hashed, sig, &rsa.PSSOptions{SaltLength: a.hash().Size(), Hash: a.hash()})
+	case SIGNATURE_RSA_PKCS1_V1_5_WITH_SHA256, SIGNATURE_RSA_PKCS1_V1_5_WITH_SHA512,
+		SIGNATURE_VERITY_RSA_PKCS1_V1_5_WITH_SHA256:
+		k, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s signature with %T", a, pub)
+		}
+		return rsa.VerifyPKCS1v15(k, a.hash(), hashed, sig)
+	case SIGNATURE_ECDSA_WITH_SHA256, SIGNATURE_ECDSA_WITH_SHA512, SIGNATURE_VERITY_ECDSA_WITH_SHA256:
+		k, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s signature with %T", a, pub)
+		}
+		if !ecdsa.VerifyASN1(k, hashed, sig) {
+			return errors.New("ECDSA verification error")
+		}
+		return nil
+	case SIGNATURE_DSA_WITH_SHA256, SIGNATURE_VERITY_DSA_WITH_SHA256:
+		k, ok := pub.(*dsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s signature with %T", a, pub)
+		}
+		var rs struct{ R, S *big.Int }
+		if rest, err := asn1.Unmarshal(sig, &rs); err != nil || len(rest) != 0 {
+			return errors.New("malformed DSA signature")
+		}
+		// the hash is truncated to the size of the subgroup, see FIPS 186-3 section 4.6
+		if n := (k.Q.BitLen() + 7) / 8; len(hashed) > n {
+			hashed = hashed[:n]
+		}
+		if !dsa.Verify(k, hashed, rs.R, rs.S) {
+			return errors.New("DSA verification error")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported %s", a)
+}
+
+// contentDigestChunkSize is the size of the chunks digested by the chunked content
+// digest algorithms.
+const contentDigestChunkSize = 1 << 20
+
+func (d contentDigestAlgorithm) newHash() hash.Hash {
+	if d == contentDigestChunkedSHA512 {
+		return sha512.New()
+	}
+	return sha256.New()
+}
+
+// computeContentDigests computes the chunked content digests of the apk in z, which
+// cover the bytes before the signing block, the central directory and the EOCD whose
+// central directory offset is the offset of the signing block:
+//
+//	chunk digest = H(0xa5 || uint32 chunk length || chunk), in 1 MB chunks of every section
+//	digest       = H(0x5a || uint32 chunk count || chunk digests...)
+//
+// See https://source.android.com/docs/security/features/apksigning/v2#integrity-protected-contents
+func computeContentDigests(z *zipSections, algs ...contentDigestAlgorithm) (map[contentDigestAlgorithm][]byte, error) {
+	eocd := makeEocd(z.eocd, uint32(z.signingBlockOffset))
+	sections := []*io.SectionReader{
+		z.beforeSigningBlock(),
+		io.NewSectionReader(bytes.NewReader(z.centraDir), 0, int64(len(z.centraDir))),
+		io.NewSectionReader(bytes.NewReader(eocd), 0, int64(len(eocd))),
+	}
+	var chunks int64
+	for _, s := range sections {
+		chunks += (s.Size() + contentDigestChunkSize - 1) / contentDigestChunkSize
+	}
+	if chunks > 1<<31 {
+		return nil, fmt.Errorf("too many chunks: %d", chunks)
+	}
+	header := make([]byte, 5)
+	tops := make([]hash.Hash, len(algs))
+	for i, alg := range algs {
+		if alg != contentDigestChunkedSHA256 && alg != contentDigestChunkedSHA512 {
+			return nil, fmt.Errorf("unsupported content digest algorithm %d", alg)
+		}
+		tops[i] = alg.newHash()
+		header[0] = 0x5a
+		putUint32(uint32(chunks), header, 1)
+		tops[i].Write(header)
+	}
+
+	buf := make([]byte, contentDigestChunkSize)
+	for _, s := range sections {
+		for off := int64(0); off < s.Size(); off += contentDigestChunkSize {
+			n := s.Size() - off
+			if n > contentDigestChunkSize {
+				n = contentDigestChunkSize
+			}
+			chunk := buf[:n]
+			if err := readFullAt(s, chunk, off); err != nil {
+				return nil, err
+			}
+			header[0] = 0xa5
+			putUint32(uint32(n), header, 1)
+			for i, alg := range algs {
+				h := alg.newHash()
+				h.Write(header)
+				h.Write(chunk)
+				tops[i].Write(h.Sum(nil))
+			}
+		}
+	}
+	digests := make(map[contentDigestAlgorithm][]byte, len(algs))
+	for i, alg := range algs {
+		digests[alg] = tops[i].Sum(nil)
+	}
+	return digests, nil
+}
@@ -0,0 +1,288 @@
+package _go
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+)
+
+type apkSigner struct {
+	key   crypto.PrivateKey
+	certs []*x509.Certificate
+	alg   SignatureAlgorithm
+	v3    bool
+	// jarHash is the digest algorithm of JAR signatures, see setJarMinSdkVersion.
+	jarHash crypto.Hash
+}
+
+// WithV3Signing adds an APK Signature Scheme v3 block for Android 9 and later besides
+// the v2 one, which then protects the v3 block from being stripped.
+func WithV3Signing() func(*apkSigner) {
+	return func(s *apkSigner) {
+		s.v3 = true
+	}
+}
+
+// SignApk signs the apk at input into output, see Sign.
+func SignApk(input, output string, key crypto.PrivateKey, certs []*x509.Certificate, opts ...func(*apkSigner)) error {
+	if sameFile(input, output) {
+		return errors.New("output must not be the input apk")
+	}
+	_, err := openFile(input, func(in *os.File) (interface{}, error) {
+		size, err := fileSize(in)
+		if err != nil {
+			return nil, err
+		}
+		out, err := os.Create(output)
+		if err != nil {
+			return nil, err
+		}
+		err = Sign(in, size, out, key, certs, opts...)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(output)
+		}
+		return nil, err
+	})
+	return err
+}
+
+// Sign writes the apk in r signed with APK Signature Scheme v2, and v3 with
+// WithV3Signing, by key to w. certs is the certificate chain of key, the first one is
+// of key. The apk may be unsigned or JAR signed, and must be aligned already, see Align,
+// since the entries are signed as they are. Existing v2 and v3 blocks are replaced, other
+// pairs of the APK Signing Block such as the channel are kept. RSA keys sign with
+// PKCS#1 v1.5, EC keys with ECDSA and DSA keys with DSA, with SHA-512 for RSA keys
+// over 3072 bits and EC keys over 256 bits and SHA-256 otherwise, as apksigner does.
+func Sign(r io.ReaderAt, size int64, w io.Writer, key crypto.PrivateKey, certs []*x509.Certificate, opts ...func(*apkSigner)) error {
+	s, err := newApkSigner(key, certs)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	z, err := newUnsignedZipSectionsAt(r, size)
+	if err != nil {
+		return err
+	}
+	signed, err := s.transform(&z)
+	if err != nil {
+		return err
+	}
+	sr, n := signed.readerAt()
+	_, err = io.Copy(w, io.NewSectionReader(sr, 0, n))
+	return err
+}
+
+func newApkSigner(key crypto.PrivateKey, certs []*x509.Certificate) (*apkSigner, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate")
+	}
+	pub, err := publicKeyOf(key)
+	if err != nil {
+		return nil, err
+	}
+	if !equalPublicKeys(pub, certs[0].PublicKey) {
+		return nil, errors.New("the first certificate is not of the private key")
+	}
+	s := &apkSigner{key: key, certs: certs}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		s.alg = SIGNATURE_RSA_PKCS1_V1_5_WITH_SHA256
+		if k.N.BitLen() > 3072 {
+			s.alg = SIGNATURE_RSA_PKCS1_V1_5_WITH_SHA512
+		}
+	case *ecdsa.PublicKey:
+		s.alg = SIGNATURE_ECDSA_WITH_SHA256
+		if k.Curve.Params().BitSize > 256 {
+			s.alg = SIGNATURE_ECDSA_WITH_SHA512
+		}
+	case *dsa.PublicKey:
+		s.alg = SIGNATURE_DSA_WITH_SHA256
+	default:
+		return nil, fmt.Errorf("unsupported public key %T", pub)
+	}
+	return s, nil
+}
+
+// newUnsignedZipSectionsAt is newZipSectionsAt that also reads apks without an APK
+// Signing Block, whose block is empty at the central directory offset then.
+func newUnsignedZipSectionsAt(in io.ReaderAt, size int64) (z zipSections, err error) {
+	eocd, eocdOffset, err := findEndOfCentralDirectoryRecord(in, size)
+	if err != nil {
+		return
+	}
+	if eocdOffset <= 0 {
+		return z, errors.New("Cannot find EOCD record, maybe a broken zip file.")
+	}
+	centralDirOffset := getEocdCentralDirectoryOffset(eocd)
+	centralDirSize := getEocdCentralDirectorySize(eocd)
+	if int64(centralDirOffset)+int64(centralDirSize) != eocdOffset {
+		return z, fmt.Errorf("central directory ends at %d, but EOCD starts at %d",
+			int64(centralDirOffset)+int64(centralDirSize), eocdOffset)
+	}
+	if centralDirOffset >= 16 {
+		magic := make([]byte, 16)
+		if err = readFullAt(in, magic, int64(centralDirOffset)-16); err != nil {
+			return
+		}
+		if getUint64(magic, 0) == _APK_SIG_BLOCK_MAGIC_LO && getUint64(magic, 8) == _APK_SIG_BLOCK_MAGIC_HI {
+			return newZipSectionsAt(in, size)
+		}
+	}
+	z = zipSections{
+		source:             in,
+		signingBlockOffset: int64(centralDirOffset),
+		centraDir:          make([]byte, centralDirSize),
+		centralDirOffset:   int64(centralDirOffset),
+		eocd:               eocd,
+		eocdOffset:         eocdOffset,
+	}
+	err = readFullAt(in, z.centraDir, z.centralDirOffset)
+	return
+}
+
+// transform replaces the signature scheme blocks of z with new ones.
+func (s *apkSigner) transform(z *zipSections) (*zipSections, error) {
+	digests, err := computeContentDigests(z, s.alg.contentDigest())
+	if err != nil {
+		return nil, err
+	}
+	digest := digests[s.alg.contentDigest()]
+
+	var v2Attrs [][]byte
+	if s.v3 {
+		v2Attrs = append(v2Attrs, append(uint32s(STRIPPING_PROTECTION_ATTR_ID), uint32s(3)...))
+	}
+	v2, err := s.signer(digest, false, v2Attrs)
+	if err != nil {
+		return nil, err
+	}
+	pairs := appendIdValue(nil, APK_SIGNATURE_SCHEME_V2_BLOCK_ID, lengthPrefixed(lengthPrefixed(v2)))
+	if s.v3 {
+		v3, err := s.signer(digest, true, nil)
+		if err != nil {
+			return nil, err
+		}
+		pairs = appendIdValue(pairs, APK_SIGNATURE_SCHEME_V3_BLOCK_ID, lengthPrefixed(lengthPrefixed(v3)))
+	}
+	if z.signingBlock != nil {
+		err := forEachIdValue(z.signingBlock, func(id uint32, value []byte) bool {
+			switch id {
+			case APK_SIGNATURE_SCHEME_V2_BLOCK_ID, APK_SIGNATURE_SCHEME_V3_BLOCK_ID,
+				APK_SIGNATURE_SCHEME_V31_BLOCK_ID, VERITY_PADDING_BLOCK_ID:
+			default:
+				pairs = appendIdValue(pairs, id, value)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	block := makeSigningBlock(pairs)
+	newzip := new(zipSections)
+	newzip.source = z.source
+	newzip.signingBlock = block
+	newzip.signingBlockOffset = z.signingBlockOffset
+	newzip.centraDir = z.centraDir
+	newzip.centralDirOffset = z.signingBlockOffset + int64(len(block))
+	newzip.eocdOffset = newzip.centralDirOffset + int64(len(z.centraDir))
+	newzip.eocd = makeEocd(z.eocd, uint32(newzip.centralDirOffset))
+	return newzip, nil
+}
+
+// signer encodes a v2 or v3 signer:
+//
+//	length-prefixed signed data:
+//	    length-prefixed sequence of length-prefixed digests: uint32 algorithm, length-prefixed digest
+//	    length-prefixed sequence of length-prefixed X.509 certificates
+//	    (v3) uint32 min SDK version, uint32 max SDK version
+//	    length-prefixed sequence of length-prefixed additional attributes
+//	(v3) uint32 min SDK version, uint32 max SDK version
+//	length-prefixed sequence of length-prefixed signatures: uint32 algorithm, length-prefixed signature
+//	length-prefixed SubjectPublicKeyInfo
+func (s *apkSigner) signer(digest []byte, v3 bool, attrs [][]byte) ([]byte, error) {
+	var sdks []byte
+	if v3 {
+		sdks = uint32s(SDK_VERSION_V3, math.MaxInt32)
+	}
+	certs := make([][]byte, len(s.certs))
+	for i, c := range s.certs {
+		certs[i] = c.Raw
+	}
+	signedData := lengthPrefixed(lengthPrefixed(append(uint32s(uint32(s.alg)), lengthPrefixed(digest)...)))
+	signedData = append(signedData, lengthPrefixed(lengthPrefixed(certs...))...)
+	signedData = append(signedData, sdks...)
+	signedData = append(signedData, lengthPrefixed(lengthPrefixed(attrs...))...)
+	sig, err := s.sign(signedData)
+	if err != nil {
+		return nil, err
+	}
+	b := append(lengthPrefixed(signedData), sdks...)
+	b = append(b, lengthPrefixed(lengthPrefixed(append(uint32s(uint32(s.alg)), lengthPrefixed(sig)...)))...)
+	return append(b, lengthPrefixed(s.certs[0].RawSubjectPublicKeyInfo)...), nil
+}
+
+// sign signs data with the algorithm of the signer, the reverse of
+// SignatureAlgorithm.verify.
+func (s *apkSigner) sign(data []byte) ([]byte, error) {
+	return s.signHash(data, s.alg.hash())
+}
+
+// signHash signs data digested with hash by the key of the signer.
+func (s *apkSigner) signHash(data []byte, hash crypto.Hash) ([]byte, error) {
+	h := hash.New()
+	h.Write(data)
+	hashed := h.Sum(nil)
+	switch k := s.key.(type) {
+	case *dsa.PrivateKey:
+		if n := (k.Q.BitLen() + 7) / 8; len(hashed) > n {
+			hashed = hashed[:n]
+		}
+		r, ss, err := dsa.Sign(rand.Reader, k, hashed)
+		if err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(struct{ R, S *big.Int }{r, ss})
+	case crypto.Signer:
+		// RSA keys sign with PKCS#1 v1.5 and EC keys to ASN.1 with a crypto.Hash
+		return k.Sign(rand.Reader, hashed, hash)
+	}
+	return nil, fmt.Errorf("unsupported private key %T", s.key)
+}
+
+// lengthPrefixed concatenates values with their uint32 little-endian lengths.
+func lengthPrefixed(values ...[]byte) []byte {
+	var b bytes.Buffer
+	n := make([]byte, 4)
+	for _, v := range values {
+		putUint32(uint32(len(v)), n, 0)
+		b.Write(n)
+		b.Write(v)
+	}
+	return b.Bytes()
+}
+
+func uint32s(vs ...uint32) []byte {
+	b := make([]byte, 4*len(vs))
+	for i, v := range vs {
+		putUint32(v, b, 4*i)
+	}
+	return b
+}
@@ -0,0 +1,212 @@
+package _go
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testDSAKey generates a small DSA key.
+func testDSAKey(t *testing.T) *dsa.PrivateKey {
+	t.Helper()
+	k := new(dsa.PrivateKey)
+	if err := dsa.GenerateParameters(&k.Parameters, rand.Reader, dsa.L1024N160); err != nil {
+		t.Fatal(err)
+	}
+	if err := dsa.GenerateKey(k, rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// testCertOf makes a self-signed certificate of key.
+func testCertOf(t *testing.T, pub crypto.PublicKey, key crypto.Signer) *x509.Certificate {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "walle signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, pub, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func testUnsignedZip(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"AndroidManifest.xml", "classes.dex"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(bytes.Repeat([]byte(name), 64)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Go cannot make certificates of DSA keys, testdata/dsa.* are made with
+	// openssl gendsa and openssl req -x509
+	dsaKey, dsaCerts, err := LoadSigningKey("testdata/dsa.key", "testdata/dsa.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaCert := testCertOf(t, rsaKey.Public(), rsaKey)
+
+	dir := t.TempDir()
+	channel := filepath.Join(dir, "channel.apk")
+	a, err := NewApk(newTestApk(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.PutChannel("xiaomi", channel); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		apk     []byte
+		key     crypto.PrivateKey
+		cert    *x509.Certificate
+		v3      bool
+		wantAlg SignatureAlgorithm
+	}{
+		{"RSA", testUnsignedZip(t), rsaKey, rsaCert, false, SIGNATURE_RSA_PKCS1_V1_5_WITH_SHA256},
+		{"RSA v3", testUnsignedZip(t), rsaKey, rsaCert, true, SIGNATURE_RSA_PKCS1_V1_5_WITH_SHA256},
+		{"ECDSA P-384", testUnsignedZip(t), ecKey, testCertOf(t, ecKey.Public(), ecKey), true, SIGNATURE_ECDSA_WITH_SHA512},
+		{"DSA", testUnsignedZip(t), dsaKey, dsaCerts[0], true, SIGNATURE_DSA_WITH_SHA256},
+		{"v1 signed", testJarSign(t, testJar{key: rsaKey, cert: rsaCert.Raw, signedSchemes: "2"}), rsaKey, rsaCert, false,
+			SIGNATURE_RSA_PKCS1_V1_5_WITH_SHA256},
+		{"channel", readTestApk(t, channel), ecKey, testCertOf(t, ecKey.Public(), ecKey), false, SIGNATURE_ECDSA_WITH_SHA512},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := filepath.Join(t.TempDir(), "in.apk")
+			if err := os.WriteFile(input, tt.apk, 0644); err != nil {
+				t.Fatal(err)
+			}
+			var opts []func(*apkSigner)
+			if tt.v3 {
+				opts = append(opts, WithV3Signing())
+			}
+			output := filepath.Join(filepath.Dir(input), "out.apk")
+			if err := SignApk(input, output, tt.key, []*x509.Certificate{tt.cert}, opts...); err != nil {
+				t.Fatal(err)
+			}
+			res, err := VerifyFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !res.Verified {
+				t.Fatalf("signed apk does not verify: %s", res.firstError())
+			}
+			if s := res.V2.Signers[0]; s.Algorithm != tt.wantAlg || !s.Certificates[0].Equal(tt.cert) {
+				t.Errorf("got v2 signer %s %s", s.Algorithm, s.Certificate.Subject)
+			}
+			if (res.V3 != nil) != tt.v3 {
+				t.Errorf("got v3 %+v", res.V3)
+			}
+			if tt.name == "channel" {
+				if a, err := NewApk(output); err != nil || a.Channel() != "xiaomi" {
+					t.Errorf("channel is lost: %v", err)
+				}
+			}
+		})
+	}
+
+	if err := Sign(bytes.NewReader(tests[0].apk), int64(len(tests[0].apk)), &bytes.Buffer{}, ecKey, []*x509.Certificate{rsaCert}); err == nil {
+		t.Errorf("signs with certificate of other key")
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dsaKey := testDSAKey(t)
+	must := func(der []byte, err error) []byte {
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+	pemOf := func(typ string, der []byte) []byte {
+		return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	}
+	params, x := must(asn1.Marshal(dsaKey.Parameters)), must(asn1.Marshal(dsaKey.X))
+
+	tests := []struct {
+		name string
+		b    []byte
+		want crypto.PublicKey
+	}{
+		{"PKCS#8 DER", must(x509.MarshalPKCS8PrivateKey(rsaKey)), rsaKey.Public()},
+		{"PKCS#8 PEM", pemOf("PRIVATE KEY", must(x509.MarshalPKCS8PrivateKey(ecKey))), ecKey.Public()},
+		{"PKCS#1", pemOf("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)), rsaKey.Public()},
+		{"SEC 1", pemOf("EC PRIVATE KEY", must(x509.MarshalECPrivateKey(ecKey))), ecKey.Public()},
+		{"PKCS#8 DSA", pemOf("PRIVATE KEY", must(asn1.Marshal(pkcs8{
+			Algorithm:  pkix.AlgorithmIdentifier{Algorithm: oidDSA, Parameters: asn1.RawValue{FullBytes: params}},
+			PrivateKey: x,
+		}))), &dsaKey.PublicKey},
+		{"OpenSSL DSA", pemOf("DSA PRIVATE KEY", must(asn1.Marshal(struct {
+			Version       int
+			P, Q, G, Y, X *big.Int
+		}{0, dsaKey.P, dsaKey.Q, dsaKey.G, dsaKey.Y, dsaKey.X}))), &dsaKey.PublicKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParsePrivateKey(tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pub, err := publicKeyOf(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalPublicKeys(pub, tt.want) {
+				t.Errorf("got key %T of other public key", key)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package _go
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+)
+
+// Digest and signature algorithms of PKCS#7 signer infos.
+var (
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidDSAWithSHA256   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 3, 2}
+	oidSHA1            = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+)
+
+// Android API levels that verify JAR signatures with SHA-256, SHA-1 is used below.
+const (
+	jarSHA256MinSdkVersion    = 18
+	jarDSASHA256MinSdkVersion = 21
+)
+
+// jarDigestNames are the names of the digest algorithms in JAR manifests.
+var jarDigestNames = map[crypto.Hash]string{
+	crypto.SHA1:   "SHA1",
+	crypto.SHA256: "SHA-256",
+}
+
+// jarSignerName is the name of the signature file and block of JAR signatures.
+const jarSignerName = "META-INF/CERT"
+
+// jarMaxLineLength is the max length of manifest lines without the line break.
+const jarMaxLineLength = 72
+
+// writeJarAttribute writes the attribute line of a manifest or signature file, split
+// into continuation lines that start with a space.
+func writeJarAttribute(b *bytes.Buffer, name, value string) {
+	line := name + ": " + value
+	for n := jarMaxLineLength; len(line) > n; n = jarMaxLineLength - 1 {
+		b.WriteString(line[:n])
+		b.WriteString("\r\n ")
+		line = line[n:]
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+// setJarMinSdkVersion picks the digest algorithm of JAR signatures of apks that run on
+// minSdkVersion and later as apksigner does: SHA-256 from Android 4.3, or 5.0 for DSA
+// keys, and SHA-1 below. EC keys are not supported below Android 4.3.
+func (s *apkSigner) setJarMinSdkVersion(minSdkVersion int) error {
+	s.jarHash = crypto.SHA256
+	switch s.key.(type) {
+	case *ecdsa.PrivateKey:
+		if minSdkVersion < jarSHA256MinSdkVersion {
+			return errors.New("JAR signatures with EC keys require minSdkVersion 18 or later")
+		}
+	case *dsa.PrivateKey:
+		if minSdkVersion < jarDSASHA256MinSdkVersion {
+			s.jarHash = crypto.SHA1
+		}
+	default:
+		if minSdkVersion < jarSHA256MinSdkVersion {
+			s.jarHash = crypto.SHA1
+		}
+	}
+	return nil
+}
+
+// jarDigest returns the digest algorithm of JAR signatures, SHA-256 by default.
+func (s *apkSigner) jarDigest() crypto.Hash {
+	if s.jarHash == 0 {
+		return crypto.SHA256
+	}
+	return s.jarHash
+}
+
+// signJar returns the files of the JAR signature of entries, whose data are the
+// digests of the entries by jarDigest, as apksigner does: the manifest with the digests
+// of entries, the signature file with the digests of the manifest and its sections,
+// which tells the apk is also signed with APK Signature Scheme v2 and v3, and the
+// PKCS#7 signature of the signature file by s.
+func (s *apkSigner) signJar(entries []*zipFile) ([]*zipFile, error) {
+	hash := s.jarDigest()
+	digestAttr := jarDigestNames[hash] + "-Digest"
+	sum := func(b []byte) string {
+		h := hash.New()
+		h.Write(b)
+		return base64.StdEncoding.EncodeToString(h.Sum(nil))
+	}
+	var manifest, sf bytes.Buffer
+	writeJarAttribute(&manifest, "Manifest-Version", "1.0")
+	writeJarAttribute(&manifest, "Created-By", "walle")
+	manifest.WriteString("\r\n")
+	var sections bytes.Buffer
+	for _, e := range entries {
+		start := manifest.Len()
+		writeJarAttribute(&manifest, "Name", e.name)
+		writeJarAttribute(&manifest, digestAttr, base64.StdEncoding.EncodeToString(e.data))
+		manifest.WriteString("\r\n")
+		writeJarAttribute(&sections, "Name", e.name)
+		writeJarAttribute(&sections, digestAttr, sum(manifest.Bytes()[start:]))
+		sections.WriteString("\r\n")
+	}
+
+	writeJarAttribute(&sf, "Signature-Version", "1.0")
+	writeJarAttribute(&sf, "Created-By", "walle")
+	writeJarAttribute(&sf, digestAttr+"-Manifest", sum(manifest.Bytes()))
+	schemes := "2"
+	if s.v3 {
+		schemes = "2, 3"
+	}
+	writeJarAttribute(&sf, jarSignedSchemesAttr, schemes)
+	sf.WriteString("\r\n")
+	sf.Write(sections.Bytes())
+
+	block, ext, err := s.signPKCS7(sf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return []*zipFile{
+		{name: jarManifest, data: manifest.Bytes()},
+		{name: jarSignerName + ".SF", data: sf.Bytes()},
+		{name: jarSignerName + ext, data: block},
+	}, nil
+}
+
+// signPKCS7 returns the detached PKCS#7 signature of content with jarDigest and without
+// authenticated attributes, and the extension of its file by the key algorithm.
+func (s *apkSigner) signPKCS7(content []byte) ([]byte, string, error) {
+	hash := s.jarDigest()
+	sig, err := s.signHash(content, hash)
+	if err != nil {
+		return nil, "", err
+	}
+	sha1 := hash == crypto.SHA1
+	alg, ext := pkcs7AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}, ".RSA"
+	switch s.key.(type) {
+	case *ecdsa.PrivateKey:
+		// SHA-1 is never used with EC keys, see setJarMinSdkVersion
+		alg, ext = pkcs7AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}, ".EC"
+	case *dsa.PrivateKey:
+		alg, ext = pkcs7AlgorithmIdentifier{Algorithm: oidDSAWithSHA256}, ".DSA"
+		if sha1 {
+			alg.Algorithm = oidDSA
+		}
+	}
+	digestAlgID := pkcs7AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	if sha1 {
+		digestAlgID.Algorithm = oidSHA1
+	}
+	digestAlg, err := asn1.Marshal(digestAlgID)
+	if err != nil {
+		return nil, "", err
+	}
+	var certs []byte
+	for _, c := range s.certs {
+		certs = append(certs, c.Raw...)
+	}
+	cert := s.certs[0]
+	sd, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: []asn1.RawValue{{FullBytes: digestAlg}},
+		ContentInfo:      pkcs7ContentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos: []pkcs7SignerInfo{{
+			Version:                   1,
+			IssuerAndSerialNumber:     pkcs7IssuerAndSerialNumber{asn1.RawValue{FullBytes: cert.RawIssuer}, cert.SerialNumber},
+			DigestAlgorithm:           digestAlgID,
+			DigestEncryptionAlgorithm: alg,
+			EncryptedDigest:           sig,
+		}},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	b, err := asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+	return b, ext, err
+}
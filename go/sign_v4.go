@@ -0,0 +1,221 @@
+package _go
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"io"
+	"os"
+)
+
+// APK Signature Scheme v4 constants, see
+// https://android.googlesource.com/platform/tools/apksig/+/master/src/main/java/com/android/apksig/internal/apk/v4/V4Signature.java
+const (
+	v4SignatureVersion       = 2
+	v4HashingAlgorithmSHA256 = 1
+	v4Log2BlockSize          = 12
+	v4BlockSize              = 1 << v4Log2BlockSize
+	v4SignatureFileSuffix    = ".idsig"
+	v4MerkleTreeDigestSize   = sha256.Size
+)
+
+// v4Signer signs the apks of a v2 or v3 signer with APK Signature Scheme v4, whose
+// signature covers the whole apk with a Merkle tree and is written next to it since
+// it cannot be in the apk.
+type v4Signer struct {
+	*apkSigner
+	// apkDigest is the content digest of the v2 or v3 signer of key.
+	apkDigest []byte
+}
+
+// SignApkV4 writes the APK Signature Scheme v4 signature of the apk at input to
+// input+".idsig", as apksigner does, for incremental installs with adb install
+// --incremental. key and certs must be of a v2 or v3 signer of the apk, and the apk
+// must not change afterwards since the signature covers all of its bytes, including
+// the channel in the APK Signing Block.
+func SignApkV4(input string, key crypto.PrivateKey, certs []*x509.Certificate) error {
+	_, err := openFile(input, func(in *os.File) (interface{}, error) {
+		size, err := fileSize(in)
+		if err != nil {
+			return nil, err
+		}
+		z, err := newZipSectionsAt(in, size)
+		if err != nil {
+			return nil, err
+		}
+		s, err := newV4Signer(key, certs, z.signingBlock)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.writeTo(in, size, input+v4SignatureFileSuffix)
+	})
+	return err
+}
+
+// newV4Signer returns the v4 signer of key, whose certificate must be of a signer of
+// signingBlock.
+func newV4Signer(key crypto.PrivateKey, certs []*x509.Certificate, signingBlock []byte) (*v4Signer, error) {
+	s, err := newApkSigner(key, certs)
+	if err != nil {
+		return nil, err
+	}
+	blocks := make(map[uint32][]byte)
+	err = forEachIdValue(signingBlock, func(id uint32, value []byte) bool {
+		blocks[id] = value
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	// v3.1 and v3 signers are preferred as apksigner does, their content digests are
+	// the same as v2 ones anyway
+	for _, id := range []uint32{APK_SIGNATURE_SCHEME_V31_BLOCK_ID, APK_SIGNATURE_SCHEME_V3_BLOCK_ID, APK_SIGNATURE_SCHEME_V2_BLOCK_ID} {
+		value, ok := blocks[id]
+		if !ok {
+			continue
+		}
+		signers, _, err := readLengthPrefixedSequence(value)
+		if err != nil {
+			return nil, newErrf("malformed signing block 0x%x: %s", id, err)
+		}
+		for _, b := range signers {
+			signer, err := parseSigner(b, id != APK_SIGNATURE_SCHEME_V2_BLOCK_ID)
+			if err != nil {
+				return nil, newErrf("malformed signer of signing block 0x%x: %s", id, err)
+			}
+			if !bytes.Equal(signer.publicKey, certs[0].RawSubjectPublicKeyInfo) {
+				continue
+			}
+			if digest := bestV4Digest(signer.digests); digest != nil {
+				return &v4Signer{apkSigner: s, apkDigest: digest}, nil
+			}
+		}
+	}
+	return nil, errors.New("the key is not of a v2 or v3 signer of the apk")
+}
+
+// bestV4Digest returns the content digest a v4 signature refers to, in the order of
+// ApkSigningBlockUtils.pickBestDigestForV4 of the platform.
+func bestV4Digest(digests []algorithmValue) []byte {
+	for _, alg := range []contentDigestAlgorithm{contentDigestChunkedSHA512, contentDigestVeritySHA256, contentDigestChunkedSHA256} {
+		for _, d := range digests {
+			if d.algorithm.contentDigest() == alg {
+				return d.value
+			}
+		}
+	}
+	return nil
+}
+
+// signFile writes the v4 signature of the apk at path to path+".idsig".
+func (s *v4Signer) signFile(path string) error {
+	_, err := openFile(path, func(f *os.File) (interface{}, error) {
+		size, err := fileSize(f)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.writeTo(f, size, path+v4SignatureFileSuffix)
+	})
+	return err
+}
+
+// writeTo writes the v4 signature of the apk in r to output.
+func (s *v4Signer) writeTo(r io.ReaderAt, size int64, output string) error {
+	b, err := s.signature(r, size)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output, b, 0644)
+}
+
+// signature returns the v4 signature file of the apk in r:
+//
+//	uint32 version 2
+//	length-prefixed hashing info:
+//	    uint32 hash algorithm 1 (SHA-256), uint8 log2 block size 12, length-prefixed salt, length-prefixed root hash
+//	length-prefixed signing info:
+//	    length-prefixed v2 or v3 content digest, length-prefixed X.509 certificate,
+//	    length-prefixed additional data, length-prefixed SubjectPublicKeyInfo,
+//	    uint32 signature algorithm, length-prefixed signature
+//	length-prefixed Merkle tree
+//
+// The signature is over the size of the signed data, uint64 apk size and the fields of
+// hashing info and signing info up to the additional data, all little-endian.
+func (s *v4Signer) signature(r io.ReaderAt, size int64) ([]byte, error) {
+	tree, root, err := merkleTree(r, size)
+	if err != nil {
+		return nil, err
+	}
+	cert := s.certs[0]
+	hashingInfo := append(uint32s(v4HashingAlgorithmSHA256), v4Log2BlockSize)
+	hashingInfo = append(hashingInfo, lengthPrefixed(nil, root)...)
+	signed := append(hashingInfo, lengthPrefixed(s.apkDigest, cert.Raw, nil)...)
+	signedData := make([]byte, 12, 12+len(signed))
+	putUint32(uint32(12+len(signed)), signedData, 0)
+	putUint64(uint64(size), signedData, 4)
+	sig, err := s.sign(append(signedData, signed...))
+	if err != nil {
+		return nil, err
+	}
+	signingInfo := lengthPrefixed(s.apkDigest, cert.Raw, nil, cert.RawSubjectPublicKeyInfo)
+	signingInfo = append(signingInfo, uint32s(uint32(s.alg))...)
+	signingInfo = append(signingInfo, lengthPrefixed(sig)...)
+	b := append(uint32s(v4SignatureVersion), lengthPrefixed(hashingInfo, signingInfo)...)
+	return append(b, lengthPrefixed(tree)...), nil
+}
+
+// merkleTree returns the fs-verity Merkle tree of the apk in r without salt, and its
+// root hash. Every level is the SHA-256 of the 4096 byte blocks of the level below,
+// the bottom one of the apk, zero-padded to whole blocks, up to a level of a single
+// block whose SHA-256 is the root hash. The top level is first in the tree.
+func merkleTree(r io.ReaderAt, size int64) (tree, root []byte, err error) {
+	if size <= 0 {
+		return nil, nil, errors.New("empty apk")
+	}
+	blocks := func(n int64) int64 { return (n + v4BlockSize - 1) / v4BlockSize }
+	// sizes of the levels from the bottom one
+	var sizes []int64
+	for n := size; ; {
+		digests := blocks(n) * v4MerkleTreeDigestSize
+		sizes = append(sizes, blocks(digests)*v4BlockSize)
+		if digests <= v4BlockSize {
+			break
+		}
+		n = digests
+	}
+	var total int64
+	for _, n := range sizes {
+		total += n
+	}
+	tree = make([]byte, total)
+
+	buf := make([]byte, v4BlockSize)
+	src := io.NewSectionReader(r, 0, size)
+	end := total
+	for _, n := range sizes {
+		level := tree[end-n : end]
+		for i := 0; ; i++ {
+			for j := range buf {
+				buf[j] = 0
+			}
+			k, err := io.ReadFull(src, buf)
+			if err == io.EOF {
+				break
+			}
+			if err != nil && err != io.ErrUnexpectedEOF {
+				return nil, nil, err
+			}
+			sum := sha256.Sum256(buf)
+			copy(level[i*v4MerkleTreeDigestSize:], sum[:])
+			if k < v4BlockSize {
+				break
+			}
+		}
+		src = io.NewSectionReader(bytes.NewReader(level), 0, n)
+		end -= n
+	}
+	sum := sha256.Sum256(tree[:v4BlockSize])
+	return tree, sum[:], nil
+}
@@ -0,0 +1,184 @@
+package _go
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testV4Signature is a parsed .idsig file.
+type testV4Signature struct {
+	root, apkDigest, cert, publicKey, signature, tree []byte
+	alg                                               SignatureAlgorithm
+	signedData                                        []byte
+}
+
+func testParseV4(t *testing.T, b []byte, size int64) *testV4Signature {
+	t.Helper()
+	fields := func(b []byte, n int) ([][]byte, []byte) {
+		var ret [][]byte
+		for i := 0; i < n; i++ {
+			v, rest, err := readLengthPrefixed(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ret, b = append(ret, v), rest
+		}
+		return ret, b
+	}
+	if getUint32(b, 0) != 2 {
+		t.Fatalf("version %d", getUint32(b, 0))
+	}
+	infos, rest := fields(b[4:], 3)
+	if len(rest) > 0 {
+		t.Fatalf("%d bytes after Merkle tree", len(rest))
+	}
+	hashingInfo, signingInfo := infos[0], infos[1]
+	if getUint32(hashingInfo, 0) != 1 || hashingInfo[4] != 12 {
+		t.Fatalf("hashing info %x", hashingInfo[:5])
+	}
+	hashing, _ := fields(hashingInfo[5:], 2)
+	if len(hashing[0]) != 0 {
+		t.Errorf("salt %x", hashing[0])
+	}
+	signing, rest := fields(signingInfo, 4)
+	sig, _ := fields(rest[4:], 1)
+	s := &testV4Signature{
+		root:      hashing[1],
+		apkDigest: signing[0],
+		cert:      signing[1],
+		publicKey: signing[3],
+		alg:       SignatureAlgorithm(getUint32(rest, 0)),
+		signature: sig[0],
+		tree:      infos[2],
+	}
+	signed := append(append([]byte{}, hashingInfo...), lengthPrefixed(s.apkDigest, s.cert, signing[2])...)
+	s.signedData = make([]byte, 12)
+	putUint32(uint32(12+len(signed)), s.signedData, 0)
+	putUint64(uint64(size), s.signedData, 4)
+	s.signedData = append(s.signedData, signed...)
+	return s
+}
+
+func TestWithV4Signing(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := testCertOf(t, rsaKey.Public(), rsaKey)
+	var signed bytes.Buffer
+	unsigned := testUnsignedZip(t)
+	if err := Sign(bytes.NewReader(unsigned), int64(len(unsigned)), &signed, rsaKey, []*x509.Certificate{cert}, WithV3Signing()); err != nil {
+		t.Fatal(err)
+	}
+	base := filepath.Join(t.TempDir(), "base.apk")
+	if err := os.WriteFile(base, signed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewApk(base, WithV4Signing(rsaKey, []*x509.Certificate{cert}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outs, err := a.BatchChannels([]string{"huawei", "xiaomi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := make(map[string]bool)
+	for _, out := range outs {
+		apk, err := os.ReadFile(out.Path())
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(out.Path() + ".idsig")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := testParseV4(t, b, int64(len(apk)))
+		roots[hex.EncodeToString(s.root)] = true
+
+		// the apk fits in a level of a single block
+		if len(s.tree) != 4096 {
+			t.Fatalf("Merkle tree of %d bytes", len(s.tree))
+		}
+		if sum := sha256.Sum256(s.tree); !bytes.Equal(sum[:], s.root) {
+			t.Errorf("root hash %x, want %x", s.root, sum)
+		}
+		for i := 0; i*4096 < len(apk); i++ {
+			block := make([]byte, 4096)
+			copy(block, apk[i*4096:])
+			if sum := sha256.Sum256(block); !bytes.Equal(sum[:], s.tree[32*i:32*i+32]) {
+				t.Errorf("digest of block %d %x, want %x", i, s.tree[32*i:32*i+32], sum)
+			}
+		}
+		v, err := VerifyFile(out.Path())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := v.V3.Signers[0].ContentDigests[SIGNATURE_RSA_PKCS1_V1_5_WITH_SHA256]; hex.EncodeToString(s.apkDigest) != want {
+			t.Errorf("apk digest %x, want %s", s.apkDigest, want)
+		}
+		if !bytes.Equal(s.cert, cert.Raw) || !bytes.Equal(s.publicKey, cert.RawSubjectPublicKeyInfo) {
+			t.Errorf("certificate or public key is not of the signer")
+		}
+		if err := s.alg.verify(rsaKey.Public(), s.signedData, s.signature); err != nil {
+			t.Errorf("%s: %s", s.alg, err)
+		}
+	}
+	if len(roots) != 2 {
+		t.Errorf("channel apks have the same root hash")
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := testCertOf(t, ecKey.Public(), ecKey)
+	a, err = NewApk(base, WithV4Signing(ecKey, []*x509.Certificate{other}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.BatchChannels([]string{"oppo"}); err == nil {
+		t.Errorf("apk is v4 signed by a key that is not its signer")
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(base), "base-oppo.apk")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("apk is generated with a v4 signing error")
+	}
+}
+
+func TestMerkleTree(t *testing.T) {
+	// 129 blocks take 2 blocks of digests, which take a block of the root level
+	data := bytes.Repeat([]byte{1}, 128*4096+1)
+	tree, root, err := merkleTree(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tree) != 3*4096 {
+		t.Fatalf("Merkle tree of %d bytes", len(tree))
+	}
+	block := make([]byte, 4096)
+	copy(block, data[128*4096:])
+	last := sha256.Sum256(block)
+	full := sha256.Sum256(data[:4096])
+	leaves := tree[4096:]
+	if !bytes.Equal(leaves[:32], full[:]) || !bytes.Equal(leaves[128*32:129*32], last[:]) ||
+		!bytes.Equal(leaves[129*32:], make([]byte, len(leaves)-129*32)) {
+		t.Errorf("wrong bottom level")
+	}
+	top0, top1 := sha256.Sum256(leaves[:4096]), sha256.Sum256(leaves[4096:])
+	if !bytes.Equal(tree[:32], top0[:]) || !bytes.Equal(tree[32:64], top1[:]) {
+		t.Errorf("wrong top level")
+	}
+	if sum := sha256.Sum256(tree[:4096]); !bytes.Equal(root, sum[:]) {
+		t.Errorf("root hash %x, want %x", root, sum)
+	}
+}
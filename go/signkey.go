@@ -0,0 +1,163 @@
+package _go
+
+import (
+	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// LoadSigningKey reads a private key and its certificate chain from PEM or DER files,
+// see ParsePrivateKey and ParseCertificates.
+func LoadSigningKey(keyPath, certPath string) (crypto.PrivateKey, []*x509.Certificate, error) {
+	b, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := ParsePrivateKey(b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", keyPath, err)
+	}
+	if b, err = os.ReadFile(certPath); err != nil {
+		return nil, nil, err
+	}
+	certs, err := ParseCertificates(b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", certPath, err)
+	}
+	return key, certs, nil
+}
+
+// ParsePrivateKey parses an unencrypted RSA, EC or DSA private key, PKCS#8 in PEM or
+// DER, or the PKCS#1 and SEC 1 PEM blocks written by OpenSSL.
+func ParsePrivateKey(b []byte) (crypto.PrivateKey, error) {
+	der, typ := b, "PRIVATE KEY"
+	if block, _ := pem.Decode(b); block != nil {
+		if _, ok := block.Headers["Proc-Type"]; ok {
+			return nil, errors.New("encrypted PEM private key is not supported")
+		}
+		der, typ = block.Bytes, block.Type
+	}
+	switch typ {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			// Go does not parse PKCS#8 DSA keys
+			if key, dsaErr := parsePKCS8DSAPrivateKey(der); dsaErr == nil {
+				return key, nil
+			}
+			return nil, err
+		}
+		return key, nil
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(der)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(der)
+	case "DSA PRIVATE KEY":
+		return parseOpenSSLDSAPrivateKey(der)
+	}
+	return nil, fmt.Errorf("unsupported PEM block %s", typ)
+}
+
+// ParseCertificates parses the certificates of PEM CERTIFICATE blocks or concatenated
+// DER certificates, the first one must be of the signing key.
+func ParseCertificates(b []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	if block, rest := pem.Decode(b); block != nil {
+		for ; block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, c)
+		}
+	} else {
+		var err error
+		if certs, err = x509.ParseCertificates(b); err != nil {
+			return nil, err
+		}
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate")
+	}
+	return certs, nil
+}
+
+var oidDSA = asn1.ObjectIdentifier{1, 2, 840, 10040, 4, 1}
+
+type pkcs8 struct {
+	Version    int
+	Algorithm  pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+func parsePKCS8DSAPrivateKey(der []byte) (*dsa.PrivateKey, error) {
+	var k pkcs8
+	if _, err := asn1.Unmarshal(der, &k); err != nil {
+		return nil, err
+	}
+	if !k.Algorithm.Algorithm.Equal(oidDSA) {
+		return nil, fmt.Errorf("not a DSA key: %s", k.Algorithm.Algorithm)
+	}
+	var params dsa.Parameters
+	if _, err := asn1.Unmarshal(k.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("malformed DSA parameters: %s", err)
+	}
+	x := new(big.Int)
+	if _, err := asn1.Unmarshal(k.PrivateKey, &x); err != nil {
+		return nil, fmt.Errorf("malformed DSA private key: %s", err)
+	}
+	return newDSAPrivateKey(params, x), nil
+}
+
+func parseOpenSSLDSAPrivateKey(der []byte) (*dsa.PrivateKey, error) {
+	var k struct {
+		Version       int
+		P, Q, G, Y, X *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &k); err != nil {
+		return nil, fmt.Errorf("malformed DSA private key: %s", err)
+	}
+	return newDSAPrivateKey(dsa.Parameters{P: k.P, Q: k.Q, G: k.G}, k.X), nil
+}
+
+func newDSAPrivateKey(params dsa.Parameters, x *big.Int) *dsa.PrivateKey {
+	y := new(big.Int).Exp(params.G, x, params.P)
+	return &dsa.PrivateKey{PublicKey: dsa.PublicKey{Parameters: params, Y: y}, X: x}
+}
+
+// publicKeyOf returns the public key of an RSA, EC or DSA private key.
+func publicKeyOf(key crypto.PrivateKey) (crypto.PublicKey, error) {
+	switch k := key.(type) {
+	case *dsa.PrivateKey:
+		return &k.PublicKey, nil
+	case crypto.Signer:
+		return k.Public(), nil
+	}
+	return nil, fmt.Errorf("unsupported private key %T", key)
+}
+
+// equalPublicKeys reports whether a and b are the same RSA, EC or DSA public key.
+func equalPublicKeys(a, b crypto.PublicKey) bool {
+	switch a := a.(type) {
+	case *rsa.PublicKey:
+		return a.Equal(b)
+	case *ecdsa.PublicKey:
+		return a.Equal(b)
+	case *dsa.PublicKey:
+		b, ok := b.(*dsa.PublicKey)
+		return ok && a.Y.Cmp(b.Y) == 0 && a.P.Cmp(b.P) == 0 && a.Q.Cmp(b.Q) == 0 && a.G.Cmp(b.G) == 0
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package _go
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Query parameters of signed download URLs, they are never written as extras.
+const (
+	signURLExpiresParam = "expires"
+	signURLSigParam     = "sig"
+	signURLTenantParam  = "tenant"
+)
+
+// SignURL returns the signed path and query to download the channel apk from a Server
+// with WithURLSigning(secret), such as "/apk/demo/xiaomi?k=v&expires=1600000000&sig=...".
+// The signature covers the base, the channel, a hash of the extras and the expiry.
+func SignURL(secret []byte, base, channel string, extras map[string]string, expires time.Time) string {
+	return SignTenantURL(secret, "", base, channel, extras, expires)
+}
+
+// SignTenantURL is SignURL for a base of tenant, see WithAuth. The tenant is covered by
+// the signature too. Extras named as the parameters of signed URLs or "channel" are
+// never served, they are dropped.
+func SignTenantURL(secret []byte, tenant, base, channel string, extras map[string]string, expires time.Time) string {
+	extras = signedExtras(extras)
+	q := make(url.Values)
+	for k, v := range extras {
+		q.Set(k, v)
+	}
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	q.Set(signURLExpiresParam, exp)
+	if tenant != "" {
+		q.Set(signURLTenantParam, tenant)
+	}
+	q.Set(signURLSigParam, signURL(secret, tenant, base, channel, extras, exp))
+	return "/apk/" + url.PathEscape(base) + "/" + url.PathEscape(channel) + "?" + q.Encode()
+}
+
+// reservedExtra reports whether k cannot be an extra of a download URL.
+func reservedExtra(k string) bool {
+	return k == signURLExpiresParam || k == signURLSigParam || k == signURLTenantParam || k == "channel"
+}
+
+// signedExtras returns extras without reserved ones, nil if there is none, so empty
+// and nil extras have the same signature as the server reads them from the query.
+func signedExtras(extras map[string]string) map[string]string {
+	var ret map[string]string
+	for k, v := range extras {
+		if reservedExtra(k) {
+			continue
+		}
+		if ret == nil {
+			ret = make(map[string]string, len(extras))
+		}
+		ret[k] = v
+	}
+	return ret
+}
+
+func signURL(secret []byte, tenant, base, channel string, extras map[string]string, expires string) string {
+	info := channelInfo{extras: signedExtras(extras)}
+	extrasHash := sha256.Sum256(info.Bytes())
+	mac := hmac.New(sha256.New, secret)
+	if tenant != "" {
+		mac.Write([]byte("tenant:" + tenant + "\n"))
+	}
+	mac.Write([]byte(base + "\n" + channel + "\n" + hex.EncodeToString(extrasHash[:]) + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// WithURLSigning only serves the URLs made by SignURL with the same secret. Requests
+// without signature get 401, tampered ones 403, malformed expiry 400 and expired
+// URLs 410.
+func WithURLSigning(secret []byte) func(*Server) {
+	return func(s *Server) {
+		s.secret = secret
+	}
+}
+
+// checkSignedURL returns the status code to reject r with, or 0 if r is signed.
+func checkSignedURL(secret []byte, r *http.Request, tenant, base, channel string, extras map[string]string, now time.Time) (int, string) {
+	q := r.URL.Query()
+	sig, exp := q.Get(signURLSigParam), q.Get(signURLExpiresParam)
+	if sig == "" || exp == "" {
+		return http.StatusUnauthorized, "missing signature"
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return http.StatusBadRequest, "invalid expires"
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return http.StatusForbidden, "invalid signature"
+	}
+	want, _ := hex.DecodeString(signURL(secret, tenant, base, channel, extras, exp))
+	if !hmac.Equal(got, want) {
+		return http.StatusForbidden, "invalid signature"
+	}
+	if now.Unix() > expires {
+		return http.StatusGone, "link expired"
+	}
+	return 0, ""
+}
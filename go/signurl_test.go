@@ -0,0 +1,47 @@
+package _go
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_WithURLSigning(t *testing.T) {
+	secret := []byte("s3cret")
+	s, _ := newTestServer(t, WithURLSigning(secret))
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	extras := map[string]string{"k": "v"}
+	valid := SignURL(secret, "demo", "xiaomi", extras, time.Now().Add(time.Hour))
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{"valid", valid, http.StatusOK},
+		{"empty extras", SignURL(secret, "demo", "xiaomi", map[string]string{}, time.Now().Add(time.Hour)), http.StatusOK},
+		{"reserved extras dropped", SignURL(secret, "demo", "xiaomi", map[string]string{"tenant": "x", "expires": "0"}, time.Now().Add(time.Hour)), http.StatusOK},
+		{"missing", "/apk/demo/xiaomi?k=v", http.StatusUnauthorized},
+		{"tampered channel", strings.Replace(valid, "xiaomi", "huawei", 1), http.StatusForbidden},
+		{"tampered extras", strings.Replace(valid, "k=v", "k=w", 1), http.StatusForbidden},
+		{"added extras", valid + "&x=y", http.StatusForbidden},
+		{"other secret", SignURL([]byte("other"), "demo", "xiaomi", extras, time.Now().Add(time.Hour)), http.StatusForbidden},
+		{"expired", SignURL(secret, "demo", "xiaomi", extras, time.Now().Add(-time.Minute)), http.StatusGone},
+		{"bad expires", "/apk/demo/xiaomi?expires=soon&sig=00", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(srv.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("GET %s got status %d, want %d", tt.path, resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
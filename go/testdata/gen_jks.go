@@ -0,0 +1,134 @@
+//go:build ignore
+// +build ignore
+
+// gen_jks writes the JKS keystore with an EC key and a trusted certificate, which
+// keytool -genkeypair does not make in one step. keytool.jks is the keytool made one.
+//
+//	go run gen_jks.go -key key.pem -certs chain.pem -trusted ca.crt -storepass storepass -keypass keypass -out keystore.jks
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"flag"
+	"io/ioutil"
+	"log"
+	"time"
+	"unicode/utf16"
+)
+
+func password(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(c>>8), byte(c))
+	}
+	return b
+}
+
+func pemBlocks(path string) [][]byte {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var ret [][]byte
+	for block, rest := pem.Decode(b); block != nil; block, rest = pem.Decode(rest) {
+		ret = append(ret, block.Bytes)
+	}
+	return ret
+}
+
+// protect encrypts a PKCS#8 key with the JKS key protector.
+func protect(key []byte, pass string) []byte {
+	pw := password(pass)
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		log.Fatal(err)
+	}
+	out := append([]byte{}, salt...)
+	d := salt
+	for i := 0; i < len(key); i += sha1.Size {
+		sum := sha1.Sum(append(append([]byte{}, pw...), d...))
+		d = sum[:]
+		for j := 0; j < sha1.Size && i+j < len(key); j++ {
+			out = append(out, key[i+j]^d[j])
+		}
+	}
+	check := sha1.Sum(append(append([]byte{}, pw...), key...))
+	info, err := asn1.Marshal(struct {
+		Algorithm struct {
+			Algorithm  asn1.ObjectIdentifier
+			Parameters asn1.RawValue
+		}
+		EncryptedData []byte
+	}{
+		Algorithm: struct {
+			Algorithm  asn1.ObjectIdentifier
+			Parameters asn1.RawValue
+		}{asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}, asn1.NullRawValue},
+		EncryptedData: append(out, check[:]...),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return info
+}
+
+func main() {
+	keyPath := flag.String("key", "", "PKCS#8 PEM private key")
+	certsPath := flag.String("certs", "", "PEM certificate chain of the key")
+	trustedPath := flag.String("trusted", "", "PEM trusted certificate")
+	alias := flag.String("alias", "walle", "alias of the key")
+	storePass := flag.String("storepass", "", "store password")
+	keyPass := flag.String("keypass", "", "key password")
+	out := flag.String("out", "", "output keystore")
+	flag.Parse()
+
+	var b bytes.Buffer
+	w := func(v interface{}) {
+		if err := binary.Write(&b, binary.BigEndian, v); err != nil {
+			log.Fatal(err)
+		}
+	}
+	utf := func(s string) {
+		w(uint16(len(s)))
+		b.WriteString(s)
+	}
+	cert := func(der []byte) {
+		utf("X.509")
+		w(uint32(len(der)))
+		b.Write(der)
+	}
+	now := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+
+	w(uint32(0xfeedfeed))
+	w(uint32(2))
+	w(uint32(2))
+	w(uint32(1))
+	utf(*alias)
+	w(now)
+	key := protect(pemBlocks(*keyPath)[0], *keyPass)
+	w(uint32(len(key)))
+	b.Write(key)
+	chain := pemBlocks(*certsPath)
+	w(uint32(len(chain)))
+	for _, c := range chain {
+		cert(c)
+	}
+	w(uint32(2))
+	utf("ca")
+	w(now)
+	cert(pemBlocks(*trustedPath)[0])
+
+	h := sha1.New()
+	h.Write(password(*storePass))
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(b.Bytes())
+	b.Write(h.Sum(nil))
+	if err := ioutil.WriteFile(*out, b.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,481 @@
+package _go
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Verification is the result of Verify.
+type Verification struct {
+	// Verified is true if a signature scheme is present and every present scheme verifies.
+	Verified bool                `json:"verified"`
+	V1       *SchemeVerification `json:"v1,omitempty"`
+	V2       *SchemeVerification `json:"v2,omitempty"`
+	V3       *SchemeVerification `json:"v3,omitempty"`
+	V31      *SchemeVerification `json:"v31,omitempty"`
+	// Errors are the problems outside of any scheme, such as a missing signing block.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// SchemeVerification is the result of a signature scheme.
+type SchemeVerification struct {
+	Verified bool                  `json:"verified"`
+	Signers  []*SignerVerification `json:"signers"`
+	Errors   []string              `json:"errors,omitempty"`
+	Warnings []string              `json:"warnings,omitempty"`
+}
+
+// SignerVerification is the result of a signer of a signature scheme.
+type SignerVerification struct {
+	Verified bool `json:"verified"`
+	// Algorithm is the signature algorithm that was verified, the strongest supported
+	// one, 0 for v1 signers.
+	Algorithm SignatureAlgorithm `json:"algorithm,omitempty"`
+	// Certificates are the certificates of the signer, the first one is of its key.
+	Certificates []*x509.Certificate `json:"-"`
+	// Certificate describes Certificates[0].
+	Certificate *Certificate `json:"certificate,omitempty"`
+	// ContentDigests are the hex content digests in the signed data by algorithm.
+	ContentDigests map[SignatureAlgorithm]string `json:"contentDigests,omitempty"`
+	// SignatureFile is the META-INF/*.SF file of a v1 signer.
+	SignatureFile string `json:"signatureFile,omitempty"`
+	// StrippingProtection are the IDs of the APK Signature Schemes a v1 signer tells
+	// the apk is also signed with.
+	StrippingProtection []int `json:"strippingProtection,omitempty"`
+	// MinSDK and MaxSDK are the SDK versions a v3 or v3.1 signer applies to.
+	MinSDK int `json:"minSdk,omitempty"`
+	MaxSDK int `json:"maxSdk,omitempty"`
+	// Lineage is the proof-of-rotation of a v3 or v3.1 signer, the last node is of
+	// Certificates[0].
+	Lineage []*LineageNode `json:"lineage,omitempty"`
+	// RotationMinSDK is the min SDK version of the v3.1 signers told by a v3 signer.
+	RotationMinSDK       int      `json:"rotationMinSdk,omitempty"`
+	RotationOnDevRelease bool     `json:"rotationOnDevRelease,omitempty"`
+	Errors               []string `json:"errors,omitempty"`
+	Warnings             []string `json:"warnings,omitempty"`
+}
+
+func (s *SignerVerification) errorf(format string, args ...interface{}) {
+	s.Errors = append(s.Errors, fmt.Sprintf(format, args...))
+}
+
+func (s *SchemeVerification) errorf(format string, args ...interface{}) {
+	s.Errors = append(s.Errors, fmt.Sprintf(format, args...))
+}
+
+// done sets Verified from the errors of the scheme and its signers.
+func (s *SchemeVerification) done() *SchemeVerification {
+	s.Verified = len(s.Errors) == 0 && len(s.Signers) > 0
+	for _, signer := range s.Signers {
+		signer.Verified = len(signer.Errors) == 0
+		s.Verified = s.Verified && signer.Verified
+	}
+	return s
+}
+
+// VerifyFile verifies the signatures of the apk at path, see Verify.
+func VerifyFile(path string) (*Verification, error) {
+	ret, err := openFile(path, func(f *os.File) (interface{}, error) {
+		size, err := fileSize(f)
+		if err != nil {
+			return nil, err
+		}
+		return Verify(f, size)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret.(*Verification), nil
+}
+
+// Verify verifies the JAR (v1) and APK Signature Scheme v2, v3 and v3.1 signatures of
+// the apk in r: the signed data of every signer is checked with its public key and
+// certificate, and the content digests are recomputed over the bytes before the signing
+// block, the central directory and the EOCD. The proof-of-rotation lineages and SDK
+// version ranges of v3 signers are checked too, see SignersFor. JAR signatures are
+// checked down to the digest of every entry, see verifyV1. An error is only returned
+// if r is not a zip or cannot be read, verification failures are reported in the
+// result.
+// See https://source.android.com/docs/security/features/apksigning/v2#verification and
+// https://source.android.com/docs/security/features/apksigning/v3
+func Verify(r io.ReaderAt, size int64) (*Verification, error) {
+	eocd, eocdOffset, err := findEndOfCentralDirectoryRecord(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if eocdOffset <= 0 {
+		return nil, errors.New("Cannot find EOCD record, maybe a broken zip file.")
+	}
+	res := &Verification{}
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		res.Errors = append(res.Errors, err.Error())
+		return res, nil
+	}
+	if hasV1Signature(zr) {
+		res.V1 = verifyV1(zr)
+	}
+	if err := res.verifyBlocks(r, size, eocd, eocdOffset); err != nil {
+		return nil, err
+	}
+	if res.V1 != nil {
+		checkV1Stripping(res)
+	}
+	schemes := res.schemes()
+	for _, s := range schemes {
+		for _, signer := range s.Signers {
+			if len(signer.Certificates) > 0 {
+				signer.Certificate = NewCertificate(signer.Certificates[0])
+			}
+		}
+	}
+	if len(schemes) == 0 && len(res.Errors) == 0 {
+		res.Errors = append(res.Errors, "no JAR signature or APK Signature Scheme v2 or v3 block")
+	}
+	res.Verified = len(res.Errors) == 0
+	for _, s := range schemes {
+		res.Verified = res.Verified && s.Verified
+	}
+	return res, nil
+}
+
+// verifyBlocks verifies the signature scheme blocks in the APK Signing Block. A missing
+// signing block is only an error without a JAR signature, Android falls back to v1.
+func (v *Verification) verifyBlocks(r io.ReaderAt, size int64, eocd []byte, eocdOffset int64) error {
+	z, err := newZipSectionsAt(r, size)
+	if err != nil {
+		if v.V1 == nil {
+			v.Errors = append(v.Errors, err.Error())
+		}
+		return nil
+	}
+	if end := z.centralDirOffset + int64(len(z.centraDir)); end != eocdOffset || !bytes.Equal(z.eocd, eocd) {
+		v.Errors = append(v.Errors, fmt.Sprintf("central directory ends at %d, but EOCD starts at %d", end, eocdOffset))
+		return nil
+	}
+	values, err := findIdValuesInApkSigningBlock(z.signingBlock, APK_SIGNATURE_SCHEME_V2_BLOCK_ID,
+		APK_SIGNATURE_SCHEME_V3_BLOCK_ID, APK_SIGNATURE_SCHEME_V31_BLOCK_ID)
+	if err != nil {
+		v.Errors = append(v.Errors, err.Error())
+		return nil
+	}
+	digester := &contentDigester{z: &z}
+	v3, hasV3 := values[APK_SIGNATURE_SCHEME_V3_BLOCK_ID]
+	if value, ok := values[APK_SIGNATURE_SCHEME_V2_BLOCK_ID]; ok {
+		v.V2 = verifyV2(value, hasV3, digester)
+	}
+	if hasV3 {
+		v.V3 = verifyV3(v3, digester)
+	}
+	if value, ok := values[APK_SIGNATURE_SCHEME_V31_BLOCK_ID]; ok {
+		v.V31 = verifyV3(value, digester)
+		checkV31(v.V3, v.V31)
+	}
+	return digester.err
+}
+
+// verifyOutput returns the first error of a generated apk that does not verify.
+func verifyOutput(output string) error {
+	res, err := VerifyFile(output)
+	if err != nil {
+		return err
+	}
+	if res.Verified {
+		return nil
+	}
+	return errors.New(res.firstError())
+}
+
+// schemes returns the results of the present signature schemes.
+func (v *Verification) schemes() []*SchemeVerification {
+	var ret []*SchemeVerification
+	for _, s := range []*SchemeVerification{v.V1, v.V2, v.V3, v.V31} {
+		if s != nil {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+func (v *Verification) firstError() string {
+	if len(v.Errors) > 0 {
+		return v.Errors[0]
+	}
+	for _, s := range v.schemes() {
+		if len(s.Errors) > 0 {
+			return s.Errors[0]
+		}
+		for _, signer := range s.Signers {
+			if len(signer.Errors) > 0 {
+				return signer.Errors[0]
+			}
+		}
+	}
+	return "signature does not verify"
+}
+
+// contentDigester computes the content digests of an apk once for all signers.
+type contentDigester struct {
+	z       *zipSections
+	digests map[contentDigestAlgorithm][]byte
+	// err is an I/O error, which fails Verify rather than the signature.
+	err error
+}
+
+func (d *contentDigester) digest(alg contentDigestAlgorithm) ([]byte, bool) {
+	if v, ok := d.digests[alg]; ok {
+		return v, true
+	}
+	if d.err != nil {
+		return nil, false
+	}
+	digests, err := computeContentDigests(d.z, alg)
+	if err != nil {
+		d.err = err
+		return nil, false
+	}
+	if d.digests == nil {
+		d.digests = make(map[contentDigestAlgorithm][]byte)
+	}
+	d.digests[alg] = digests[alg]
+	return digests[alg], true
+}
+
+// readLengthPrefixed reads a value prefixed by its uint32 length from b.
+func readLengthPrefixed(b []byte) (value, rest []byte, err error) {
+	if len(b) < 4 {
+		return nil, nil, fmt.Errorf("remaining %d bytes are too short for a length prefix", len(b))
+	}
+	n := getUint32(b, 0)
+	if uint64(n) > uint64(len(b)-4) {
+		return nil, nil, fmt.Errorf("length %d is out of the remaining %d bytes", n, len(b)-4)
+	}
+	return b[4 : 4+n], b[4+n:], nil
+}
+
+// readLengthPrefixedSequence reads a length-prefixed sequence of length-prefixed values.
+func readLengthPrefixedSequence(b []byte) (values [][]byte, rest []byte, err error) {
+	seq, rest, err := readLengthPrefixed(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	for len(seq) > 0 {
+		var v []byte
+		if v, seq, err = readLengthPrefixed(seq); err != nil {
+			return nil, nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rest, nil
+}
+
+// algorithmValue is a signature algorithm ID followed by a length-prefixed value, used
+// by the digests and signatures of signers.
+type algorithmValue struct {
+	algorithm SignatureAlgorithm
+	value     []byte
+}
+
+func readAlgorithmValues(b []byte) ([]algorithmValue, []byte, error) {
+	records, rest, err := readLengthPrefixedSequence(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	avs := make([]algorithmValue, len(records))
+	for i, rec := range records {
+		if len(rec) < 4 {
+			return nil, nil, fmt.Errorf("record #%d is too short", i)
+		}
+		v, _, err := readLengthPrefixed(rec[4:])
+		if err != nil {
+			return nil, nil, fmt.Errorf("record #%d: %s", i, err)
+		}
+		avs[i] = algorithmValue{algorithm: SignatureAlgorithm(getUint32(rec, 0)), value: v}
+	}
+	return avs, rest, nil
+}
+
+// signer is a signer of the v2 and v3 blocks:
+//
+//	length-prefixed signed data:
+//	    length-prefixed sequence of digests (algorithm ID, length-prefixed digest)
+//	    length-prefixed sequence of length-prefixed X.509 certificates
+//	    uint32 min SDK and uint32 max SDK (v3 only)
+//	    length-prefixed sequence of length-prefixed additional attributes (ID, value)
+//	uint32 min SDK and uint32 max SDK (v3 only)
+//	length-prefixed sequence of signatures (algorithm ID, length-prefixed signature)
+//	length-prefixed public key (SubjectPublicKeyInfo)
+type signer struct {
+	signedData   []byte
+	digests      []algorithmValue
+	certificates [][]byte
+	attributes   [][]byte
+	signatures   []algorithmValue
+	publicKey    []byte
+
+	// SDK versions of v3 signers in the signed data and outside of it.
+	signedMinSDK, signedMaxSDK uint32
+	minSDK, maxSDK             uint32
+}
+
+func parseSigner(b []byte, v3 bool) (*signer, error) {
+	s := &signer{}
+	signedData, rest, err := readLengthPrefixed(b)
+	if err != nil {
+		return nil, fmt.Errorf("signed data: %s", err)
+	}
+	s.signedData = signedData
+	if s.digests, signedData, err = readAlgorithmValues(signedData); err != nil {
+		return nil, fmt.Errorf("digests: %s", err)
+	}
+	if s.certificates, signedData, err = readLengthPrefixedSequence(signedData); err != nil {
+		return nil, fmt.Errorf("certificates: %s", err)
+	}
+	if v3 {
+		if len(signedData) < 8 {
+			return nil, errors.New("signed data has no SDK versions")
+		}
+		s.signedMinSDK, s.signedMaxSDK = getUint32(signedData, 0), getUint32(signedData, 4)
+		signedData = signedData[8:]
+	}
+	if s.attributes, _, err = readLengthPrefixedSequence(signedData); err != nil {
+		return nil, fmt.Errorf("additional attributes: %s", err)
+	}
+	if v3 {
+		if len(rest) < 8 {
+			return nil, errors.New("no SDK versions")
+		}
+		s.minSDK, s.maxSDK = getUint32(rest, 0), getUint32(rest, 4)
+		rest = rest[8:]
+	}
+	if s.signatures, rest, err = readAlgorithmValues(rest); err != nil {
+		return nil, fmt.Errorf("signatures: %s", err)
+	}
+	if s.publicKey, _, err = readLengthPrefixed(rest); err != nil {
+		return nil, fmt.Errorf("public key: %s", err)
+	}
+	return s, nil
+}
+
+// attribute returns the value of the additional attribute id of s.
+func (s *signer) attribute(id uint32) ([]byte, bool) {
+	for _, attr := range s.attributes {
+		if len(attr) >= 4 && getUint32(attr, 0) == id {
+			return attr[4:], true
+		}
+	}
+	return nil, false
+}
+
+// verifySigners verifies the value of a v2 or v3 block, a length-prefixed sequence of
+// length-prefixed signers. check is called with every signer that verifies.
+func verifySigners(value []byte, v3 bool, digester *contentDigester, check func(*signer, *SignerVerification)) *SchemeVerification {
+	res := &SchemeVerification{Signers: []*SignerVerification{}}
+	signers, rest, err := readLengthPrefixedSequence(value)
+	if err != nil {
+		res.errorf("malformed block: %s", err)
+		return res.done()
+	}
+	if len(rest) > 0 {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("%d bytes after signers", len(rest)))
+	}
+	if len(signers) == 0 {
+		res.errorf("no signers")
+		return res.done()
+	}
+	for i, b := range signers {
+		sv := &SignerVerification{}
+		res.Signers = append(res.Signers, sv)
+		s, err := parseSigner(b, v3)
+		if err != nil {
+			sv.errorf("malformed signer #%d: %s", i, err)
+			continue
+		}
+		verifySigner(s, sv, digester)
+		if len(sv.Errors) == 0 && check != nil {
+			check(s, sv)
+		}
+	}
+	return res.done()
+}
+
+// verifySigner checks the strongest supported signature of s over its signed data,
+// the certificate of the public key and the content digest of that algorithm.
+func verifySigner(s *signer, res *SignerVerification, digester *contentDigester) {
+	res.ContentDigests = make(map[SignatureAlgorithm]string, len(s.digests))
+	for _, d := range s.digests {
+		res.ContentDigests[d.algorithm] = hex.EncodeToString(d.value)
+	}
+	for _, der := range s.certificates {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			res.errorf("malformed certificate #%d: %s", len(res.Certificates), err)
+			return
+		}
+		res.Certificates = append(res.Certificates, cert)
+	}
+
+	var best *algorithmValue
+	for i, sig := range s.signatures {
+		if !sig.algorithm.supported() {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("%s is not verified", sig.algorithm))
+			continue
+		}
+		if best == nil || sig.algorithm.stronger(best.algorithm) {
+			best = &s.signatures[i]
+		}
+	}
+	if best == nil {
+		res.errorf("no supported signature")
+		return
+	}
+	res.Algorithm = best.algorithm
+	pub, err := x509.ParsePKIXPublicKey(s.publicKey)
+	if err != nil {
+		res.errorf("malformed public key: %s", err)
+		return
+	}
+	if err := best.algorithm.verify(pub, s.signedData, best.value); err != nil {
+		res.errorf("%s signature over signed data does not verify: %s", best.algorithm, err)
+		return
+	}
+
+	if len(s.digests) != len(s.signatures) {
+		res.errorf("%d digests but %d signatures", len(s.digests), len(s.signatures))
+		return
+	}
+	for i := range s.digests {
+		if s.digests[i].algorithm != s.signatures[i].algorithm {
+			res.errorf("signature algorithms of digests and signatures mismatch")
+			return
+		}
+	}
+	if len(res.Certificates) == 0 {
+		res.errorf("no certificate")
+		return
+	}
+	if !bytes.Equal(res.Certificates[0].RawSubjectPublicKeyInfo, s.publicKey) {
+		res.errorf("public key does not match the first certificate")
+		return
+	}
+
+	var signed []byte
+	for _, d := range s.digests {
+		if d.algorithm == best.algorithm {
+			signed = d.value
+		}
+	}
+	computed, ok := digester.digest(best.algorithm.contentDigest())
+	if !ok {
+		return
+	}
+	if !bytes.Equal(signed, computed) {
+		res.errorf("%s content digest mismatch, signed %x but computed %x", best.algorithm, signed, computed)
+	}
+}
@@ -0,0 +1,202 @@
+package _go
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert makes a self-signed certificate of key.
+func testCert(t *testing.T, key crypto.Signer) []byte {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "walle test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func testAlgorithmValue(alg SignatureAlgorithm, v []byte) []byte {
+	id := make([]byte, 4)
+	putUint32(uint32(alg), id, 0)
+	return append(id, lengthPrefixed(v)...)
+}
+
+func testSignature(t *testing.T, alg SignatureAlgorithm, key crypto.Signer, data []byte) []byte {
+	t.Helper()
+	h := alg.hash().New()
+	h.Write(data)
+	var opts crypto.SignerOpts = alg.hash()
+	if alg == SIGNATURE_RSA_PSS_WITH_SHA256 || alg == SIGNATURE_RSA_PSS_WITH_SHA512 {
+		opts = &rsa.PSSOptions{SaltLength: alg.hash().Size(), Hash: alg.hash()}
+	}
+	sig, err := key.Sign(rand.Reader, h.Sum(nil), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+// testSigner is a signer of testSign, SDK versions are only written for v3 blocks.
+type testSigner struct {
+	alg            SignatureAlgorithm
+	key            crypto.Signer
+	cert           []byte
+	minSDK, maxSDK uint32
+	// attrs are additional attributes, uint32 ID followed by the value.
+	attrs [][]byte
+}
+
+type testBlock struct {
+	id      uint32
+	signers []testSigner
+}
+
+// testSign replaces the signing block of the apk in b with the given signature scheme
+// blocks, the content digests of the apk are computed by computeContentDigests.
+func testSign(t *testing.T, b []byte, blocks ...testBlock) []byte {
+	t.Helper()
+	z, err := newZipSectionsAt(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pairs []byte
+	for _, block := range blocks {
+		v3 := block.id != APK_SIGNATURE_SCHEME_V2_BLOCK_ID
+		var signers [][]byte
+		for _, s := range block.signers {
+			digests, err := computeContentDigests(&z, s.alg.contentDigest())
+			if err != nil {
+				t.Fatal(err)
+			}
+			signedData := lengthPrefixed(lengthPrefixed(testAlgorithmValue(s.alg, digests[s.alg.contentDigest()])))
+			signedData = append(signedData, lengthPrefixed(lengthPrefixed(s.cert))...)
+			if v3 {
+				signedData = append(signedData, uint32s(s.minSDK, s.maxSDK)...)
+			}
+			signedData = append(signedData, lengthPrefixed(lengthPrefixed(s.attrs...))...)
+			pub, err := x509.MarshalPKIXPublicKey(s.key.Public())
+			if err != nil {
+				t.Fatal(err)
+			}
+			signer := lengthPrefixed(signedData)
+			if v3 {
+				signer = append(signer, uint32s(s.minSDK, s.maxSDK)...)
+			}
+			signer = append(signer, lengthPrefixed(lengthPrefixed(testAlgorithmValue(s.alg, testSignature(t, s.alg, s.key, signedData))), pub)...)
+			signers = append(signers, signer)
+		}
+		pairs = appendIdValue(pairs, block.id, lengthPrefixed(lengthPrefixed(signers...)))
+	}
+	block := testSigningBlock(pairs)
+
+	out := append([]byte{}, b[:z.signingBlockOffset]...)
+	out = append(out, block...)
+	out = append(out, z.centraDir...)
+	return append(out, makeEocd(z.eocd, uint32(len(out)-len(z.centraDir)))...)
+}
+
+func testSignV2(t *testing.T, b []byte, alg SignatureAlgorithm, key crypto.Signer, cert []byte) []byte {
+	t.Helper()
+	return testSign(t, b, testBlock{APK_SIGNATURE_SCHEME_V2_BLOCK_ID, []testSigner{{alg: alg, key: key, cert: cert}}})
+}
+
+func readTestApk(t *testing.T, path string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := readTestApk(t, newTestApk(t, t.TempDir()))
+	signed := testSignV2(t, unsigned, SIGNATURE_RSA_PKCS1_V1_5_WITH_SHA256, rsaKey, testCert(t, rsaKey))
+	cdOffset := int(getEocdCentralDirectoryOffset(signed[len(signed)-_ZIP_EOCD_REC_MIN_SIZE:]))
+	flip := func(b []byte, i int) []byte {
+		b = append([]byte{}, b...)
+		b[i] ^= 0xff
+		return b
+	}
+
+	tests := []struct {
+		name string
+		apk  []byte
+		want bool
+	}{
+		{"RSA PKCS#1 v1.5", signed, true},
+		{"RSA-PSS SHA-512", testSignV2(t, unsigned, SIGNATURE_RSA_PSS_WITH_SHA512, rsaKey, testCert(t, rsaKey)), true},
+		{"ECDSA", testSignV2(t, unsigned, SIGNATURE_ECDSA_WITH_SHA256, ecKey, testCert(t, ecKey)), true},
+		{"certificate of other key", testSignV2(t, unsigned, SIGNATURE_ECDSA_WITH_SHA256, ecKey, testCert(t, rsaKey)), false},
+		{"modified entry", flip(signed, 40), false},
+		{"modified central directory", flip(signed, cdOffset+20), false},
+		{"modified signature", flip(signed, cdOffset-400), false},
+		{"fake v2 block", unsigned, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Verify(bytes.NewReader(tt.apk), int64(len(tt.apk)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Verified != tt.want {
+				t.Errorf("Verified = %v, want %v: %s", res.Verified, tt.want, res.firstError())
+			}
+			if tt.want && (res.V2 == nil || len(res.V2.Signers) != 1 || len(res.V2.Signers[0].Certificates) != 1) {
+				t.Errorf("got %+v", res.V2)
+			}
+		})
+	}
+
+	t.Run("channel", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "signed.apk")
+		if err := os.WriteFile(path, signed, 0644); err != nil {
+			t.Fatal(err)
+		}
+		a, err := NewApk(path, WithVerify())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.PutChannelWithExtra("xiaomi", map[string]string{"k": "v"}, filepath.Join(dir, "out.apk")); err != nil {
+			t.Errorf("channel apk does not verify: %s", err)
+		}
+
+		a, err = NewApk(newTestApk(t, dir), WithVerify())
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := filepath.Join(dir, "unsigned-out.apk")
+		if _, err := a.PutChannel("xiaomi", out); err == nil {
+			t.Errorf("channel apk of unsigned base verifies")
+		}
+		if _, err := os.Stat(out); !os.IsNotExist(err) {
+			t.Errorf("unverified output is kept: %v", err)
+		}
+	})
+}
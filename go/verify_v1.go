@@ -0,0 +1,549 @@
+package _go
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jarManifest is the manifest of JAR signatures.
+const jarManifest = "META-INF/MANIFEST.MF"
+
+// jarSignedSchemesAttr is the main attribute of signature files that lists the IDs of
+// the APK Signature Schemes the apk is also signed with, so the v2 and v3 blocks cannot
+// be stripped to downgrade the apk to v1.
+const jarSignedSchemesAttr = "X-Android-APK-Signed"
+
+// jarSection is a section of a manifest or signature file.
+type jarSection struct {
+	// attrs by lower-case attribute name.
+	attrs map[string]string
+	// raw is the bytes of the section including the empty line that ends it.
+	raw []byte
+}
+
+func (s *jarSection) name() string {
+	return s.attrs["name"]
+}
+
+// parseJarSections parses a manifest or signature file: sections of "Name: value"
+// lines, a line starting with a space continues the previous value, and an empty line
+// ends a section. The first section is the main one.
+// See https://docs.oracle.com/javase/8/docs/technotes/guides/jar/jar.html#JAR_Manifest
+func parseJarSections(b []byte) (main *jarSection, sections []*jarSection, err error) {
+	cur := &jarSection{attrs: make(map[string]string)}
+	start, lastKey := 0, ""
+	end := func(off int) {
+		cur.raw = b[start:off]
+		if main == nil {
+			main = cur
+		} else if len(cur.attrs) > 0 {
+			sections = append(sections, cur)
+		}
+		cur, start, lastKey = &jarSection{attrs: make(map[string]string)}, off, ""
+	}
+	for off := 0; off < len(b); {
+		i := bytes.IndexAny(b[off:], "\r\n")
+		line, next := b[off:], len(b)
+		if i >= 0 {
+			line, next = b[off:off+i], off+i+1
+			if b[off+i] == '\r' && next < len(b) && b[next] == '\n' {
+				next++
+			}
+		}
+		off = next
+		switch {
+		case len(line) == 0:
+			end(off)
+		case line[0] == ' ':
+			if lastKey == "" {
+				return nil, nil, fmt.Errorf("continuation line without attribute at %d", off)
+			}
+			cur.attrs[lastKey] += string(line[1:])
+		default:
+			colon := bytes.Index(line, []byte(": "))
+			if colon <= 0 {
+				return nil, nil, fmt.Errorf("malformed line %q", line)
+			}
+			lastKey = strings.ToLower(string(line[:colon]))
+			if _, dup := cur.attrs[lastKey]; dup {
+				return nil, nil, fmt.Errorf("duplicate attribute %s", line[:colon])
+			}
+			cur.attrs[lastKey] = string(line[colon+2:])
+		}
+	}
+	if start < len(b) || main == nil {
+		end(len(b))
+	}
+	return main, sections, nil
+}
+
+// jarDigestAlgorithms are the digest algorithms of JAR signatures by lower-case name.
+var jarDigestAlgorithms = map[string]crypto.Hash{
+	"sha1":    crypto.SHA1,
+	"sha-1":   crypto.SHA1,
+	"sha-256": crypto.SHA256,
+	"sha-384": crypto.SHA384,
+	"sha-512": crypto.SHA512,
+}
+
+// jarDigests returns the digests of the "{algorithm}{suffix}" attributes of s, such as
+// "SHA-256-Digest", by the supported algorithms.
+func jarDigests(s *jarSection, suffix string) (map[crypto.Hash][]byte, error) {
+	digests := make(map[crypto.Hash][]byte)
+	for k, v := range s.attrs {
+		if !strings.HasSuffix(k, suffix) {
+			continue
+		}
+		h, ok := jarDigestAlgorithms[strings.TrimSuffix(k, suffix)]
+		if !ok {
+			continue
+		}
+		d, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("malformed %s: %s", k, err)
+		}
+		digests[h] = d
+	}
+	return digests, nil
+}
+
+// checkJarDigests checks that digests are of data, there must be one at least.
+func checkJarDigests(digests map[crypto.Hash][]byte, data []byte) bool {
+	if len(digests) == 0 {
+		return false
+	}
+	for h, d := range digests {
+		hh := h.New()
+		hh.Write(data)
+		if !bytes.Equal(hh.Sum(nil), d) {
+			return false
+		}
+	}
+	return true
+}
+
+// jarEntryNeedsDigest reports whether entry must be listed in the manifest, every file
+// except the manifest and signature files of META-INF.
+func jarEntryNeedsDigest(name string) bool {
+	if strings.HasSuffix(name, "/") {
+		return false
+	}
+	if !strings.HasPrefix(name, "META-INF/") || strings.Contains(name[len("META-INF/"):], "/") {
+		return true
+	}
+	lower := strings.ToLower(name[len("META-INF/"):])
+	if lower == "manifest.mf" || strings.HasPrefix(lower, "sig-") {
+		return false
+	}
+	switch path.Ext(lower) {
+	case ".sf", ".rsa", ".dsa", ".ec":
+		return false
+	}
+	return true
+}
+
+// verifyV1 verifies the JAR signatures of the apk in zr: the PKCS#7 signature over
+// every signature file, the signature file digests of the manifest and the manifest
+// digests of every entry, which must be signed by all signers.
+// See https://source.android.com/docs/security/features/apksigning#v1
+func verifyV1(zr *zip.Reader) *SchemeVerification {
+	res := &SchemeVerification{Signers: []*SignerVerification{}}
+	files := make(map[string]*zip.File, len(zr.File))
+	var blocks []string
+	for _, f := range zr.File {
+		if _, dup := files[f.Name]; dup {
+			res.errorf("duplicate entry %s", f.Name)
+			return res.done()
+		}
+		files[f.Name] = f
+		dir, name := path.Split(f.Name)
+		switch strings.ToUpper(path.Ext(name)) {
+		case ".RSA", ".DSA", ".EC":
+			if dir == "META-INF/" {
+				blocks = append(blocks, f.Name)
+			}
+		}
+	}
+	sort.Strings(blocks)
+	mf, ok := files[jarManifest]
+	if !ok {
+		res.errorf("no %s", jarManifest)
+		return res.done()
+	}
+	manifest, err := readZipFile(mf)
+	if err != nil {
+		res.errorf("%s: %s", jarManifest, err)
+		return res.done()
+	}
+	mainSection, sections, err := parseJarSections(manifest)
+	if err != nil {
+		res.errorf("malformed %s: %s", jarManifest, err)
+		return res.done()
+	}
+	entries := make(map[string]*jarSection, len(sections))
+	for _, s := range sections {
+		if _, dup := entries[s.name()]; dup {
+			res.errorf("duplicate section %s in %s", s.name(), jarManifest)
+			return res.done()
+		}
+		entries[s.name()] = s
+	}
+
+	// signed are the entries signed by every signer that verifies.
+	signed := make(map[*SignerVerification]map[string]bool)
+	for _, block := range blocks {
+		sf := strings.TrimSuffix(block, path.Ext(block)) + ".SF"
+		sv := &SignerVerification{SignatureFile: sf}
+		res.Signers = append(res.Signers, sv)
+		f, ok := files[sf]
+		if !ok {
+			sv.errorf("no signature file %s of %s", sf, block)
+			continue
+		}
+		if names := verifyJarSigner(f, files[block], manifest, mainSection, entries, sv); len(sv.Errors) == 0 {
+			signed[sv] = names
+		}
+	}
+	if len(blocks) == 0 {
+		res.errorf("no signature block file")
+	}
+
+	for _, f := range zr.File {
+		if !jarEntryNeedsDigest(f.Name) {
+			continue
+		}
+		s, ok := entries[f.Name]
+		if !ok {
+			res.errorf("entry %s is not in %s", f.Name, jarManifest)
+			continue
+		}
+		for _, sv := range res.Signers {
+			if names, ok := signed[sv]; ok && !names[f.Name] {
+				sv.errorf("entry %s is not signed", f.Name)
+			}
+		}
+		digests, err := jarDigests(s, "-digest")
+		if err != nil || len(digests) == 0 {
+			res.errorf("entry %s has no supported digest in %s", f.Name, jarManifest)
+			continue
+		}
+		if err := checkZipFileDigests(f, digests); err != nil {
+			res.errorf("entry %s: %s", f.Name, err)
+		}
+	}
+	return res.done()
+}
+
+// verifyJarSigner checks the signature block and signature file of a signer, and
+// returns the names of the entries it signs.
+func verifyJarSigner(sf, block *zip.File, manifest []byte, mainSection *jarSection,
+	entries map[string]*jarSection, sv *SignerVerification) map[string]bool {
+	sfBytes, err := readZipFile(sf)
+	if err != nil {
+		sv.errorf("%s: %s", sf.Name, err)
+		return nil
+	}
+	blockBytes, err := readZipFile(block)
+	if err != nil {
+		sv.errorf("%s: %s", block.Name, err)
+		return nil
+	}
+	certs, err := verifyPKCS7(blockBytes, sfBytes)
+	if err != nil {
+		sv.errorf("%s: %s", block.Name, err)
+		return nil
+	}
+	sv.Certificates = certs
+
+	sfMain, sfSections, err := parseJarSections(sfBytes)
+	if err != nil {
+		sv.errorf("malformed %s: %s", sf.Name, err)
+		return nil
+	}
+	if v, ok := sfMain.attrs[strings.ToLower(jarSignedSchemesAttr)]; ok {
+		for _, id := range strings.Split(v, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(id))
+			if err != nil {
+				sv.errorf("malformed %s: %s", jarSignedSchemesAttr, v)
+				return nil
+			}
+			sv.StrippingProtection = append(sv.StrippingProtection, n)
+		}
+	}
+
+	names := make(map[string]bool, len(entries))
+	// the digest of the whole manifest signs every entry in it
+	digests, err := jarDigests(sfMain, "-digest-manifest")
+	if err != nil {
+		sv.errorf("%s: %s", sf.Name, err)
+		return nil
+	}
+	if checkJarDigests(digests, manifest) {
+		for name := range entries {
+			names[name] = true
+		}
+		return names
+	}
+
+	digests, err = jarDigests(sfMain, "-digest-manifest-main-attributes")
+	if err != nil {
+		sv.errorf("%s: %s", sf.Name, err)
+		return nil
+	}
+	if len(digests) > 0 && !checkJarDigests(digests, mainSection.raw) {
+		sv.errorf("%s digest of manifest main attributes mismatch", sf.Name)
+		return nil
+	}
+	for _, s := range sfSections {
+		entry, ok := entries[s.name()]
+		if !ok {
+			sv.errorf("%s signs %s, which is not in %s", sf.Name, s.name(), jarManifest)
+			return nil
+		}
+		digests, err := jarDigests(s, "-digest")
+		if err != nil {
+			sv.errorf("%s: %s", sf.Name, err)
+			return nil
+		}
+		if !checkJarDigests(digests, entry.raw) {
+			sv.errorf("%s digest of manifest section %s mismatch", sf.Name, s.name())
+			return nil
+		}
+		names[s.name()] = true
+	}
+	return names
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// checkZipFileDigests streams the uncompressed data of f into every digest.
+func checkZipFileDigests(f *zip.File, digests map[crypto.Hash][]byte) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	hashes := make(map[crypto.Hash]io.Writer, len(digests))
+	writers := make([]io.Writer, 0, len(digests))
+	for h := range digests {
+		hh := h.New()
+		hashes[h], writers = hh, append(writers, hh)
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), rc); err != nil {
+		return err
+	}
+	for h, d := range digests {
+		if sum := hashes[h].(interface{ Sum([]byte) []byte }).Sum(nil); !bytes.Equal(sum, d) {
+			return fmt.Errorf("%s digest mismatch", h)
+		}
+	}
+	return nil
+}
+
+// PKCS#7 SignedData of JAR signature block files, see RFC 2315.
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []asn1.RawValue `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7AlgorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerialNumber
+	DigestAlgorithm           pkcs7AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkcs7AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type pkcs7IssuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+var (
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+
+	pkcs7DigestAlgorithms = map[string]crypto.Hash{
+		"1.3.14.3.2.26":          crypto.SHA1,
+		"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+		"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+		"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+	}
+)
+
+// verifyPKCS7 verifies the detached PKCS#7 signature in block over content, and returns
+// the certificates with the one of the signer first. Only one signer is allowed, as
+// Android does.
+func verifyPKCS7(block, content []byte) ([]*x509.Certificate, error) {
+	var ci pkcs7ContentInfo
+	if rest, err := asn1.Unmarshal(block, &ci); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("malformed PKCS#7: %v", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("PKCS#7 content type %s is not SignedData", ci.ContentType)
+	}
+	var sd pkcs7SignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("malformed PKCS#7 SignedData: %s", err)
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, fmt.Errorf("%d PKCS#7 signers, want 1", len(sd.SignerInfos))
+	}
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("malformed certificate: %s", err)
+	}
+	si := sd.SignerInfos[0]
+	var signerCert *x509.Certificate
+	for i, c := range certs {
+		if bytes.Equal(c.RawIssuer, si.IssuerAndSerialNumber.Issuer.FullBytes) &&
+			c.SerialNumber.Cmp(si.IssuerAndSerialNumber.SerialNumber) == 0 {
+			signerCert = c
+			certs[0], certs[i] = certs[i], certs[0]
+			break
+		}
+	}
+	if signerCert == nil {
+		return nil, errors.New("no certificate of the PKCS#7 signer")
+	}
+	h, ok := pkcs7DigestAlgorithms[si.DigestAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported digest algorithm %s", si.DigestAlgorithm.Algorithm)
+	}
+
+	signed := content
+	if len(si.AuthenticatedAttributes.FullBytes) > 0 {
+		// the signature is over the DER of the attributes as a SET rather than [0]
+		signed = append([]byte{0x31}, si.AuthenticatedAttributes.FullBytes[1:]...)
+		if err := checkPKCS7Attributes(signed, h, content); err != nil {
+			return nil, err
+		}
+	}
+	hh := h.New()
+	hh.Write(signed)
+	if err := verifyDigestSignature(signerCert.PublicKey, h, hh.Sum(nil), si.EncryptedDigest); err != nil {
+		return nil, fmt.Errorf("signature does not verify: %s", err)
+	}
+	return certs, nil
+}
+
+// checkPKCS7Attributes checks the content type and message digest attributes.
+func checkPKCS7Attributes(set []byte, h crypto.Hash, content []byte) error {
+	var attrs []pkcs7Attribute
+	if _, err := asn1.UnmarshalWithParams(set, &attrs, "set"); err != nil {
+		return fmt.Errorf("malformed PKCS#7 attributes: %s", err)
+	}
+	var contentType, digest bool
+	for _, a := range attrs {
+		switch {
+		case a.Type.Equal(oidContentType):
+			var oid asn1.ObjectIdentifier
+			if _, err := asn1.Unmarshal(a.Values.Bytes, &oid); err != nil || !oid.Equal(oidData) {
+				return errors.New("PKCS#7 content type attribute is not data")
+			}
+			contentType = true
+		case a.Type.Equal(oidMessageDigest):
+			var d []byte
+			if _, err := asn1.Unmarshal(a.Values.Bytes, &d); err != nil {
+				return errors.New("malformed PKCS#7 message digest attribute")
+			}
+			hh := h.New()
+			hh.Write(content)
+			if !bytes.Equal(d, hh.Sum(nil)) {
+				return errors.New("PKCS#7 message digest mismatch")
+			}
+			digest = true
+		}
+	}
+	if !contentType || !digest {
+		return errors.New("PKCS#7 attributes have no content type or message digest")
+	}
+	return nil
+}
+
+// verifyDigestSignature checks sig over hashed by the key of pub, RSA signatures are
+// PKCS#1 v1.5 ones.
+func verifyDigestSignature(pub crypto.PublicKey, h crypto.Hash, hashed, sig []byte) error {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, h, hashed, sig)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, hashed, sig) {
+			return errors.New("ECDSA verification error")
+		}
+		return nil
+	case *dsa.PublicKey:
+		var rs struct{ R, S *big.Int }
+		if rest, err := asn1.Unmarshal(sig, &rs); err != nil || len(rest) != 0 {
+			return errors.New("malformed DSA signature")
+		}
+		// the hash is truncated to the size of the subgroup, see FIPS 186-3 section 4.6
+		if n := (k.Q.BitLen() + 7) / 8; len(hashed) > n {
+			hashed = hashed[:n]
+		}
+		if !dsa.Verify(k, hashed, rs.R, rs.S) {
+			return errors.New("DSA verification error")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported public key %T", pub)
+}
+
+// checkV1Stripping fails the v1 signers that tell the apk is also signed with an APK
+// Signature Scheme whose block is missing.
+func checkV1Stripping(v *Verification) {
+	for _, s := range v.V1.Signers {
+		for _, id := range s.StrippingProtection {
+			if (id == 2 && v.V2 == nil) || (id == 3 && v.V3 == nil) {
+				s.errorf("signed with APK Signature Scheme v%d, but the v%d block is stripped", id, id)
+			}
+		}
+	}
+	v.V1.done()
+}
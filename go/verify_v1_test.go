@@ -0,0 +1,235 @@
+package _go
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"testing"
+)
+
+// testJar describes a JAR signed apk of testJarSign.
+type testJar struct {
+	key  crypto.Signer
+	cert []byte
+	// signedSchemes is the X-Android-APK-Signed attribute of the signature file.
+	signedSchemes string
+	// attributes signs the signature file with PKCS#7 authenticated attributes.
+	attributes bool
+	// unlisted is an entry left out of the manifest.
+	unlisted string
+	// tampered is an entry whose data differs from its manifest digest.
+	tampered string
+	// badSignature signs other bytes than the signature file.
+	badSignature bool
+}
+
+func testSha256(b []byte) string {
+	d := sha256.Sum256(b)
+	return base64.StdEncoding.EncodeToString(d[:])
+}
+
+// testJarSign makes a JAR signed apk with an empty APK Signing Block, which testSign
+// can replace with signature scheme blocks.
+func testJarSign(t *testing.T, j testJar) []byte {
+	t.Helper()
+	entries := []struct{ name, data string }{
+		{"AndroidManifest.xml", "manifest"},
+		{"classes.dex", "dex"},
+		{"res/", ""},
+		{"res/layout/main.xml", "layout"},
+		{"META-INF/services/a.b.C", "service"},
+	}
+	manifest := "Manifest-Version: 1.0\r\nCreated-By: walle test\r\n\r\n"
+	sf := "Signature-Version: 1.0\r\nCreated-By: walle test\r\n"
+	if j.signedSchemes != "" {
+		sf += jarSignedSchemesAttr + ": " + j.signedSchemes + "\r\n"
+	}
+	sf += "SHA-256-Digest-Manifest-Main-Attributes: " + testSha256([]byte(manifest)) + "\r\n\r\n"
+	for _, e := range entries {
+		if e.name == j.unlisted || !jarEntryNeedsDigest(e.name) {
+			continue
+		}
+		section := fmt.Sprintf("Name: %s\r\nSHA-256-Digest: %s\r\n\r\n", e.name, testSha256([]byte(e.data)))
+		manifest += section
+		sf += fmt.Sprintf("Name: %s\r\nSHA-256-Digest: %s\r\n\r\n", e.name, testSha256([]byte(section)))
+	}
+	signed := []byte(sf)
+	if j.badSignature {
+		signed = append([]byte{}, signed...)
+		signed[0] ^= 0xff
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	write := func(name string, data []byte) {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(jarManifest, []byte(manifest))
+	write("META-INF/CERT.SF", []byte(sf))
+	write("META-INF/CERT.RSA", testPKCS7(t, j.key, j.cert, signed, j.attributes))
+	for _, e := range entries {
+		data := e.data
+		if e.name == j.tampered {
+			data += "!"
+		}
+		write(e.name, []byte(data))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	cdOffset := getEocdCentralDirectoryOffset(b[len(b)-_ZIP_EOCD_REC_MIN_SIZE:])
+	block := testSigningBlock(nil)
+	out := append(append(append([]byte{}, b[:cdOffset]...), block...), b[cdOffset:]...)
+	setEocdCentralDirectoryOffset(out[len(out)-_ZIP_EOCD_REC_MIN_SIZE:], cdOffset+uint32(len(block)))
+	return out
+}
+
+// testPKCS7 makes a detached PKCS#7 SHA-256 signature of content as in JAR signature
+// block files.
+func testPKCS7(t *testing.T, key crypto.Signer, der, content []byte, attributes bool) []byte {
+	t.Helper()
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha256OID := pkcs7AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}}
+	si := pkcs7SignerInfo{
+		Version:                   1,
+		IssuerAndSerialNumber:     pkcs7IssuerAndSerialNumber{asn1.RawValue{FullBytes: cert.RawIssuer}, cert.SerialNumber},
+		DigestAlgorithm:           sha256OID,
+		DigestEncryptionAlgorithm: pkcs7AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}},
+	}
+	signed := content
+	if attributes {
+		set := func(v interface{}) asn1.RawValue {
+			b, err := asn1.Marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: b}
+		}
+		digest := sha256.Sum256(content)
+		attrs, err := asn1.MarshalWithParams([]pkcs7Attribute{
+			{oidContentType, set(oidData)},
+			{oidMessageDigest, set(digest[:])},
+		}, "set")
+		if err != nil {
+			t.Fatal(err)
+		}
+		si.AuthenticatedAttributes = asn1.RawValue{FullBytes: append([]byte{0xa0}, attrs[1:]...)}
+		signed = attrs
+	}
+	h := sha256.Sum256(signed)
+	if si.EncryptedDigest, err = key.Sign(rand.Reader, h[:], crypto.SHA256); err != nil {
+		t.Fatal(err)
+	}
+	alg, err := asn1.Marshal(sha256OID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: []asn1.RawValue{{FullBytes: alg}},
+		ContentInfo:      pkcs7ContentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der},
+		SignerInfos:      []pkcs7SignerInfo{si},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := asn1.Marshal(pkcs7ContentInfo{oidSignedData,
+		asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestVerify_v1(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaCert, ecCert := testCert(t, rsaKey), testCert(t, ecKey)
+	jar := func(j testJar) []byte {
+		if j.key == nil {
+			j.key, j.cert = rsaKey, rsaCert
+		}
+		return testJarSign(t, j)
+	}
+	v2 := func(b []byte) []byte {
+		return testSignV2(t, b, SIGNATURE_ECDSA_WITH_SHA256, ecKey, ecCert)
+	}
+
+	tests := []struct {
+		name string
+		apk  []byte
+		want bool
+	}{
+		{"RSA", jar(testJar{}), true},
+		{"ECDSA with attributes", jar(testJar{key: ecKey, cert: ecCert, attributes: true}), true},
+		{"tampered entry", jar(testJar{tampered: "classes.dex"}), false},
+		{"entry not in manifest", jar(testJar{unlisted: "META-INF/services/a.b.C"}), false},
+		{"bad signature", jar(testJar{badSignature: true}), false},
+		{"bad signature with attributes", jar(testJar{badSignature: true, attributes: true}), false},
+		{"v2 stripped", jar(testJar{signedSchemes: "2"}), false},
+		{"v1 and v2", v2(jar(testJar{signedSchemes: "2"})), true},
+		{"v3 stripped", v2(jar(testJar{signedSchemes: "2, 3"})), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Verify(bytes.NewReader(tt.apk), int64(len(tt.apk)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Verified != tt.want {
+				t.Fatalf("Verified = %v, want %v: %s", res.Verified, tt.want, res.firstError())
+			}
+			if !tt.want {
+				return
+			}
+			scheme, signers := res.SignersFor(21)
+			if scheme != "v1" || len(signers) != 1 || signers[0].SignatureFile != "META-INF/CERT.SF" ||
+				len(signers[0].Certificates) != 1 {
+				t.Errorf("SDK 21 got %d signers of %s", len(signers), scheme)
+			}
+		})
+	}
+}
+
+func TestParseJarSections(t *testing.T) {
+	main, sections, err := parseJarSections([]byte("Manifest-Version: 1.0\n\nName: a/very/long\n /name\rSHA-256-Digest: x\r\n\r\nName: b\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if main.attrs["manifest-version"] != "1.0" || string(main.raw) != "Manifest-Version: 1.0\n\n" {
+		t.Errorf("got main section %+v", main)
+	}
+	if len(sections) != 2 || sections[0].name() != "a/very/long/name" || sections[0].attrs["sha-256-digest"] != "x" ||
+		string(sections[0].raw) != "Name: a/very/long\n /name\rSHA-256-Digest: x\r\n\r\n" || sections[1].name() != "b" {
+		t.Errorf("got sections %+v", sections)
+	}
+	if _, _, err := parseJarSections([]byte("Name: a\nName: b\n")); err == nil {
+		t.Errorf("duplicate attribute parses")
+	}
+}
@@ -0,0 +1,17 @@
+package _go
+
+// STRIPPING_PROTECTION_ATTR_ID is the additional attribute of v2 signers that tells
+// the apk is also signed with the signature scheme in its uint32 value, so the v3
+// block cannot be stripped to downgrade the apk to v2.
+const STRIPPING_PROTECTION_ATTR_ID = 0xbeeff00d
+
+// verifyV2 verifies the value of the v2 block, hasV3 tells whether the apk has a v3
+// block for the stripping protection.
+func verifyV2(value []byte, hasV3 bool, digester *contentDigester) *SchemeVerification {
+	return verifySigners(value, false, digester, func(s *signer, res *SignerVerification) {
+		if v, ok := s.attribute(STRIPPING_PROTECTION_ATTR_ID); ok && len(v) >= 4 &&
+			getUint32(v, 0) == 3 && !hasV3 {
+			res.errorf("signed with APK Signature Scheme v3, but the v3 block is stripped")
+		}
+	})
+}
@@ -0,0 +1,248 @@
+package _go
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Additional attributes of v3 signers.
+// See https://android.googlesource.com/platform/tools/apksig/+/master/src/main/java/com/android/apksig/internal/apk/v3/V3SchemeConstants.java
+const (
+	PROOF_OF_ROTATION_ATTR_ID        = 0x3ba06f8c
+	ROTATION_MIN_SDK_VERSION_ATTR_ID = 0x559f8b02
+	ROTATION_ON_DEV_RELEASE_ATTR_ID  = 0xc2a6b3ba
+)
+
+// First SDK versions of the signature schemes.
+const (
+	SDK_VERSION_V2  = 24
+	SDK_VERSION_V3  = 28
+	SDK_VERSION_V31 = 33
+)
+
+// LineageFlags are the capabilities granted to a past signing certificate by the keys
+// rotated after it.
+type LineageFlags uint32
+
+const (
+	LINEAGE_INSTALLED_DATA LineageFlags = 1 << iota
+	LINEAGE_SHARED_USER_ID
+	LINEAGE_PERMISSION
+	LINEAGE_ROLLBACK
+	LINEAGE_AUTH
+)
+
+var lineageFlagNames = []string{"installed-data", "shared-uid", "permission", "rollback", "auth"}
+
+func (f LineageFlags) String() string {
+	var names []string
+	for i, name := range lineageFlagNames {
+		if f&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	if rest := f &^ (1<<uint(len(lineageFlagNames)) - 1); rest != 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint32(rest)))
+	}
+	return strings.Join(names, "|")
+}
+
+func (f LineageFlags) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// LineageNode is a signing certificate in the proof-of-rotation lineage of a v3 signer,
+// from the oldest one to the current one.
+type LineageNode struct {
+	Certificate *x509.Certificate `json:"-"`
+	Flags       LineageFlags      `json:"flags"`
+	// SignedBy is the algorithm of the previous certificate's signature over this node,
+	// 0 for the first node.
+	SignedBy SignatureAlgorithm `json:"signedBy,omitempty"`
+}
+
+// lineageVersion is the only supported version of the proof-of-rotation structure.
+const lineageVersion = 1
+
+// parseLineage decodes and verifies a proof-of-rotation lineage:
+//
+//	uint32 version
+//	repeated length-prefixed nodes:
+//	    length-prefixed signed data:
+//	        length-prefixed X.509 certificate
+//	        uint32 signature algorithm of the previous node
+//	    uint32 flags
+//	    uint32 signature algorithm of this node's key over the next node
+//	    length-prefixed signature by the previous node's key
+//
+// See https://android.googlesource.com/platform/tools/apksig/+/master/src/main/java/com/android/apksig/internal/apk/v3/V3SigningCertificateLineage.java
+func parseLineage(b []byte) ([]*LineageNode, error) {
+	if len(b) < 4 {
+		return nil, errors.New("no version")
+	}
+	if v := getUint32(b, 0); v != lineageVersion {
+		return nil, fmt.Errorf("unsupported version %d", v)
+	}
+	b = b[4:]
+	var (
+		nodes    []*LineageNode
+		last     *x509.Certificate
+		lastAlgo SignatureAlgorithm
+	)
+	for i := 0; len(b) > 0; i++ {
+		node, rest, err := readLengthPrefixed(b)
+		if err != nil {
+			return nil, fmt.Errorf("node #%d: %s", i, err)
+		}
+		b = rest
+		signedData, rest, err := readLengthPrefixed(node)
+		if err != nil || len(rest) < 8 {
+			return nil, fmt.Errorf("node #%d is malformed", i)
+		}
+		flags, algo := LineageFlags(getUint32(rest, 0)), SignatureAlgorithm(getUint32(rest, 4))
+		sig, _, err := readLengthPrefixed(rest[8:])
+		if err != nil {
+			return nil, fmt.Errorf("node #%d signature: %s", i, err)
+		}
+		der, rest, err := readLengthPrefixed(signedData)
+		if err != nil || len(rest) < 4 {
+			return nil, fmt.Errorf("node #%d signed data is malformed", i)
+		}
+		signedAlgo := SignatureAlgorithm(getUint32(rest, 0))
+		if last != nil {
+			if signedAlgo != lastAlgo {
+				return nil, fmt.Errorf("node #%d is signed with %s, but node #%d signs with %s", i, signedAlgo, i-1, lastAlgo)
+			}
+			if err := lastAlgo.verify(last.PublicKey, signedData, sig); err != nil {
+				return nil, fmt.Errorf("node #%d signature does not verify: %s", i, err)
+			}
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("node #%d certificate: %s", i, err)
+		}
+		for _, n := range nodes {
+			if n.Certificate.Equal(cert) {
+				return nil, fmt.Errorf("node #%d is a duplicate certificate", i)
+			}
+		}
+		n := &LineageNode{Certificate: cert, Flags: flags}
+		if last != nil {
+			n.SignedBy = signedAlgo
+		}
+		nodes = append(nodes, n)
+		last, lastAlgo = cert, algo
+	}
+	if len(nodes) == 0 {
+		return nil, errors.New("no nodes")
+	}
+	return nodes, nil
+}
+
+// verifyV3 verifies the value of a v3 or v3.1 block, the SDK version ranges of its
+// signers must not overlap and the lineage of a signer must end with its certificate.
+func verifyV3(value []byte, digester *contentDigester) *SchemeVerification {
+	var signers []*signer
+	res := verifySigners(value, true, digester, func(s *signer, sv *SignerVerification) {
+		sv.MinSDK, sv.MaxSDK = int(s.minSDK), int(s.maxSDK)
+		if s.minSDK != s.signedMinSDK || s.maxSDK != s.signedMaxSDK {
+			sv.errorf("SDK versions %d-%d do not match the signed %d-%d", s.minSDK, s.maxSDK, s.signedMinSDK, s.signedMaxSDK)
+			return
+		}
+		if s.minSDK > s.maxSDK {
+			sv.errorf("min SDK version %d is above max SDK version %d", s.minSDK, s.maxSDK)
+			return
+		}
+		if v, ok := s.attribute(PROOF_OF_ROTATION_ATTR_ID); ok {
+			lineage, err := parseLineage(v)
+			if err != nil {
+				sv.errorf("malformed proof-of-rotation: %s", err)
+				return
+			}
+			if !bytes.Equal(lineage[len(lineage)-1].Certificate.Raw, sv.Certificates[0].Raw) {
+				sv.errorf("proof-of-rotation does not end with the signer certificate")
+				return
+			}
+			sv.Lineage = lineage
+		}
+		if v, ok := s.attribute(ROTATION_MIN_SDK_VERSION_ATTR_ID); ok && len(v) >= 4 {
+			sv.RotationMinSDK = int(getUint32(v, 0))
+		}
+		if _, ok := s.attribute(ROTATION_ON_DEV_RELEASE_ATTR_ID); ok {
+			sv.RotationOnDevRelease = true
+		}
+		signers = append(signers, s)
+	})
+
+	sort.Slice(signers, func(i, j int) bool { return signers[i].minSDK < signers[j].minSDK })
+	for i := 1; i < len(signers); i++ {
+		if signers[i].minSDK <= signers[i-1].maxSDK {
+			res.errorf("signers for SDK versions %d-%d and %d-%d overlap",
+				signers[i-1].minSDK, signers[i-1].maxSDK, signers[i].minSDK, signers[i].maxSDK)
+		}
+	}
+	return res.done()
+}
+
+// checkV31 checks the v3.1 block against the v3 one: the v3 block is required for the
+// platforms before v3.1, and its signers tell the min SDK version of the v3.1 signers.
+func checkV31(v3, v31 *SchemeVerification) {
+	if v3 == nil {
+		v31.errorf("v3.1 block without v3 block")
+		v31.done()
+		return
+	}
+	min := -1
+	for _, s := range v31.Signers {
+		if s.Verified && (min < 0 || s.MinSDK < min) {
+			min = s.MinSDK
+		}
+	}
+	for _, s := range v3.Signers {
+		if s.Verified && min >= 0 && s.RotationMinSDK != min {
+			v31.errorf("v3 signer tells rotation min SDK version %d, but v3.1 signers start at %d", s.RotationMinSDK, min)
+		}
+	}
+	v31.done()
+}
+
+// SignersFor returns the signature scheme and its signers that Android of sdk version
+// uses to verify the apk: the v3.1 or v3 signer whose SDK range has sdk on Android 9
+// and later, otherwise the v2 signers on Android 7 and later, otherwise the v1 signers.
+// Signers is empty if the scheme has no verified signer for sdk, which fails the
+// installation.
+func (v *Verification) SignersFor(sdk int) (scheme string, signers []*SignerVerification) {
+	find := func(s *SchemeVerification) []*SignerVerification {
+		for _, signer := range s.Signers {
+			if signer.Verified && signer.MinSDK <= sdk && sdk <= signer.MaxSDK {
+				return []*SignerVerification{signer}
+			}
+		}
+		return nil
+	}
+	if sdk >= SDK_VERSION_V31 && v.V31 != nil {
+		if signers := find(v.V31); len(signers) > 0 {
+			return "v3.1", signers
+		}
+	}
+	if sdk >= SDK_VERSION_V3 && v.V3 != nil {
+		return "v3", find(v.V3)
+	}
+	if sdk >= SDK_VERSION_V2 && v.V2 != nil {
+		if v.V2.Verified {
+			return "v2", v.V2.Signers
+		}
+		return "v2", nil
+	}
+	if v.V1 != nil {
+		if v.V1.Verified {
+			return "v1", v.V1.Signers
+		}
+		return "v1", nil
+	}
+	return "", nil
+}
@@ -0,0 +1,144 @@
+package _go
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testLineageNode is a node of testLineage, alg is the algorithm of key over the
+// next node.
+type testLineageNode struct {
+	key   crypto.Signer
+	cert  []byte
+	alg   SignatureAlgorithm
+	flags LineageFlags
+}
+
+// testLineage encodes a proof-of-rotation lineage where every node is signed by the
+// key of the previous one, see parseLineage.
+func testLineage(t *testing.T, nodes ...testLineageNode) []byte {
+	t.Helper()
+	b := uint32s(lineageVersion)
+	for i, n := range nodes {
+		var prevAlg SignatureAlgorithm
+		var sig []byte
+		signedData := lengthPrefixed(n.cert)
+		if i > 0 {
+			prevAlg = nodes[i-1].alg
+		}
+		signedData = append(signedData, uint32s(uint32(prevAlg))...)
+		if i > 0 {
+			sig = testSignature(t, prevAlg, nodes[i-1].key, signedData)
+		}
+		node := append(lengthPrefixed(signedData), uint32s(uint32(n.flags), uint32(n.alg))...)
+		b = append(b, lengthPrefixed(append(node, lengthPrefixed(sig)...))...)
+	}
+	return b
+}
+
+func TestVerify_v3(t *testing.T) {
+	var keys [3]*ecdsa.PrivateKey
+	var certs [3][]byte
+	for i := range keys {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i], certs[i] = k, testCert(t, k)
+	}
+	const alg, maxSDK = SIGNATURE_ECDSA_WITH_SHA256, 0x7fffffff
+	allFlags := LINEAGE_INSTALLED_DATA | LINEAGE_SHARED_USER_ID | LINEAGE_PERMISSION | LINEAGE_AUTH
+	lineage := testLineage(t,
+		testLineageNode{keys[0], certs[0], alg, allFlags},
+		testLineageNode{keys[1], certs[1], alg, allFlags})
+	unsigned := readTestApk(t, newTestApk(t, t.TempDir()))
+	v2 := func(attrs ...[]byte) testBlock {
+		return testBlock{APK_SIGNATURE_SCHEME_V2_BLOCK_ID, []testSigner{{alg: alg, key: keys[0], cert: certs[0], attrs: attrs}}}
+	}
+	stripping := append(uint32s(STRIPPING_PROTECTION_ATTR_ID), uint32s(3)...)
+	rotated := testSigner{alg: alg, key: keys[1], cert: certs[1], minSDK: 28, maxSDK: maxSDK,
+		attrs: [][]byte{append(uint32s(PROOF_OF_ROTATION_ATTR_ID), lineage...)}}
+	v3 := testBlock{APK_SIGNATURE_SCHEME_V3_BLOCK_ID, []testSigner{rotated}}
+
+	tests := []struct {
+		name string
+		apk  []byte
+		want bool
+		// wantCerts are the indexes of the certificates presented to SDK 27, 30 and 34
+		wantCerts [3]int
+	}{
+		{"v2 and v3 with lineage", testSign(t, unsigned, v2(stripping), v3), true, [3]int{0, 1, 1}},
+		{"v3 stripped", testSign(t, unsigned, v2(stripping)), false, [3]int{}},
+		{"lineage of other signer", testSign(t, unsigned, v2(), testBlock{APK_SIGNATURE_SCHEME_V3_BLOCK_ID, []testSigner{
+			{alg: alg, key: keys[2], cert: certs[2], minSDK: 28, maxSDK: maxSDK, attrs: rotated.attrs},
+		}}), false, [3]int{}},
+		{"lineage not signed by previous key", testSign(t, unsigned, v2(), testBlock{APK_SIGNATURE_SCHEME_V3_BLOCK_ID, []testSigner{
+			{alg: alg, key: keys[1], cert: certs[1], minSDK: 28, maxSDK: maxSDK, attrs: [][]byte{append(uint32s(PROOF_OF_ROTATION_ATTR_ID),
+				testLineage(t, testLineageNode{keys[2], certs[0], alg, 0}, testLineageNode{keys[1], certs[1], alg, 0})...)}},
+		}}), false, [3]int{}},
+		{"overlapping SDK versions", testSign(t, unsigned, v2(), testBlock{APK_SIGNATURE_SCHEME_V3_BLOCK_ID, []testSigner{
+			{alg: alg, key: keys[0], cert: certs[0], minSDK: 24, maxSDK: 30},
+			rotated,
+		}}), false, [3]int{}},
+		{"v3.1", testSign(t, unsigned, v2(stripping),
+			testBlock{APK_SIGNATURE_SCHEME_V3_BLOCK_ID, []testSigner{{alg: alg, key: keys[0], cert: certs[0], minSDK: 24, maxSDK: maxSDK,
+				attrs: [][]byte{append(uint32s(ROTATION_MIN_SDK_VERSION_ATTR_ID), uint32s(33)...)}}}},
+			testBlock{APK_SIGNATURE_SCHEME_V31_BLOCK_ID, []testSigner{{alg: alg, key: keys[1], cert: certs[1], minSDK: 33, maxSDK: maxSDK,
+				attrs: rotated.attrs}}}), true, [3]int{0, 0, 1}},
+		{"v3.1 without rotation min SDK", testSign(t, unsigned, v2(stripping),
+			testBlock{APK_SIGNATURE_SCHEME_V3_BLOCK_ID, []testSigner{{alg: alg, key: keys[0], cert: certs[0], minSDK: 24, maxSDK: maxSDK}}},
+			testBlock{APK_SIGNATURE_SCHEME_V31_BLOCK_ID, []testSigner{{alg: alg, key: keys[1], cert: certs[1], minSDK: 33, maxSDK: maxSDK,
+				attrs: rotated.attrs}}}), false, [3]int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Verify(bytes.NewReader(tt.apk), int64(len(tt.apk)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Verified != tt.want {
+				t.Fatalf("Verified = %v, want %v: %s", res.Verified, tt.want, res.firstError())
+			}
+			if !tt.want {
+				return
+			}
+			for i, sdk := range []int{27, 30, 34} {
+				scheme, signers := res.SignersFor(sdk)
+				if len(signers) != 1 || !bytes.Equal(signers[0].Certificates[0].Raw, certs[tt.wantCerts[i]]) {
+					t.Errorf("SDK %d got %d signers of %s, want certificate #%d", sdk, len(signers), scheme, tt.wantCerts[i])
+				}
+			}
+		})
+	}
+
+	t.Run("channel keeps lineage", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "signed.apk")
+		if err := os.WriteFile(path, testSign(t, unsigned, v2(stripping), v3), 0644); err != nil {
+			t.Fatal(err)
+		}
+		a, err := NewApk(path, WithVerify())
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := a.PutChannel("xiaomi", filepath.Join(dir, "out.apk"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := VerifyFile(out.Path())
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := res.V3.Signers[0]
+		if len(s.Lineage) != 2 || s.Lineage[0].Flags != allFlags || s.Lineage[1].SignedBy != alg ||
+			s.MinSDK != 28 || s.MaxSDK != maxSDK {
+			t.Errorf("got v3 signer %+v", s)
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package _go
+
+import (
+	"io"
+)
+
+// VirtualApk is a channel apk served on demand from the base apk and a small in-memory
+// tail: base prefix, new signing block, central directory and patched EOCD. Nothing is
+// written to disk, it can be streamed or served directly, e.g. with http.ServeContent.
+//
+// The base apk file is kept open until Close is called.
+type VirtualApk struct {
+	*io.SectionReader
+	info   channelInfo
+	closer io.Closer
+}
+
+// Virtual returns the apk that PutChannelWithExtra would generate, without writing it.
+func (a *apk) Virtual(channel string, extras map[string]string) (*VirtualApk, error) {
+	z, closer, err := a.open()
+	if err != nil {
+		return nil, newErrf("Error occurred on parsing apk %s, %s", a.path, err)
+	}
+	v, err := newVirtualApk(z, channelInfo{channel: channel, extras: extras})
+	if err != nil {
+		closer.Close()
+		return nil, newErrf("Error occurred on generating channel %s, %s", channel, err)
+	}
+	v.closer = closer
+	return v, nil
+}
+
+func newVirtualApk(z zipSections, info channelInfo) (*VirtualApk, error) {
+	newZip, err := newTransform(info)(&z)
+	if err != nil {
+		return nil, err
+	}
+	r, size := newZip.readerAt()
+	return &VirtualApk{
+		SectionReader: io.NewSectionReader(r, 0, size),
+		info:          info,
+		closer:        nopCloser{},
+	}, nil
+}
+
+func (v *VirtualApk) Channel() string {
+	return v.info.channel
+}
+
+func (v *VirtualApk) Extras() map[string]string {
+	return v.info.extras
+}
+
+// Close closes the base apk if it was opened by Virtual.
+func (v *VirtualApk) Close() error {
+	return v.closer.Close()
+}
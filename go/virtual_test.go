@@ -0,0 +1,94 @@
+package _go
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApk_Virtual(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewApk(newTestApk(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	extras := map[string]string{"a": "1", "b": "2", "c": "3"}
+	out, err := a.PutChannelWithExtra("xiaomi", extras, filepath.Join(dir, "out.apk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(out.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := a.Virtual("xiaomi", extras)
+	if err != nil {
+		t.Fatalf("Virtual() error = %v", err)
+	}
+	defer v.Close()
+	if v.Size() != int64(len(want)) {
+		t.Fatalf("Virtual() size = %d, want %d", v.Size(), len(want))
+	}
+	got, err := io.ReadAll(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Virtual() content differs from PutChannelWithExtra()")
+	}
+
+	// reads across section boundaries
+	z, err := newZipSections(a.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, off := range []int64{0, z.signingBlockOffset - 3, z.centralDirOffset - 5, int64(len(want)) - 30} {
+		buf := make([]byte, 64)
+		n, err := v.ReadAt(buf, off)
+		end := off + int64(len(buf))
+		if end > int64(len(want)) {
+			end = int64(len(want))
+		}
+		if !bytes.Equal(buf[:n], want[off:end]) || (n < len(buf)) != (err == io.EOF) {
+			t.Errorf("ReadAt(%d) = %d, %v", off, n, err)
+		}
+	}
+	if _, err := v.Seek(-22, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	eocd, err := io.ReadAll(v)
+	if err != nil || !bytes.Equal(eocd, want[len(want)-22:]) {
+		t.Errorf("Seek() then Read() got %x, %v", eocd, err)
+	}
+
+	ch, err := NewApkFromReaderAt(v, v.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch.Channel() != "xiaomi" || len(ch.Extras()) != 3 {
+		t.Errorf("NewApkFromReaderAt(Virtual()) got %v", ch.All())
+	}
+}
+
+func TestApk_VirtualChannelExtra(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewApk(newTestApk(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := a.Virtual("xiaomi", map[string]string{"channel": "evil", "k": "v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	info, err := readInfoAt(v, v.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.channel != "xiaomi" || info.extras["k"] != "v" {
+		t.Errorf("channel extra overrides the channel: %s", info.raw)
+	}
+}
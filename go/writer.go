@@ -1,12 +1,18 @@
 package _go
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 )
 
 type zipSections struct {
-	beforeSigningBlock []byte
+	// source holds the bytes before signing block, which are never changed and may be
+	// too large to keep in memory.
+	source             io.ReaderAt
 	signingBlock       []byte
 	signingBlockOffset int64
 	centraDir          []byte
@@ -16,45 +22,94 @@ type zipSections struct {
 }
 type transform func(*zipSections) (*zipSections, error)
 
+// writeTo writes the transformed zip to a temporary file next to output, which is
+// renamed to output on success, so output may be the apk z is read from.
 func (z *zipSections) writeTo(output string, transform transform) (err error) {
-	f, err := os.Create(output)
+	newZip, err := transform(z)
 	if err != nil {
 		return
 	}
 
-	defer f.Close()
-
-	newZip, err := transform(z)
+	f, err := os.CreateTemp(filepath.Dir(output), ".walle-*.apk")
 	if err != nil {
 		return
 	}
-
-	for _, s := range [][]byte{
-		newZip.beforeSigningBlock,
-		newZip.signingBlock,
-		newZip.centraDir,
-		newZip.eocd,
-	} {
-		_, err := f.Write(s)
+	defer func() {
 		if err != nil {
-			return err
+			tempFile{f}.Close()
 		}
+	}()
+	if err = f.Chmod(0644); err != nil {
+		return
 	}
-	return
+
+	r, size := newZip.readerAt()
+	if _, err = io.Copy(f, io.NewSectionReader(r, 0, size)); err != nil {
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+	return os.Rename(f.Name(), output)
+}
+
+// beforeSigningBlock reads the bytes before signing block from source.
+func (z *zipSections) beforeSigningBlock() *io.SectionReader {
+	return io.NewSectionReader(z.source, 0, z.signingBlockOffset)
+}
+
+// readerAt returns the whole zip as a concatenation of its sections.
+func (z *zipSections) readerAt() (io.ReaderAt, int64) {
+	r := newMultiReaderAt(
+		z.beforeSigningBlock(),
+		bytes.NewReader(z.signingBlock),
+		bytes.NewReader(z.centraDir),
+		bytes.NewReader(z.eocd),
+	)
+	return r, r.Size()
 }
 
+// newZipSections reads the sections of input, bytes before signing block are loaded
+// into memory since the file is closed on return.
 func newZipSections(input string) (z zipSections, err error) {
-	in, err := os.Open(input)
+	ret, err := openFile(input, func(f *os.File) (interface{}, error) {
+		size, err := fileSize(f)
+		if err != nil {
+			return nil, err
+		}
+		z, err := newZipSectionsAt(f, size)
+		if err != nil {
+			return nil, err
+		}
+		// TODO: waste too large memory
+		if z.signingBlockOffset >= maxSigningBlockOffset {
+			fmt.Print("Warning: maybe waste large memory on processing this apk! ")
+			fmt.Println("Before APK Signing Block bytes size is", z.signingBlockOffset/1024/1024, "MB")
+		}
+		beforeSigningBlock := make([]byte, z.signingBlockOffset)
+		if err := readFullAt(f, beforeSigningBlock, 0); err != nil {
+			return nil, err
+		}
+		z.source = bytes.NewReader(beforeSigningBlock)
+		return z, nil
+	})
 	if err != nil {
 		return
 	}
-	defer in.Close()
+	return ret.(zipSections), nil
+}
 
+// newZipSectionsAt reads the sections of in, bytes before signing block are read from
+// in when needed, so in must be kept open while the sections are used.
+func newZipSectionsAt(in io.ReaderAt, size int64) (z zipSections, err error) {
 	// read eocd
-	eocd, eocdOffset, err := findEndOfCentralDirectoryRecord(in)
+	eocd, eocdOffset, err := findEndOfCentralDirectoryRecord(in, size)
 	if err != nil {
 		return
 	}
+	if eocdOffset <= 0 {
+		return z, errors.New("Cannot find EOCD record, maybe a broken zip file.")
+	}
 	centralDirOffset := getEocdCentralDirectoryOffset(eocd)
 	centralDirSize := getEocdCentralDirectorySize(eocd)
 	z.eocd = eocd
@@ -68,26 +123,11 @@ func newZipSections(input string) (z zipSections, err error) {
 	}
 	z.signingBlock = signingBlock
 	z.signingBlockOffset = signingBlockOffset
-	// read bytes before signing block
-	// TODO: waste too large memory
-	if signingBlockOffset >= maxSigningBlockOffset {
-		fmt.Print("Warning: maybe waste large memory on processing this apk! ")
-		fmt.Println("Before APK Signing Block bytes size is", signingBlockOffset/1024/1024, "MB")
-	}
-	beforeSigningBlock := make([]byte, signingBlockOffset)
-	n, err := in.ReadAt(beforeSigningBlock, 0)
-	if err != nil {
-		return
-	}
-	if int64(n) != signingBlockOffset {
-		return z, fmt.Errorf("Read bytes count mismatched! Expect %d, but %d", signingBlockOffset, n)
-	}
-	z.beforeSigningBlock = beforeSigningBlock
+	z.source = in
 
 	centralDir := make([]byte, centralDirSize)
-	n, err = in.ReadAt(centralDir, int64(centralDirOffset))
-	if uint32(n) != centralDirSize {
-		return z, fmt.Errorf("Read bytes count mismatched! Expect %d, but %d", centralDirSize, n)
+	if err = readFullAt(in, centralDir, int64(centralDirOffset)); err != nil {
+		return
 	}
 	z.centraDir = centralDir
 	return
@@ -108,7 +148,7 @@ func newTransform(info channelInfo) transform {
 			return nil, err
 		}
 		newzip := new(zipSections)
-		newzip.beforeSigningBlock = zip.beforeSigningBlock
+		newzip.source = zip.source
 		newzip.signingBlock = newBlock
 		newzip.signingBlockOffset = zip.signingBlockOffset
 		newzip.centraDir = zip.centraDir